@@ -3,8 +3,12 @@ module github.com/LeHTVy/h_ai
 go 1.21
 
 require (
+	github.com/creack/pty v1.1.21
 	github.com/gin-gonic/gin v1.9.1
+	github.com/gorilla/websocket v1.5.0
+	go.etcd.io/bbolt v1.3.8
 	go.uber.org/zap v1.26.0
+	golang.org/x/sys v0.8.0
 )
 
 require (
@@ -29,7 +33,6 @@ require (
 	golang.org/x/arch v0.3.0 // indirect
 	golang.org/x/crypto v0.9.0 // indirect
 	golang.org/x/net v0.10.0 // indirect
-	golang.org/x/sys v0.8.0 // indirect
 	golang.org/x/text v0.9.0 // indirect
 	google.golang.org/protobuf v1.30.0 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
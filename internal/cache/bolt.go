@@ -0,0 +1,112 @@
+package cache
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var resultsBucket = []byte("results")
+
+// BoltBackend keeps entries in a bbolt database so they survive restarts. Each value is
+// the expiry in unix nanoseconds followed by the serialized entry.
+type BoltBackend struct {
+	db *bolt.DB
+}
+
+// OpenBolt opens or creates the cache database at path
+func OpenBolt(path string) (*BoltBackend, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, fmt.Errorf("failed to create cache directory: %w", err)
+	}
+
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open cache database: %w", err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(resultsBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to initialize cache database: %w", err)
+	}
+
+	return &BoltBackend{db: db}, nil
+}
+
+func (b *BoltBackend) Name() string {
+	return "bolt"
+}
+
+func (b *BoltBackend) Get(key string) (Entry, bool) {
+	var entry Entry
+	var found bool
+	b.db.View(func(tx *bolt.Tx) error {
+		entry, found = decodeEntry(tx.Bucket(resultsBucket).Get([]byte(key)))
+		return nil
+	})
+	return entry, found
+}
+
+func (b *BoltBackend) Set(key string, entry Entry) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(resultsBucket).Put([]byte(key), encodeEntry(entry))
+	})
+}
+
+func (b *BoltBackend) Delete(key string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(resultsBucket).Delete([]byte(key))
+	})
+}
+
+func (b *BoltBackend) Clear() error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.DeleteBucket(resultsBucket); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucket(resultsBucket)
+		return err
+	})
+}
+
+func (b *BoltBackend) Range(fn func(key string, entry Entry)) {
+	b.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(resultsBucket).ForEach(func(k, v []byte) error {
+			if len(v) >= 8 {
+				// The value is only valid inside the transaction, as Range documents
+				fn(string(k), Entry{Value: v[8:], Expires: time.Unix(0, int64(binary.BigEndian.Uint64(v)))})
+			}
+			return nil
+		})
+	})
+}
+
+func (b *BoltBackend) Close() error {
+	return b.db.Close()
+}
+
+func encodeEntry(entry Entry) []byte {
+	data := make([]byte, 8+len(entry.Value))
+	binary.BigEndian.PutUint64(data, uint64(entry.Expires.UnixNano()))
+	copy(data[8:], entry.Value)
+	return data
+}
+
+// decodeEntry copies the entry out of data, which is only valid inside the transaction
+func decodeEntry(data []byte) (Entry, bool) {
+	if len(data) < 8 {
+		return Entry{}, false
+	}
+	return Entry{
+		Value:   append([]byte(nil), data[8:]...),
+		Expires: time.Unix(0, int64(binary.BigEndian.Uint64(data))),
+	}, true
+}
@@ -1,95 +1,265 @@
 package cache
 
 import (
+	"container/list"
+	"encoding/json"
+	"errors"
 	"sync"
 	"time"
 )
 
-type item struct {
-	value      interface{}
-	expiration int64
+// ErrTooLarge is returned when a value alone is larger than the cache
+var ErrTooLarge = errors.New("value exceeds the cache size limit")
+
+// Entry is a serialized value with the time it expires
+type Entry struct {
+	Value   []byte
+	Expires time.Time
+}
+
+func (e Entry) expired(now time.Time) bool {
+	return now.After(e.Expires)
 }
 
+// Backend stores cache entries. Expiry, size accounting and eviction are left to the
+// Cache, backends return entries whether they expired or not.
+type Backend interface {
+	// Name identifies the backend in the stats
+	Name() string
+	Get(key string) (Entry, bool)
+	Set(key string, entry Entry) error
+	Delete(key string) error
+	Clear() error
+	// Range calls fn for every stored entry, the entry is only valid during the call
+	Range(fn func(key string, entry Entry))
+	Close() error
+}
+
+// lruItem is what the cache remembers about a stored entry to evict it
+type lruItem struct {
+	key     string
+	size    int64
+	expires time.Time
+}
+
+// Cache keeps values with a TTL in a backend, values are stored as JSON. When the
+// stored values grow past the size limit the least recently used ones are evicted.
 type Cache struct {
-	items    map[string]*item
-	mu       sync.RWMutex
-	cleanup  *time.Ticker
+	backend    Backend
+	cleanup    *time.Ticker
+	done       chan struct{}
 	defaultTTL time.Duration
+	maxBytes   int64
+
+	closeOnce sync.Once
+	closeErr  error
+
+	mu sync.Mutex
+	// lru holds the entries, most recently used first
+	lru   *list.List
+	index map[string]*list.Element
+	bytes int64
+
+	hits        uint64
+	misses      uint64
+	evictions   uint64
+	expirations uint64
 }
 
-func New(defaultTTL, cleanupInterval time.Duration) *Cache {
+// New creates a cache on backend holding at most maxBytes of serialized values, 0 means
+// unlimited. Expired entries are swept every cleanupInterval.
+func New(backend Backend, maxBytes int64, defaultTTL, cleanupInterval time.Duration) *Cache {
 	c := &Cache{
-		items:      make(map[string]*item),
+		backend:    backend,
 		defaultTTL: defaultTTL,
-		cleanup:     time.NewTicker(cleanupInterval),
+		maxBytes:   maxBytes,
+		cleanup:    time.NewTicker(cleanupInterval),
+		done:       make(chan struct{}),
+		lru:        list.New(),
+		index:      make(map[string]*list.Element),
 	}
 
+	// A persistent backend may already hold entries, their use order is not known
+	backend.Range(func(key string, entry Entry) {
+		c.index[key] = c.lru.PushBack(&lruItem{key: key, size: int64(len(entry.Value)), expires: entry.Expires})
+		c.bytes += int64(len(entry.Value))
+	})
+	c.mu.Lock()
+	c.evict()
+	c.mu.Unlock()
+
 	go c.startCleanup()
 	return c
 }
 
-func (c *Cache) Get(key string) (interface{}, bool) {
-	c.mu.RLock()
-	defer c.mu.RUnlock()
+// Get decodes the value cached under key into out and reports whether there was one
+func (c *Cache) Get(key string, out interface{}) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 
-	item, found := c.items[key]
+	elem, found := c.index[key]
 	if !found {
-		return nil, false
+		c.misses++
+		return false
 	}
-
-	if time.Now().UnixNano() > item.expiration {
-		delete(c.items, key)
-		return nil, false
+	if elem.Value.(*lruItem).expires.Before(time.Now()) {
+		c.remove(elem)
+		c.expirations++
+		c.misses++
+		return false
 	}
 
-	return item.value, true
+	entry, found := c.backend.Get(key)
+	if !found || json.Unmarshal(entry.Value, out) != nil {
+		// Lost by the backend or written by an incompatible version
+		c.remove(elem)
+		c.misses++
+		return false
+	}
+	c.lru.MoveToFront(elem)
+	c.hits++
+	return true
 }
 
-func (c *Cache) Set(key string, value interface{}, ttl time.Duration) {
+// Has reports whether a value that has not expired is cached under key. It does not
+// count as a use of the value.
+func (c *Cache) Has(key string) bool {
 	c.mu.Lock()
 	defer c.mu.Unlock()
+	elem, found := c.index[key]
+	return found && !elem.Value.(*lruItem).expires.Before(time.Now())
+}
 
+// Set caches value under key for ttl, 0 uses the default TTL
+func (c *Cache) Set(key string, value interface{}, ttl time.Duration) error {
 	if ttl == 0 {
 		ttl = c.defaultTTL
 	}
 
-	c.items[key] = &item{
-		value:      value,
-		expiration: time.Now().Add(ttl).UnixNano(),
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
 	}
+	size := int64(len(data))
+	if c.maxBytes > 0 && size > c.maxBytes {
+		return ErrTooLarge
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	expires := time.Now().Add(ttl)
+	if err := c.backend.Set(key, Entry{Value: data, Expires: expires}); err != nil {
+		return err
+	}
+	if elem, found := c.index[key]; found {
+		c.bytes -= elem.Value.(*lruItem).size
+		c.lru.Remove(elem)
+	}
+	c.index[key] = c.lru.PushFront(&lruItem{key: key, size: size, expires: expires})
+	c.bytes += size
+	c.evict()
+	return nil
 }
 
-func (c *Cache) Delete(key string) {
+func (c *Cache) Delete(key string) error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
-	delete(c.items, key)
+	if elem, found := c.index[key]; found {
+		c.bytes -= elem.Value.(*lruItem).size
+		c.lru.Remove(elem)
+		delete(c.index, key)
+	}
+	return c.backend.Delete(key)
 }
 
-func (c *Cache) Clear() {
+func (c *Cache) Clear() error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
-	c.items = make(map[string]*item)
+	c.lru.Init()
+	c.index = make(map[string]*list.Element)
+	c.bytes = 0
+	return c.backend.Clear()
 }
 
 func (c *Cache) Stats() map[string]interface{} {
-	c.mu.RLock()
-	defer c.mu.RUnlock()
+	c.mu.Lock()
+	defer c.mu.Unlock()
 
+	hitRate := 0.0
+	if lookups := c.hits + c.misses; lookups > 0 {
+		hitRate = float64(c.hits) / float64(lookups)
+	}
 	return map[string]interface{}{
-		"items":      len(c.items),
+		"items":       len(c.index),
 		"default_ttl": c.defaultTTL.String(),
+		"backend":     c.backend.Name(),
+		"bytes_used":  c.bytes,
+		"max_bytes":   c.maxBytes,
+		"hits":        c.hits,
+		"misses":      c.misses,
+		"hit_rate":    hitRate,
+		"evictions":   c.evictions,
+		"expirations": c.expirations,
+	}
+}
+
+// Close stops the cleanup and closes the backend. Later calls return the result of the first.
+func (c *Cache) Close() error {
+	c.closeOnce.Do(func() {
+		c.cleanup.Stop()
+		close(c.done)
+		c.closeErr = c.backend.Close()
+	})
+	return c.closeErr
+}
+
+// evict drops the least recently used entries until the cache fits its size limit,
+// c.mu must be held
+func (c *Cache) evict() {
+	if c.maxBytes <= 0 {
+		return
+	}
+	for c.bytes > c.maxBytes {
+		elem := c.lru.Back()
+		if elem == nil {
+			return
+		}
+		c.remove(elem)
+		c.evictions++
+	}
+}
+
+// remove drops an entry from the index and the backend, c.mu must be held
+func (c *Cache) remove(elem *list.Element) {
+	item := elem.Value.(*lruItem)
+	c.lru.Remove(elem)
+	delete(c.index, item.key)
+	c.bytes -= item.size
+	c.backend.Delete(item.key)
+}
+
+// deleteExpired drops the entries that expired by now
+func (c *Cache) deleteExpired(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for elem := c.lru.Front(); elem != nil; {
+		next := elem.Next()
+		if elem.Value.(*lruItem).expires.Before(now) {
+			c.remove(elem)
+			c.expirations++
+		}
+		elem = next
 	}
 }
 
 func (c *Cache) startCleanup() {
-	for range c.cleanup.C {
-		c.mu.Lock()
-		now := time.Now().UnixNano()
-		for key, item := range c.items {
-			if now > item.expiration {
-				delete(c.items, key)
-			}
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-c.cleanup.C:
+			c.deleteExpired(time.Now())
 		}
-		c.mu.Unlock()
 	}
 }
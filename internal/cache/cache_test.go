@@ -0,0 +1,208 @@
+package cache
+
+import (
+	"errors"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// value is a string whose JSON encoding is exactly size bytes
+func value(size int) string {
+	return strings.Repeat("x", size-2)
+}
+
+func TestCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	tests := []struct {
+		name     string
+		maxBytes int64
+		// ops are "set:<key>:<size>" and "get:<key>", applied in order
+		ops         []string
+		wantKeys    []string
+		wantEvicted uint64
+	}{
+		{
+			name:     "fits",
+			maxBytes: 300,
+			ops:      []string{"set:a:100", "set:b:100", "set:c:100"},
+			wantKeys: []string{"a", "b", "c"},
+		},
+		{
+			name:        "oldest goes first",
+			maxBytes:    300,
+			ops:         []string{"set:a:100", "set:b:100", "set:c:100", "set:d:100"},
+			wantKeys:    []string{"b", "c", "d"},
+			wantEvicted: 1,
+		},
+		{
+			name:        "reads count as use",
+			maxBytes:    300,
+			ops:         []string{"set:a:100", "set:b:100", "set:c:100", "get:a", "set:d:100"},
+			wantKeys:    []string{"a", "c", "d"},
+			wantEvicted: 1,
+		},
+		{
+			name:        "large value evicts several",
+			maxBytes:    300,
+			ops:         []string{"set:a:100", "set:b:100", "set:c:100", "set:d:250"},
+			wantKeys:    []string{"d"},
+			wantEvicted: 3,
+		},
+		{
+			name:     "overwrite replaces the size",
+			maxBytes: 300,
+			ops:      []string{"set:a:100", "set:b:100", "set:a:50", "set:c:150"},
+			wantKeys: []string{"a", "b", "c"},
+		},
+		{
+			name:     "unlimited",
+			maxBytes: 0,
+			ops:      []string{"set:a:1000", "set:b:1000", "set:c:1000"},
+			wantKeys: []string{"a", "b", "c"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := New(NewMemory(), tt.maxBytes, time.Hour, time.Hour)
+			defer c.Close()
+
+			for _, op := range tt.ops {
+				parts := strings.Split(op, ":")
+				switch parts[0] {
+				case "set":
+					size, err := strconv.Atoi(parts[2])
+					if err != nil {
+						t.Fatalf("bad op %q", op)
+					}
+					if err := c.Set(parts[1], value(size), 0); err != nil {
+						t.Fatalf("Set(%s): %v", parts[1], err)
+					}
+				case "get":
+					var out string
+					if !c.Get(parts[1], &out) {
+						t.Fatalf("Get(%s) missed", parts[1])
+					}
+				}
+			}
+
+			for _, key := range []string{"a", "b", "c", "d"} {
+				want := contains(tt.wantKeys, key)
+				if got := c.Has(key); got != want {
+					t.Errorf("Has(%s) = %v, want %v", key, got, want)
+				}
+			}
+			stats := c.Stats()
+			if got := stats["evictions"].(uint64); got != tt.wantEvicted {
+				t.Errorf("evictions = %d, want %d", got, tt.wantEvicted)
+			}
+			if tt.maxBytes > 0 && stats["bytes_used"].(int64) > tt.maxBytes {
+				t.Errorf("bytes_used = %d, over the limit of %d", stats["bytes_used"], tt.maxBytes)
+			}
+		})
+	}
+}
+
+func TestCacheRejectsValuesLargerThanTheLimit(t *testing.T) {
+	c := New(NewMemory(), 100, time.Hour, time.Hour)
+	defer c.Close()
+
+	if err := c.Set("a", value(50), 0); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	if err := c.Set("b", value(101), 0); !errors.Is(err, ErrTooLarge) {
+		t.Fatalf("Set of an oversized value = %v, want ErrTooLarge", err)
+	}
+	if !c.Has("a") || c.Has("b") {
+		t.Errorf("an oversized value must neither be cached nor evict others")
+	}
+}
+
+func TestCacheExpiry(t *testing.T) {
+	c := New(NewMemory(), 0, time.Hour, time.Hour)
+	defer c.Close()
+
+	if err := c.Set("short", "v", time.Millisecond); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	if err := c.Set("long", "v", 0); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	time.Sleep(5 * time.Millisecond)
+
+	var out string
+	if c.Get("short", &out) {
+		t.Errorf("Get returned an expired value")
+	}
+	if !c.Get("long", &out) || out != "v" {
+		t.Errorf("Get(long) = %q, want v", out)
+	}
+
+	stats := c.Stats()
+	if stats["hits"].(uint64) != 1 || stats["misses"].(uint64) != 1 || stats["expirations"].(uint64) != 1 {
+		t.Errorf("stats = %v, want 1 hit, 1 miss and 1 expiration", stats)
+	}
+	if stats["items"].(int) != 1 {
+		t.Errorf("items = %v, want 1", stats["items"])
+	}
+}
+
+func TestBoltCacheSurvivesReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.db")
+
+	backend, err := OpenBolt(path)
+	if err != nil {
+		t.Fatalf("OpenBolt: %v", err)
+	}
+	c := New(backend, 0, time.Hour, time.Hour)
+	if err := c.Set("kept", "v", 0); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	if err := c.Set("expired", "v", time.Millisecond); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	if err := c.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	time.Sleep(5 * time.Millisecond)
+
+	backend, err = OpenBolt(path)
+	if err != nil {
+		t.Fatalf("OpenBolt: %v", err)
+	}
+	c = New(backend, 0, time.Hour, time.Hour)
+	defer c.Close()
+
+	var out string
+	if !c.Get("kept", &out) || out != "v" {
+		t.Errorf("Get(kept) = %q, want v", out)
+	}
+	if c.Get("expired", &out) {
+		t.Errorf("Get returned a value that expired while the cache was closed")
+	}
+}
+
+func TestCacheCloseTwice(t *testing.T) {
+	backend, err := OpenBolt(filepath.Join(t.TempDir(), "cache.db"))
+	if err != nil {
+		t.Fatalf("OpenBolt: %v", err)
+	}
+	c := New(backend, 0, time.Hour, time.Hour)
+	if err := c.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if err := c.Close(); err != nil {
+		t.Fatalf("second Close: %v", err)
+	}
+}
+
+func contains(keys []string, key string) bool {
+	for _, k := range keys {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,99 @@
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Key identifies a cached tool result. Two requests share a result only when they ran
+// the same tool version with the same parameters against the same target.
+type Key struct {
+	Tool string
+	// Version fingerprints the tool binary, upgrading the tool invalidates its results
+	Version string
+	Target  string
+	// Params are the normalized parameters, their order is significant
+	Params []string
+}
+
+// String renders the key, the parameters are hashed to keep keys short
+func (k Key) String() string {
+	h := sha256.New()
+	for _, param := range k.Params {
+		h.Write([]byte(param))
+		h.Write([]byte{0})
+	}
+	return fmt.Sprintf("%s@%s|%s|%s", k.Tool, k.Version, k.Target, hex.EncodeToString(h.Sum(nil)[:16]))
+}
+
+// TTLPolicy says how long the results of each tool stay cached
+type TTLPolicy struct {
+	Default time.Duration
+	Tools   map[string]time.Duration
+}
+
+// DefaultTTLPolicy keeps slow, slowly changing enumerations longer than scans of live services
+func DefaultTTLPolicy() TTLPolicy {
+	return TTLPolicy{
+		Default: 30 * time.Minute,
+		Tools: map[string]time.Duration{
+			"amass":       24 * time.Hour,
+			"subfinder":   24 * time.Hour,
+			"nuclei":      6 * time.Hour,
+			"nmap":        time.Hour,
+			"masscan":     time.Hour,
+			"gobuster":    time.Hour,
+			"feroxbuster": time.Hour,
+			"ffuf":        time.Hour,
+			"command":     5 * time.Minute,
+		},
+	}
+}
+
+// For returns the TTL of tool's results
+func (p TTLPolicy) For(tool string) time.Duration {
+	if ttl, found := p.Tools[tool]; found {
+		return ttl
+	}
+	return p.Default
+}
+
+// With returns a copy of p with overrides applied, "*" replaces the default
+func (p TTLPolicy) With(overrides map[string]time.Duration) TTLPolicy {
+	merged := TTLPolicy{Default: p.Default, Tools: make(map[string]time.Duration, len(p.Tools)+len(overrides))}
+	for tool, ttl := range p.Tools {
+		merged.Tools[tool] = ttl
+	}
+	for tool, ttl := range overrides {
+		if tool == "*" {
+			merged.Default = ttl
+		} else {
+			merged.Tools[tool] = ttl
+		}
+	}
+	return merged
+}
+
+// ParseTTLs parses per-tool TTLs like "amass=24h,nmap=30m,*=1h"
+func ParseTTLs(s string) (map[string]time.Duration, error) {
+	ttls := make(map[string]time.Duration)
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		tool, value, found := strings.Cut(part, "=")
+		if !found {
+			return nil, fmt.Errorf("invalid cache TTL %q, expected tool=duration", part)
+		}
+		ttl, err := time.ParseDuration(strings.TrimSpace(value))
+		if err != nil || ttl <= 0 {
+			return nil, fmt.Errorf("invalid cache TTL for %s: %q", tool, value)
+		}
+		ttls[strings.TrimSpace(tool)] = ttl
+	}
+	return ttls, nil
+}
@@ -0,0 +1,58 @@
+package cache
+
+import "sync"
+
+// MemoryBackend keeps entries in a map, they are lost when the process exits
+type MemoryBackend struct {
+	mu    sync.RWMutex
+	items map[string]Entry
+}
+
+// NewMemory creates an empty in-memory backend
+func NewMemory() *MemoryBackend {
+	return &MemoryBackend{items: make(map[string]Entry)}
+}
+
+func (m *MemoryBackend) Name() string {
+	return "memory"
+}
+
+func (m *MemoryBackend) Get(key string) (Entry, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	entry, found := m.items[key]
+	return entry, found
+}
+
+func (m *MemoryBackend) Set(key string, entry Entry) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.items[key] = entry
+	return nil
+}
+
+func (m *MemoryBackend) Delete(key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.items, key)
+	return nil
+}
+
+func (m *MemoryBackend) Clear() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.items = make(map[string]Entry)
+	return nil
+}
+
+func (m *MemoryBackend) Range(fn func(key string, entry Entry)) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for key, entry := range m.items {
+		fn(key, entry)
+	}
+}
+
+func (m *MemoryBackend) Close() error {
+	return nil
+}
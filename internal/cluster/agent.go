@@ -0,0 +1,403 @@
+package cluster
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/LeHTVy/h_ai/internal/executor"
+	"github.com/LeHTVy/h_ai/internal/policy"
+	"github.com/LeHTVy/h_ai/internal/tools"
+)
+
+// retryDelay is how long the agent waits before retrying a failed call to the coordinator
+const retryDelay = 5 * time.Second
+
+// reportAttempts bounds how often a task result is sent before it is given up
+const reportAttempts = 5
+
+// AgentConfig configures a worker node
+type AgentConfig struct {
+	// Coordinator is the base URL of the coordinating server, like http://10.0.0.1:8888
+	Coordinator string
+	// Token must match the coordinator's cluster token
+	Token    string
+	Name     string
+	Segment  string
+	Capacity int
+}
+
+// Agent is the worker side of the cluster: it registers with the coordinator, pulls
+// tasks and runs them through the local tools manager
+type Agent struct {
+	logger *zap.Logger
+	cfg    AgentConfig
+	tools  *tools.Manager
+	client *http.Client
+
+	// regMu serializes registration between the pollers
+	regMu    sync.Mutex
+	mu       sync.Mutex
+	workerID string
+	running  map[string]context.CancelCauseFunc
+	tasks    sync.WaitGroup
+
+	stopHeartbeat chan struct{}
+	heartbeatDone chan struct{}
+}
+
+// NewAgent creates the agent of a worker node
+func NewAgent(logger *zap.Logger, cfg AgentConfig, toolsMgr *tools.Manager) *Agent {
+	cfg.Coordinator = strings.TrimRight(cfg.Coordinator, "/")
+	if cfg.Capacity <= 0 {
+		cfg.Capacity = 1
+	}
+	return &Agent{
+		logger: logger,
+		cfg:    cfg,
+		tools:  toolsMgr,
+		// Pulls are held open by the coordinator for up to MaxPollWait
+		client:        &http.Client{Timeout: MaxPollWait + 30*time.Second},
+		running:       make(map[string]context.CancelCauseFunc),
+		stopHeartbeat: make(chan struct{}),
+		heartbeatDone: make(chan struct{}),
+	}
+}
+
+// Run pulls and runs tasks until ctx is done. Tasks still running then are left to Shutdown.
+func (a *Agent) Run(ctx context.Context) {
+	go a.heartbeatLoop()
+
+	var pollers sync.WaitGroup
+	for i := 0; i < a.cfg.Capacity; i++ {
+		pollers.Add(1)
+		go func() {
+			defer pollers.Done()
+			a.pollLoop(ctx)
+		}()
+	}
+	pollers.Wait()
+}
+
+// Shutdown waits until ctx is done for the running tasks, cancels the rest and leaves
+// the coordinator. It returns the IDs of the cancelled tasks.
+func (a *Agent) Shutdown(ctx context.Context) []string {
+	finished := make(chan struct{})
+	go func() {
+		a.tasks.Wait()
+		close(finished)
+	}()
+
+	var cancelled []string
+	select {
+	case <-finished:
+	case <-ctx.Done():
+		cancelled = a.cancelAll("worker shutting down")
+		// Cancelled tasks still report their partial results
+		<-finished
+	}
+
+	close(a.stopHeartbeat)
+	<-a.heartbeatDone
+
+	if id := a.currentID(); id != "" {
+		leaveCtx, cancel := context.WithTimeout(context.Background(), retryDelay)
+		defer cancel()
+		if _, err := a.call(leaveCtx, http.MethodDelete, "/api/cluster/workers/"+id, nil, nil); err != nil {
+			a.logger.Warn("Failed to leave coordinator", zap.Error(err))
+		}
+	}
+	return cancelled
+}
+
+func (a *Agent) pollLoop(ctx context.Context) {
+	for ctx.Err() == nil {
+		id, err := a.register(ctx)
+		if err != nil {
+			a.logger.Warn("Failed to register with coordinator",
+				zap.String("coordinator", a.cfg.Coordinator), zap.Error(err))
+			sleep(ctx, retryDelay)
+			continue
+		}
+
+		var task Task
+		status, err := a.call(ctx, http.MethodGet,
+			fmt.Sprintf("/api/cluster/workers/%s/next?wait=%s", id, MaxPollWait), nil, &task)
+		switch {
+		case ctx.Err() != nil:
+			return
+		case status == http.StatusNotFound:
+			// The coordinator restarted or dropped this worker
+			a.forget(id)
+			continue
+		case err != nil:
+			a.logger.Warn("Failed to pull task", zap.Error(err))
+			sleep(ctx, retryDelay)
+			continue
+		case status == http.StatusNoContent:
+			continue
+		}
+
+		// The slot stays taken until the task is done, unless the agent is stopping
+		done := make(chan struct{})
+		a.tasks.Add(1)
+		go func() {
+			defer a.tasks.Done()
+			defer close(done)
+			a.runTask(id, task)
+		}()
+		select {
+		case <-done:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// runTask runs a pulled task and reports its result
+func (a *Agent) runTask(workerID string, task Task) {
+	a.logger.Info("Running task",
+		zap.String("task_id", task.ID),
+		zap.String("tool", task.Tool),
+		zap.String("job_id", task.JobID))
+
+	var res TaskResult
+	run, err := a.tools.Prepare(task.Tool, task.Request)
+	if err != nil {
+		res.Result = map[string]interface{}{"success": false, "error": err.Error()}
+	} else {
+		ctx, cancel := context.WithCancelCause(context.Background())
+		a.mu.Lock()
+		a.running[task.ID] = cancel
+		a.mu.Unlock()
+
+		runCtx := executor.WithJobID(executor.WithRequester(ctx, task.Requester), task.JobID)
+		res.Result = run(policy.WithRole(runCtx, task.Role))
+		if ctx.Err() != nil {
+			res.Error = context.Cause(ctx).Error()
+		}
+
+		a.mu.Lock()
+		delete(a.running, task.ID)
+		a.mu.Unlock()
+		cancel(nil)
+	}
+
+	path := fmt.Sprintf("/api/cluster/workers/%s/tasks/%s", workerID, task.ID)
+	for attempt := 1; attempt <= reportAttempts; attempt++ {
+		ctx, cancel := context.WithTimeout(context.Background(), MaxPollWait)
+		status, err := a.call(ctx, http.MethodPost, path, res, nil)
+		cancel()
+		if err == nil {
+			return
+		}
+		if status == http.StatusNotFound {
+			a.logger.Warn("Coordinator no longer knows the task, dropping its result", zap.String("task_id", task.ID))
+			return
+		}
+		a.logger.Warn("Failed to report task result",
+			zap.String("task_id", task.ID), zap.Int("attempt", attempt), zap.Error(err))
+		time.Sleep(retryDelay)
+	}
+}
+
+// heartbeatLoop keeps the worker registered and cancels the tasks the coordinator asks to
+func (a *Agent) heartbeatLoop() {
+	defer close(a.heartbeatDone)
+
+	ticker := time.NewTicker(HeartbeatInterval)
+	defer ticker.Stop()
+	lastContact := time.Now()
+	for {
+		select {
+		case <-a.stopHeartbeat:
+			return
+		case <-ticker.C:
+		}
+
+		id := a.currentID()
+		if id == "" {
+			continue
+		}
+		if time.Since(lastContact) > workerTimeout {
+			// The coordinator has given up on this worker and its tasks by now
+			a.cancelAll("lost contact with coordinator")
+			a.forget(id)
+			lastContact = time.Now()
+			continue
+		}
+
+		a.mu.Lock()
+		hb := Heartbeat{Running: make([]string, 0, len(a.running))}
+		for taskID := range a.running {
+			hb.Running = append(hb.Running, taskID)
+		}
+		a.mu.Unlock()
+
+		ctx, cancel := context.WithTimeout(context.Background(), HeartbeatInterval)
+		var resp HeartbeatResponse
+		status, err := a.call(ctx, http.MethodPost, "/api/cluster/workers/"+id+"/heartbeat", hb, &resp)
+		cancel()
+		if status == http.StatusNotFound {
+			a.forget(id)
+			continue
+		}
+		if err != nil {
+			a.logger.Warn("Heartbeat failed", zap.Error(err))
+			continue
+		}
+		lastContact = time.Now()
+
+		for _, taskID := range resp.Cancel {
+			a.mu.Lock()
+			if cancelTask, ok := a.running[taskID]; ok {
+				a.logger.Info("Task cancelled by coordinator", zap.String("task_id", taskID))
+				cancelTask(errors.New("cancelled by coordinator"))
+			}
+			a.mu.Unlock()
+		}
+	}
+}
+
+// cancelAll cancels the running tasks and returns their IDs
+func (a *Agent) cancelAll(reason string) []string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	cancelled := make([]string, 0, len(a.running))
+	for id, cancel := range a.running {
+		cancelled = append(cancelled, id)
+		cancel(errors.New(reason))
+	}
+	sort.Strings(cancelled)
+	if len(cancelled) > 0 {
+		a.logger.Warn("Cancelling running tasks", zap.String("reason", reason), zap.Strings("tasks", cancelled))
+	}
+	return cancelled
+}
+
+// register returns the worker ID, registering with the coordinator first if needed
+func (a *Agent) register(ctx context.Context) (string, error) {
+	a.regMu.Lock()
+	defer a.regMu.Unlock()
+
+	if id := a.currentID(); id != "" {
+		return id, nil
+	}
+
+	// Tools installed since the last registration are picked up
+	var available []string
+	for tool, ok := range a.tools.RefreshToolsAvailability() {
+		if ok {
+			available = append(available, tool)
+		}
+	}
+	sort.Strings(available)
+
+	var resp struct {
+		Worker Worker `json:"worker"`
+	}
+	reg := Registration{
+		Name:     a.cfg.Name,
+		Segment:  a.cfg.Segment,
+		Tools:    available,
+		Capacity: a.cfg.Capacity,
+	}
+	if _, err := a.call(ctx, http.MethodPost, "/api/cluster/workers", reg, &resp); err != nil {
+		return "", err
+	}
+
+	a.mu.Lock()
+	a.workerID = resp.Worker.ID
+	a.mu.Unlock()
+	a.logger.Info("Registered with coordinator",
+		zap.String("coordinator", a.cfg.Coordinator),
+		zap.String("worker_id", resp.Worker.ID),
+		zap.Strings("tools", available))
+	return resp.Worker.ID, nil
+}
+
+func (a *Agent) currentID() string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.workerID
+}
+
+// forget drops the worker ID the coordinator no longer knows, so the next pull registers again
+func (a *Agent) forget(id string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.workerID == id {
+		a.logger.Warn("Coordinator does not know this worker, registering again", zap.String("worker_id", id))
+		a.workerID = ""
+	}
+}
+
+// call sends a JSON request to the coordinator and decodes the response into out. It
+// returns the HTTP status, with an error for anything but 2xx.
+func (a *Agent) call(ctx context.Context, method, path string, body, out interface{}) (int, error) {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return 0, fmt.Errorf("marshal error: %w", err)
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, a.cfg.Coordinator+path, reader)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+a.cfg.Token)
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return resp.StatusCode, fmt.Errorf("HTTP %d: %s", resp.StatusCode, strings.TrimSpace(string(data)))
+	}
+	if out != nil && resp.StatusCode != http.StatusNoContent {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return resp.StatusCode, fmt.Errorf("decode error: %w", err)
+		}
+	}
+	return resp.StatusCode, nil
+}
+
+func sleep(ctx context.Context, d time.Duration) {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+	case <-timer.C:
+	}
+}
+
+// ValidateCoordinatorURL checks a coordinator URL given on the command line
+func ValidateCoordinatorURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("expected an http(s) URL like http://10.0.0.1:8888, got %q", raw)
+	}
+	return nil
+}
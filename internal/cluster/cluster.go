@@ -0,0 +1,145 @@
+// Package cluster distributes tool requests to worker nodes. Workers register with the
+// coordinator, advertise the tools they have installed and pull tasks over HTTP, so
+// scans can run from hosts in other network segments.
+package cluster
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+var (
+	// ErrUnknownWorker is returned for worker IDs that are not registered, workers re-register on it
+	ErrUnknownWorker = errors.New("worker not registered")
+	// ErrTaskNotFound is returned for unknown task IDs
+	ErrTaskNotFound = errors.New("task not found")
+	// ErrNoWorker is returned when no online worker can run a dispatched request
+	ErrNoWorker = errors.New("no online worker can run this tool")
+)
+
+// Timing of the worker protocol
+const (
+	// HeartbeatInterval is how often workers report their running tasks
+	HeartbeatInterval = 5 * time.Second
+	// workerTimeout is how long a worker may go unheard before it is dropped
+	workerTimeout = 30 * time.Second
+	// MaxPollWait bounds how long a pull for the next task is held open
+	MaxPollWait = 25 * time.Second
+	// maxPendingTime bounds how long a task waits for a worker to pick it up
+	maxPendingTime = 10 * time.Minute
+	// taskRetention is how long finished tasks are kept
+	taskRetention = 24 * time.Hour
+)
+
+// Registration is sent by a worker when it joins the coordinator
+type Registration struct {
+	Name string `json:"name"`
+	// Segment labels the network the worker scans from, so requests can target it
+	Segment string `json:"segment,omitempty"`
+	// Tools lists the installed tool binaries
+	Tools []string `json:"tools"`
+	// Capacity is how many tasks the worker runs at once
+	Capacity int `json:"capacity"`
+}
+
+// Worker is a registered worker node
+type Worker struct {
+	ID       string   `json:"id"`
+	Name     string   `json:"name"`
+	Segment  string   `json:"segment,omitempty"`
+	Tools    []string `json:"tools"`
+	Capacity int      `json:"capacity"`
+	Address  string   `json:"address,omitempty"`
+	// Running lists the IDs of the tasks the worker is running
+	Running      []string  `json:"running"`
+	RegisteredAt time.Time `json:"registered_at"`
+	LastSeen     time.Time `json:"last_seen"`
+}
+
+// hasTool reports whether the worker can run requests needing binary, empty for any
+func (w *Worker) hasTool(binary string) bool {
+	if binary == "" {
+		return true
+	}
+	for _, tool := range w.Tools {
+		if tool == binary {
+			return true
+		}
+	}
+	return false
+}
+
+// TaskStatus is the lifecycle state of a task
+type TaskStatus string
+
+const (
+	TaskPending   TaskStatus = "pending"
+	TaskRunning   TaskStatus = "running"
+	TaskCompleted TaskStatus = "completed"
+	TaskFailed    TaskStatus = "failed"
+	TaskCancelled TaskStatus = "cancelled"
+)
+
+// Task is one tool request dispatched to a worker
+type Task struct {
+	ID string `json:"id"`
+	// JobID is the coordinator job waiting for the task
+	JobID   string          `json:"job_id,omitempty"`
+	Tool    string          `json:"tool"`
+	Request json.RawMessage `json:"request"`
+	// Requester and Role are the caller of the dispatch, the worker executes under them
+	Requester string `json:"requester,omitempty"`
+	Role      string `json:"role,omitempty"`
+
+	// WorkerID pins the task to one worker, Segment to the workers of a segment.
+	// Once assigned, Segment is the one of the worker running it.
+	WorkerID string `json:"worker_id,omitempty"`
+	Segment  string `json:"segment,omitempty"`
+
+	Status TaskStatus `json:"status"`
+	// AssignedTo is the worker running or having run the task
+	AssignedTo string                 `json:"assigned_to,omitempty"`
+	WorkerName string                 `json:"worker_name,omitempty"`
+	CreatedAt  time.Time              `json:"created_at"`
+	StartedAt  *time.Time             `json:"started_at,omitempty"`
+	EndedAt    *time.Time             `json:"ended_at,omitempty"`
+	Result     map[string]interface{} `json:"result,omitempty"`
+	Error      string                 `json:"error,omitempty"`
+
+	// requestedSegment is the segment asked for by the dispatch, restored when the
+	// task goes back into the queue
+	requestedSegment string
+}
+
+// Finished reports whether the task reached a terminal state
+func (t *Task) Finished() bool {
+	return t.Status == TaskCompleted || t.Status == TaskFailed || t.Status == TaskCancelled
+}
+
+// Selector picks the workers a request is dispatched to. The zero value runs it once
+// on any worker having the tool.
+type Selector struct {
+	// Worker is the ID or name of the worker to run on
+	Worker string `json:"worker,omitempty"`
+	// Segment restricts the request to workers of a network segment
+	Segment string `json:"segment,omitempty"`
+	// All runs the request on every matching worker instead of one
+	All bool `json:"all,omitempty"`
+}
+
+// Heartbeat is sent periodically by workers
+type Heartbeat struct {
+	Running []string `json:"running"`
+}
+
+// HeartbeatResponse tells a worker which of its tasks were cancelled
+type HeartbeatResponse struct {
+	Cancel []string `json:"cancel,omitempty"`
+}
+
+// TaskResult is reported by a worker when a task ends
+type TaskResult struct {
+	Result map[string]interface{} `json:"result"`
+	Error  string                 `json:"error,omitempty"`
+}
@@ -0,0 +1,632 @@
+package cluster
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/LeHTVy/h_ai/internal/executor"
+	"github.com/LeHTVy/h_ai/internal/policy"
+	"github.com/LeHTVy/h_ai/internal/tools"
+	"github.com/LeHTVy/h_ai/internal/utils"
+)
+
+type workerEntry struct {
+	Worker
+	running map[string]bool
+	// cancelled holds running tasks the worker is told to stop on its next heartbeat
+	cancelled map[string]bool
+}
+
+// Coordinator keeps the registered workers and hands out dispatched tasks to them
+type Coordinator struct {
+	logger *zap.Logger
+
+	mu      sync.Mutex
+	workers map[string]*workerEntry
+	tasks   map[string]*Task
+	// queue holds the IDs of pending tasks, oldest first
+	queue  []string
+	closed bool
+	// changed is closed and replaced whenever workers or tasks change
+	changed chan struct{}
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewCoordinator creates a coordinator, Start begins dropping unresponsive workers
+func NewCoordinator(logger *zap.Logger) *Coordinator {
+	return &Coordinator{
+		logger:  logger,
+		workers: make(map[string]*workerEntry),
+		tasks:   make(map[string]*Task),
+		changed: make(chan struct{}),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+}
+
+// Start expires workers and tasks in the background until Close is called
+func (c *Coordinator) Start() {
+	go c.sweepLoop()
+}
+
+// Close stops handing out tasks and ends pending pulls. Unfinished tasks are cancelled
+// so the jobs waiting for them return, their IDs are returned.
+func (c *Coordinator) Close() []string {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil
+	}
+	c.closed = true
+	now := time.Now()
+	var cancelled []string
+	for _, task := range c.tasks {
+		if !task.Finished() {
+			c.finish(task, TaskCancelled, "coordinator shutting down", now)
+			cancelled = append(cancelled, task.ID)
+		}
+	}
+	sort.Strings(cancelled)
+	c.broadcast()
+	c.mu.Unlock()
+
+	close(c.stop)
+	<-c.done
+	return cancelled
+}
+
+// Register adds a worker. A worker registering again under the same name, after a
+// restart for example, keeps its ID and the tasks it was running are failed.
+func (c *Coordinator) Register(reg Registration, address string) (Worker, error) {
+	if reg.Name == "" {
+		return Worker{}, fmt.Errorf("worker name is required")
+	}
+	if reg.Capacity <= 0 {
+		reg.Capacity = 1
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	id := utils.NewID()
+	for _, w := range c.workers {
+		if w.Name == reg.Name {
+			c.failRunning(w, "worker re-registered", now)
+			id = w.ID
+			break
+		}
+	}
+
+	tools := append([]string(nil), reg.Tools...)
+	sort.Strings(tools)
+	w := &workerEntry{
+		Worker: Worker{
+			ID:           id,
+			Name:         reg.Name,
+			Segment:      reg.Segment,
+			Tools:        tools,
+			Capacity:     reg.Capacity,
+			Address:      address,
+			RegisteredAt: now,
+			LastSeen:     now,
+		},
+		running:   make(map[string]bool),
+		cancelled: make(map[string]bool),
+	}
+	c.workers[id] = w
+	c.broadcast()
+
+	c.logger.Info("Worker registered",
+		zap.String("worker_id", id),
+		zap.String("name", reg.Name),
+		zap.String("segment", reg.Segment),
+		zap.String("address", address),
+		zap.Strings("tools", tools),
+		zap.Int("capacity", reg.Capacity))
+	return w.snapshot(), nil
+}
+
+// Deregister removes a worker, failing the tasks it was running
+func (c *Coordinator) Deregister(id string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	w, ok := c.workers[id]
+	if !ok {
+		return ErrUnknownWorker
+	}
+	c.remove(w, "worker left")
+	c.logger.Info("Worker left", zap.String("worker_id", id), zap.String("name", w.Name))
+	return nil
+}
+
+// Heartbeat marks a worker alive and returns the running tasks it must cancel
+func (c *Coordinator) Heartbeat(id string, hb Heartbeat) (HeartbeatResponse, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	w, ok := c.workers[id]
+	if !ok {
+		return HeartbeatResponse{}, ErrUnknownWorker
+	}
+	w.LastSeen = time.Now()
+
+	var resp HeartbeatResponse
+	for _, taskID := range hb.Running {
+		if w.cancelled[taskID] {
+			resp.Cancel = append(resp.Cancel, taskID)
+		}
+	}
+	return resp, nil
+}
+
+// Next hands the worker its next task, waiting until ctx is done for one to come up.
+// It returns false when there is none.
+func (c *Coordinator) Next(ctx context.Context, workerID string) (Task, bool, error) {
+	for {
+		c.mu.Lock()
+		w, ok := c.workers[workerID]
+		if !ok {
+			c.mu.Unlock()
+			return Task{}, false, ErrUnknownWorker
+		}
+		w.LastSeen = time.Now()
+		if c.closed {
+			c.mu.Unlock()
+			return Task{}, false, nil
+		}
+		if task := c.assign(w); task != nil {
+			snapshot := *task
+			c.mu.Unlock()
+			return snapshot, true, nil
+		}
+		changed := c.changed
+		c.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return Task{}, false, nil
+		case <-changed:
+		}
+	}
+}
+
+// assign starts the oldest pending task w can run. Callers must hold c.mu.
+func (c *Coordinator) assign(w *workerEntry) *Task {
+	if len(w.running) >= w.Capacity {
+		return nil
+	}
+
+	pending := c.queue[:0]
+	var picked *Task
+	for _, id := range c.queue {
+		task, ok := c.tasks[id]
+		if !ok || task.Status != TaskPending {
+			continue
+		}
+		if picked == nil && w.canRun(task) {
+			picked = task
+			continue
+		}
+		pending = append(pending, id)
+	}
+	c.queue = pending
+	if picked == nil {
+		return nil
+	}
+
+	now := time.Now()
+	picked.Status = TaskRunning
+	picked.AssignedTo = w.ID
+	picked.WorkerName = w.Name
+	picked.Segment = w.Segment
+	picked.StartedAt = &now
+	w.running[picked.ID] = true
+
+	c.logger.Info("Task assigned",
+		zap.String("task_id", picked.ID),
+		zap.String("tool", picked.Tool),
+		zap.String("worker", w.Name))
+	return picked
+}
+
+// Release puts a task assigned to a worker back in the queue when the worker never
+// received it, e.g. because its poll request went away while the task was assigned
+func (c *Coordinator) Release(workerID, taskID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	task, ok := c.tasks[taskID]
+	if !ok || task.AssignedTo != workerID || task.Status != TaskRunning {
+		return
+	}
+	if w, ok := c.workers[workerID]; ok {
+		delete(w.running, taskID)
+	}
+	task.Status = TaskPending
+	task.AssignedTo, task.WorkerName = "", ""
+	task.Segment = task.requestedSegment
+	task.StartedAt = nil
+	// Oldest first, it was at the head of the queue when it was assigned
+	c.queue = append([]string{taskID}, c.queue...)
+	c.broadcast()
+
+	c.logger.Info("Task returned to the queue", zap.String("task_id", taskID))
+}
+
+func (w *workerEntry) canRun(task *Task) bool {
+	if task.WorkerID != "" && task.WorkerID != w.ID {
+		return false
+	}
+	if task.Segment != "" && task.Segment != w.Segment {
+		return false
+	}
+	return w.hasTool(tools.RequestBinary(task.Tool))
+}
+
+// Complete records the result a worker reported for one of its tasks
+func (c *Coordinator) Complete(workerID, taskID string, res TaskResult) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	task, ok := c.tasks[taskID]
+	if !ok || task.AssignedTo != workerID {
+		return ErrTaskNotFound
+	}
+	if w, ok := c.workers[workerID]; ok {
+		delete(w.running, taskID)
+		delete(w.cancelled, taskID)
+		w.LastSeen = time.Now()
+	}
+
+	if task.Finished() {
+		// Cancelled or given up on meanwhile, keep whatever the worker got
+		if task.Result == nil {
+			task.Result = res.Result
+		}
+		c.broadcast()
+		return nil
+	}
+
+	task.Result = res.Result
+	status, errMsg := TaskCompleted, res.Error
+	if res.Result["success"] != true {
+		status = TaskFailed
+		if msg, ok := res.Result["error"].(string); ok && errMsg == "" {
+			errMsg = msg
+		}
+	}
+	c.finish(task, status, errMsg, time.Now())
+	c.broadcast()
+
+	c.logger.Info("Task finished",
+		zap.String("task_id", task.ID),
+		zap.String("worker", task.WorkerName),
+		zap.String("status", string(task.Status)))
+	return nil
+}
+
+// Dispatch queues tool requests for the workers picked by sel. The job ID, requester
+// and role are taken from ctx and carried over to the workers. With sel.All one task
+// is created per matching worker, otherwise a single one for the first to pull it.
+func (c *Coordinator) Dispatch(ctx context.Context, tool string, request json.RawMessage, sel Selector) ([]Task, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	matching, err := c.match(tool, sel)
+	if err != nil {
+		return nil, err
+	}
+
+	template := Task{
+		JobID:     executor.JobIDFromContext(ctx),
+		Tool:      tool,
+		Request:   request,
+		Requester: executor.RequesterFromContext(ctx),
+		Role:      policy.RoleFromContext(ctx),
+		Segment:   sel.Segment,
+		Status:    TaskPending,
+		CreatedAt: time.Now(),
+
+		requestedSegment: sel.Segment,
+	}
+
+	var targets []string
+	switch {
+	case sel.All:
+		for _, w := range matching {
+			targets = append(targets, w.ID)
+		}
+	case sel.Worker != "":
+		targets = []string{matching[0].ID}
+	default:
+		// Any matching worker, including ones registering later
+		targets = []string{""}
+	}
+
+	tasks := make([]Task, 0, len(targets))
+	for _, workerID := range targets {
+		task := template
+		task.ID = utils.NewID()
+		task.WorkerID = workerID
+		c.tasks[task.ID] = &task
+		c.queue = append(c.queue, task.ID)
+		tasks = append(tasks, task)
+	}
+	c.broadcast()
+
+	c.logger.Info("Dispatched tool request",
+		zap.String("tool", tool),
+		zap.String("job_id", template.JobID),
+		zap.Int("tasks", len(tasks)))
+	return tasks, nil
+}
+
+// Match returns the online workers sel picks for tool, or why there are none
+func (c *Coordinator) Match(tool string, sel Selector) ([]Worker, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	matching, err := c.match(tool, sel)
+	if err != nil {
+		return nil, err
+	}
+	workers := make([]Worker, 0, len(matching))
+	for _, w := range matching {
+		workers = append(workers, w.snapshot())
+	}
+	return workers, nil
+}
+
+// match is Match without the lock. Callers must hold c.mu.
+func (c *Coordinator) match(tool string, sel Selector) ([]*workerEntry, error) {
+	if c.closed {
+		return nil, executor.ErrShuttingDown
+	}
+	binary := tools.RequestBinary(tool)
+
+	if sel.Worker != "" {
+		w := c.lookup(sel.Worker)
+		if w == nil {
+			return nil, fmt.Errorf("%w: %s", ErrUnknownWorker, sel.Worker)
+		}
+		if sel.Segment != "" && w.Segment != sel.Segment {
+			return nil, fmt.Errorf("%w: worker %s is not in segment %q", ErrNoWorker, w.Name, sel.Segment)
+		}
+		if !w.hasTool(binary) {
+			return nil, fmt.Errorf("%w: worker %s does not have %s", ErrNoWorker, w.Name, binary)
+		}
+		return []*workerEntry{w}, nil
+	}
+
+	var matching []*workerEntry
+	for _, w := range c.workers {
+		if (sel.Segment == "" || w.Segment == sel.Segment) && w.hasTool(binary) {
+			matching = append(matching, w)
+		}
+	}
+	if len(matching) == 0 {
+		return nil, ErrNoWorker
+	}
+	sort.Slice(matching, func(i, j int) bool { return matching[i].Name < matching[j].Name })
+	return matching, nil
+}
+
+// lookup finds a worker by ID or name. Callers must hold c.mu.
+func (c *Coordinator) lookup(idOrName string) *workerEntry {
+	if w, ok := c.workers[idOrName]; ok {
+		return w
+	}
+	for _, w := range c.workers {
+		if w.Name == idOrName {
+			return w
+		}
+	}
+	return nil
+}
+
+// Wait blocks until the tasks have finished and returns them. When ctx is done first
+// the unfinished tasks are cancelled.
+func (c *Coordinator) Wait(ctx context.Context, ids []string) []Task {
+	for {
+		c.mu.Lock()
+		finished := true
+		for _, id := range ids {
+			if task, ok := c.tasks[id]; ok && !task.Finished() {
+				finished = false
+				break
+			}
+		}
+		if finished {
+			tasks := c.snapshots(ids)
+			c.mu.Unlock()
+			return tasks
+		}
+		changed := c.changed
+		c.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			c.Cancel(ids)
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			return c.snapshots(ids)
+		case <-changed:
+		}
+	}
+}
+
+// Cancel stops tasks. Running ones are cancelled on their worker at its next heartbeat.
+func (c *Coordinator) Cancel(ids []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	for _, id := range ids {
+		task, ok := c.tasks[id]
+		if !ok || task.Finished() {
+			continue
+		}
+		if w, ok := c.workers[task.AssignedTo]; ok && task.Status == TaskRunning {
+			w.cancelled[id] = true
+		}
+		c.finish(task, TaskCancelled, "", now)
+	}
+	c.broadcast()
+}
+
+// Task returns a task by ID
+func (c *Coordinator) Task(id string) (Task, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	task, ok := c.tasks[id]
+	if !ok {
+		return Task{}, false
+	}
+	return *task, true
+}
+
+// Tasks returns the tasks of a job, or all tasks when jobID is empty, oldest first
+func (c *Coordinator) Tasks(jobID string) []Task {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	tasks := make([]Task, 0, len(c.tasks))
+	for _, task := range c.tasks {
+		if jobID == "" || task.JobID == jobID {
+			tasks = append(tasks, *task)
+		}
+	}
+	sort.Slice(tasks, func(i, j int) bool { return tasks[i].CreatedAt.Before(tasks[j].CreatedAt) })
+	return tasks
+}
+
+// Workers returns the registered workers by name
+func (c *Coordinator) Workers() []Worker {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	workers := make([]Worker, 0, len(c.workers))
+	for _, w := range c.workers {
+		workers = append(workers, w.snapshot())
+	}
+	sort.Slice(workers, func(i, j int) bool { return workers[i].Name < workers[j].Name })
+	return workers
+}
+
+func (w *workerEntry) snapshot() Worker {
+	snapshot := w.Worker
+	snapshot.Running = make([]string, 0, len(w.running))
+	for id := range w.running {
+		snapshot.Running = append(snapshot.Running, id)
+	}
+	sort.Strings(snapshot.Running)
+	return snapshot
+}
+
+// snapshots copies the tasks with the given IDs. Callers must hold c.mu.
+func (c *Coordinator) snapshots(ids []string) []Task {
+	tasks := make([]Task, 0, len(ids))
+	for _, id := range ids {
+		if task, ok := c.tasks[id]; ok {
+			tasks = append(tasks, *task)
+		}
+	}
+	return tasks
+}
+
+func (c *Coordinator) sweepLoop() {
+	defer close(c.done)
+
+	ticker := time.NewTicker(HeartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.stop:
+			return
+		case now := <-ticker.C:
+			c.sweep(now)
+		}
+	}
+}
+
+// sweep drops workers that stopped responding and expires old tasks
+func (c *Coordinator) sweep(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	changed := false
+	for _, w := range c.workers {
+		if now.Sub(w.LastSeen) > workerTimeout {
+			c.logger.Warn("Worker stopped responding",
+				zap.String("worker_id", w.ID),
+				zap.String("name", w.Name),
+				zap.Time("last_seen", w.LastSeen))
+			c.remove(w, fmt.Sprintf("worker %s stopped responding", w.Name))
+			changed = true
+		}
+	}
+
+	for id, task := range c.tasks {
+		switch {
+		case task.Status == TaskPending && now.Sub(task.CreatedAt) > maxPendingTime:
+			c.finish(task, TaskFailed, fmt.Sprintf("no worker picked up the task within %s", maxPendingTime), now)
+			changed = true
+		case task.Finished() && task.EndedAt != nil && now.Sub(*task.EndedAt) > taskRetention:
+			delete(c.tasks, id)
+		}
+	}
+	if changed {
+		c.broadcast()
+	}
+}
+
+// remove drops a worker, failing its running tasks and the pending ones pinned to it.
+// Callers must hold c.mu.
+func (c *Coordinator) remove(w *workerEntry, reason string) {
+	now := time.Now()
+	c.failRunning(w, reason, now)
+	for _, task := range c.tasks {
+		if task.Status == TaskPending && task.WorkerID == w.ID {
+			c.finish(task, TaskFailed, reason, now)
+		}
+	}
+	delete(c.workers, w.ID)
+	c.broadcast()
+}
+
+// failRunning fails the tasks a worker is running. Callers must hold c.mu.
+func (c *Coordinator) failRunning(w *workerEntry, reason string, now time.Time) {
+	for id := range w.running {
+		if task, ok := c.tasks[id]; ok && !task.Finished() {
+			c.finish(task, TaskFailed, reason, now)
+		}
+	}
+	w.running = make(map[string]bool)
+	w.cancelled = make(map[string]bool)
+}
+
+// finish moves a task to a terminal state. Callers must hold c.mu.
+func (c *Coordinator) finish(task *Task, status TaskStatus, errMsg string, now time.Time) {
+	task.Status = status
+	task.EndedAt = &now
+	if errMsg != "" {
+		task.Error = errMsg
+	}
+}
+
+// broadcast wakes everything waiting for a change. Callers must hold c.mu.
+func (c *Coordinator) broadcast() {
+	close(c.changed)
+	c.changed = make(chan struct{})
+}
@@ -0,0 +1,137 @@
+package executor
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+var artifactIDPattern = regexp.MustCompile(`^[0-9a-f]{16}$`)
+
+// ArtifactInfo describes a file written for an execution
+type ArtifactInfo struct {
+	Name    string    `json:"name"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+}
+
+// ArtifactStore keeps the full output of executions on disk, one directory per execution
+type ArtifactStore struct {
+	dir string
+}
+
+// NewArtifactStore creates the artifact directory if needed
+func NewArtifactStore(dir string) (*ArtifactStore, error) {
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return nil, fmt.Errorf("failed to create artifact directory: %w", err)
+	}
+	return &ArtifactStore{dir: dir}, nil
+}
+
+// Create opens a new artifact file for writing
+func (a *ArtifactStore) Create(id, name string) (*os.File, error) {
+	path, err := a.path(id, name)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0750); err != nil {
+		return nil, err
+	}
+	return os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0640)
+}
+
+// Open opens an existing artifact for reading. Symlinks are refused since
+// tools control the contents of their output directory.
+func (a *ArtifactStore) Open(id, name string) (*os.File, error) {
+	path, err := a.path(id, name)
+	if err != nil {
+		return nil, err
+	}
+
+	current := filepath.Join(a.dir, id)
+	for _, part := range strings.Split(name, "/") {
+		current = filepath.Join(current, part)
+		info, err := os.Lstat(current)
+		if err != nil {
+			return nil, err
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return nil, fmt.Errorf("artifact %q is a symlink", name)
+		}
+	}
+	return os.Open(path)
+}
+
+// outputDirName holds the files a sandboxed tool writes itself
+const outputDirName = "output"
+
+// OutputDir creates and returns the directory a tool may write its own files to
+func (a *ArtifactStore) OutputDir(id string) (string, error) {
+	if !artifactIDPattern.MatchString(id) {
+		return "", fmt.Errorf("invalid artifact id %q", id)
+	}
+	dir, err := filepath.Abs(filepath.Join(a.dir, id, outputDirName))
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return "", err
+	}
+	return dir, nil
+}
+
+// List returns the artifacts stored for an execution, including the files in its output directory
+func (a *ArtifactStore) List(id string) ([]ArtifactInfo, error) {
+	if !artifactIDPattern.MatchString(id) {
+		return nil, fmt.Errorf("invalid artifact id %q", id)
+	}
+
+	root := filepath.Join(a.dir, id)
+	if _, err := os.Stat(root); err != nil {
+		return nil, err
+	}
+
+	artifacts := []ArtifactInfo{}
+	err := filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil || !info.Mode().IsRegular() {
+			return nil
+		}
+		name, err := filepath.Rel(root, path)
+		if err != nil {
+			return nil
+		}
+		artifacts = append(artifacts, ArtifactInfo{
+			Name:    filepath.ToSlash(name),
+			Size:    info.Size(),
+			ModTime: info.ModTime(),
+		})
+		return nil
+	})
+	return artifacts, err
+}
+
+// path resolves an artifact file, rejecting anything that could escape the store.
+// Only files in the output directory may have a slash separated path.
+func (a *ArtifactStore) path(id, name string) (string, error) {
+	if !artifactIDPattern.MatchString(id) {
+		return "", fmt.Errorf("invalid artifact id %q", id)
+	}
+	parts := strings.Split(name, "/")
+	if len(parts) > 1 && parts[0] != outputDirName {
+		return "", fmt.Errorf("invalid artifact name %q", name)
+	}
+	for _, part := range parts {
+		if part == "" || part[0] == '.' || strings.ContainsRune(part, '\\') {
+			return "", fmt.Errorf("invalid artifact name %q", name)
+		}
+	}
+	return filepath.Join(a.dir, id, filepath.FromSlash(name)), nil
+}
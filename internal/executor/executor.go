@@ -2,18 +2,23 @@ package executor
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
 	"os/exec"
-	"os/signal"
-	"runtime"
+	"path/filepath"
+	"sort"
+	"strings"
 	"sync"
-	"syscall"
 	"time"
 
 	"go.uber.org/zap"
 
 	"github.com/LeHTVy/h_ai/internal/cache"
+	"github.com/LeHTVy/h_ai/internal/history"
+	"github.com/LeHTVy/h_ai/internal/policy"
+	"github.com/LeHTVy/h_ai/internal/procstats"
+	"github.com/LeHTVy/h_ai/internal/utils"
 )
 
 type ExecutionResult struct {
@@ -23,240 +28,907 @@ type ExecutionResult struct {
 	ReturnCode   int           `json:"return_code"`
 	ExecutionTime float64     `json:"execution_time"`
 	PID          int           `json:"pid,omitempty"`
+	TimedOut     bool          `json:"timed_out,omitempty"`
+	Cancelled    bool          `json:"cancelled,omitempty"`
+	ExecutionID  string        `json:"execution_id,omitempty"`
+	StdoutBytes  int64         `json:"stdout_bytes"`
+	StderrBytes  int64         `json:"stderr_bytes"`
+	StdoutTruncated bool       `json:"stdout_truncated,omitempty"`
+	StderrTruncated bool       `json:"stderr_truncated,omitempty"`
+	ArtifactID   string        `json:"artifact_id,omitempty"`
+	QueueWait    float64       `json:"queue_wait,omitempty"`
+	QueueRejected bool         `json:"queue_rejected,omitempty"`
+	LimitBreaches []string     `json:"limit_breaches,omitempty"`
+	PolicyViolation string     `json:"policy_violation,omitempty"`
+	Sandboxed    bool          `json:"sandboxed,omitempty"`
+	// Recording is the asciicast artifact of the execution, empty when not recorded
+	Recording string `json:"recording,omitempty"`
+	// FailureReason says why the execution did not succeed, empty when it did
+	FailureReason FailureReason `json:"failure_reason,omitempty"`
+	// Signal is the signal that terminated the process, e.g. SIGKILL
+	Signal string `json:"signal,omitempty"`
+	// OutputIncomplete is set when the output could not be read to the end, e.g. when
+	// background children kept the pipes open past the exit of the process
+	OutputIncomplete bool `json:"output_incomplete,omitempty"`
+	// Cached is set when the result was served from the cache instead of running anything.
+	// It then has no PID or execution ID of its own, ArtifactID still names the output
+	// of the execution that produced it.
+	Cached bool `json:"cached,omitempty"`
 }
 
+// Process states reported in ProcessInfo.Status
+const (
+	ProcessQueued    = "queued"
+	ProcessRunning   = "running"
+	ProcessPaused    = "paused"
+	ProcessCompleted = "completed"
+	ProcessFailed    = "failed"
+	ProcessKilled    = "killed"
+	ProcessTimedOut  = "timed_out"
+)
+
 type ProcessInfo struct {
 	PID         int       `json:"pid"`
 	Command     string    `json:"command"`
 	StartTime   time.Time `json:"start_time"`
 	Status      string    `json:"status"`
+	JobID       string    `json:"job_id,omitempty"`
+	// PausedAt is set while the process is paused
+	PausedAt *time.Time `json:"paused_at,omitempty"`
+	// PausedDuration is the total time spent paused, in seconds
+	PausedDuration float64 `json:"paused_duration"`
+	// ElapsedTime is the time since the process started, in seconds
+	ElapsedTime float64 `json:"elapsed_time"`
+	// Usage covers the process and its descendants, nil where /proc is unavailable
+	Usage *procstats.Usage `json:"usage,omitempty"`
+	// ExecutionID identifies the execution in the history and the artifacts
+	ExecutionID string `json:"execution_id,omitempty"`
+	// QueuedAt and QueuePosition are set while the execution waits for a slot, without a PID
+	QueuedAt      *time.Time `json:"queued_at,omitempty"`
+	QueuePosition int        `json:"queue_position,omitempty"`
+	// EndTime, ExitCode and FailureReason are set once the process finished
+	EndTime       *time.Time    `json:"end_time,omitempty"`
+	ExitCode      *int          `json:"exit_code,omitempty"`
+	FailureReason FailureReason `json:"failure_reason,omitempty"`
+	// ResultURL is where the outcome of a finished process is kept, when recorded
+	ResultURL string `json:"result_url,omitempty"`
+
+	pausedTotal time.Duration
+	deadline    *pausableTimer
+	terminated  bool
+}
+
+type contextKey string
+
+const jobIDKey contextKey = "job_id"
+
+// WithJobID tags ctx so that processes spawned under it are associated with the job.
+func WithJobID(ctx context.Context, jobID string) context.Context {
+	return context.WithValue(ctx, jobIDKey, jobID)
+}
+
+// JobIDFromContext returns the job ID attached to ctx, if any.
+func JobIDFromContext(ctx context.Context) string {
+	jobID, _ := ctx.Value(jobIDKey).(string)
+	return jobID
+}
+
+const requesterKey contextKey = "requester"
+
+// WithRequester records who asked for the processes spawned under ctx.
+func WithRequester(ctx context.Context, requester string) context.Context {
+	return context.WithValue(ctx, requesterKey, requester)
+}
+
+// RequesterFromContext returns the requester attached to ctx, if any.
+func RequesterFromContext(ctx context.Context) string {
+	requester, _ := ctx.Value(requesterKey).(string)
+	return requester
+}
+
+const freshKey contextKey = "fresh"
+
+// WithFreshResults makes executions under ctx skip cached results, e.g. for scheduled scans.
+// Their results still refresh the cache.
+func WithFreshResults(ctx context.Context) context.Context {
+	return context.WithValue(ctx, freshKey, true)
+}
+
+func freshResults(ctx context.Context) bool {
+	fresh, _ := ctx.Value(freshKey).(bool)
+	return fresh
+}
+
+// maxTimeout caps per-request timeouts
+const maxTimeout = 24 * time.Hour
+
+// Config holds the executor settings
+type Config struct {
+	// Timeout is the default run time limit of a process
+	Timeout time.Duration
+	// MaxOutputBytes bounds how much of each output stream is kept in memory, 0 means unbounded
+	MaxOutputBytes int
+	// ArtifactDir receives the full output of every execution, empty disables artifacts
+	ArtifactDir string
+	// Scheduler holds the concurrency limits applied before spawning
+	Scheduler SchedulerConfig
+	// Limits holds the resource limits applied to spawned processes
+	Limits LimitsConfig
+	// History records every finished execution, nil disables the history
+	History *history.Store
+	// Policy is checked before anything is spawned, nil allows everything
+	Policy *policy.Engine
+	// Sandbox runs tools in Linux namespaces, nil runs them unconfined
+	Sandbox *SandboxConfig
+	// Mode records executions to, or replays them from, fixtures in FixtureDir
+	Mode       ExecMode
+	FixtureDir string
+	// ProcessRetention is how long finished processes stay in the process list, 0 drops
+	// them right away
+	ProcessRetention time.Duration
+	// MaxSessions bounds the interactive sessions running at once, 0 means unlimited
+	MaxSessions int
+}
+
+// DefaultConfig returns the executor defaults
+func DefaultConfig() Config {
+	return Config{
+		Timeout:        300 * time.Second, // 5 minutes default
+		MaxOutputBytes: 1024 * 1024,
+		Scheduler: SchedulerConfig{
+			MaxConcurrent: 8,
+			MaxQueue:      64,
+			ToolLimits:    map[string]int{"masscan": 1},
+		},
+		ProcessRetention: 10 * time.Minute,
+		MaxSessions:      8,
+	}
 }
 
 type Executor struct {
 	logger      *zap.Logger
 	cache       *cache.Cache
+	artifacts   *ArtifactStore
+	scheduler   *Scheduler
+	limits      LimitsConfig
+	history     *history.Store
+	policy      *policy.Engine
+	sandbox     *SandboxConfig
+	mode        ExecMode
+	fixtureDir  string
 	processes   map[int]*ProcessInfo
 	processLock sync.RWMutex
+	retention   time.Duration
+	streams     map[int]*OutputStream
+	streamLock  sync.RWMutex
+	sessions    map[string]*Session
+	sessionLock sync.RWMutex
+	running     int // sessions whose process has not exited
+	maxSessions int
 	timeout     time.Duration
+	maxOutput   int
 }
 
-func New(logger *zap.Logger, cache *cache.Cache) *Executor {
+func New(logger *zap.Logger, cache *cache.Cache, cfg Config) *Executor {
 	executor := &Executor{
-		logger:    logger,
-		cache:     cache,
-		processes: make(map[int]*ProcessInfo),
-		timeout:   300 * time.Second, // 5 minutes default
+		logger:      logger,
+		cache:       cache,
+		scheduler:   NewScheduler(cfg.Scheduler),
+		limits:      cfg.Limits,
+		history:     cfg.History,
+		policy:      cfg.Policy,
+		sandbox:     cfg.Sandbox,
+		mode:        cfg.Mode,
+		fixtureDir:  cfg.FixtureDir,
+		processes:   make(map[int]*ProcessInfo),
+		retention:   cfg.ProcessRetention,
+		streams:     make(map[int]*OutputStream),
+		sessions:    make(map[string]*Session),
+		maxSessions: cfg.MaxSessions,
+		timeout:     cfg.Timeout,
+		maxOutput:   cfg.MaxOutputBytes,
+	}
+
+	if cfg.ArtifactDir != "" {
+		artifacts, err := NewArtifactStore(cfg.ArtifactDir)
+		if err != nil {
+			logger.Error("Output artifacts disabled", zap.Error(err))
+		} else {
+			executor.artifacts = artifacts
+		}
+	}
+
+	if cfg.Limits.CgroupRoot != "" {
+		if !cgroupsSupported {
+			logger.Warn("cgroups are only supported on Linux, applying no cgroup limits")
+		} else if err := initCgroupRoot(cfg.Limits.CgroupRoot); err != nil {
+			logger.Warn("Failed to initialise cgroup root, falling back to rlimits",
+				zap.String("cgroup_root", cfg.Limits.CgroupRoot), zap.Error(err))
+			executor.limits.CgroupRoot = ""
+		}
+	}
+
+	if cfg.Sandbox != nil && !sandboxSupported {
+		logger.Warn("The sandbox requires Linux namespaces, running tools unconfined")
+		executor.sandbox = nil
+	}
+
+	switch executor.mode {
+	case "":
+		executor.mode = ExecLive
+	case ExecRecord:
+		if err := os.MkdirAll(cfg.FixtureDir, 0o755); err != nil {
+			logger.Error("Failed to create fixture directory, recording nothing",
+				zap.String("fixture_dir", cfg.FixtureDir), zap.Error(err))
+			executor.mode = ExecLive
+		}
+	}
+	if executor.mode != ExecLive {
+		logger.Info("Execution fixtures enabled",
+			zap.String("mode", string(executor.mode)), zap.String("fixture_dir", cfg.FixtureDir))
 	}
 
-	// Handle cleanup on exit
-	go executor.handleCleanup()
 	return executor
 }
 
 func (e *Executor) Execute(command string, useCache bool) ExecutionResult {
+	return e.ExecuteContext(context.Background(), command, useCache)
+}
+
+// ExecuteContext runs command like Execute, but the process is killed when ctx is done.
+func (e *Executor) ExecuteContext(ctx context.Context, command string, useCache bool) ExecutionResult {
+	return e.ExecuteSpec(ctx, ShellCommand(command), useCache)
+}
+
+// ExecuteSpec spawns the process described by spec directly, without a shell.
+func (e *Executor) ExecuteSpec(ctx context.Context, spec CommandSpec, useCache bool) ExecutionResult {
+	if result, denied := e.checkPolicy(ctx, spec); denied {
+		return result
+	}
+
+	cacheKey := spec.cacheKey()
+
 	// Check cache first
-	if useCache {
-		if cached, found := e.cache.Get(command); found {
-			if result, ok := cached.(ExecutionResult); ok {
-				e.logger.Debug("Using cached result", zap.String("command", command))
-				return result
-			}
+	if useCache && !freshResults(ctx) {
+		var cached ExecutionResult
+		if e.cache.Get(cacheKey, &cached) {
+			e.logger.Debug("Using cached result", zap.String("command", cacheKey))
+			// The process that produced the result is long gone
+			cached.Cached = true
+			cached.PID = 0
+			cached.ExecutionID = ""
+			cached.QueueWait = 0
+			return cached
+		}
+	}
+
+	queuedAt := time.Now()
+	release, err := e.scheduler.Acquire(ctx, filepath.Base(spec.Binary), spec.String())
+	if err != nil {
+		result := ExecutionResult{
+			Success:    false,
+			Stderr:     err.Error(),
+			ReturnCode: -1,
+			QueueWait:  time.Since(queuedAt).Seconds(),
 		}
+		if errors.Is(err, ErrQueueFull) || errors.Is(err, ErrShuttingDown) {
+			result.QueueRejected = true
+			result.FailureReason = FailureQueueRejected
+		} else {
+			result.Cancelled = true
+			result.FailureReason = FailureCancelled
+		}
+		return result
 	}
+	queueWait := time.Since(queuedAt).Seconds()
 
 	start := time.Now()
-	result := e.executeCommand(command)
+	result := e.executeCommand(ctx, spec)
+	release()
 	executionTime := time.Since(start).Seconds()
 	result.ExecutionTime = executionTime
+	result.QueueWait = queueWait
+
+	e.recordHistory(ctx, spec, start, result)
 
 	// Cache successful results
 	if useCache && result.Success {
-		e.cache.Set(command, result, 30*time.Minute)
+		if err := e.cache.Set(cacheKey, result, spec.CacheTTL); errors.Is(err, cache.ErrTooLarge) {
+			e.logger.Debug("Result too large to cache", zap.String("command", cacheKey))
+		} else if err != nil {
+			e.logger.Warn("Failed to cache result", zap.String("command", cacheKey), zap.Error(err))
+		}
 	}
 
 	return result
 }
 
-func (e *Executor) executeCommand(command string) ExecutionResult {
-	ctx, cancel := context.WithTimeout(context.Background(), e.timeout)
+// timeoutFor returns the run time allowed for spec
+func (e *Executor) timeoutFor(spec CommandSpec) time.Duration {
+	timeout := spec.Timeout
+	if timeout <= 0 {
+		timeout = e.timeout
+	}
+	if timeout > maxTimeout {
+		timeout = maxTimeout
+	}
+	return timeout
+}
+
+func (e *Executor) executeCommand(parent context.Context, spec CommandSpec) ExecutionResult {
+	timeout := e.timeoutFor(spec)
+
+	// The timeout only counts time the process is not paused
+	ctx, cancel := context.WithCancel(parent)
 	defer cancel()
+	deadline := newPausableTimer(timeout, cancel)
+	defer deadline.stop()
+
+	executionID := utils.NewID()
+	// Replayed executions only read a fixture, they need no sandbox
+	sandboxed := e.mode != ExecReplay && e.sandbox.enabledFor(filepath.Base(spec.Binary))
+
+	// Limits are in place before the tool runs, it must not start without them
+	var limits *limitHandle
+	if toolLimits := e.limits.For(filepath.Base(spec.Binary)); !toolLimits.IsZero() && e.mode != ExecReplay {
+		var err error
+		if limits, err = e.prepareLimits(executionID, toolLimits); err != nil {
+			return startFailure(executionID, err)
+		}
+	}
 
 	var cmd *exec.Cmd
-	if runtime.GOOS == "windows" {
-		cmd = exec.CommandContext(ctx, "cmd", "/c", command)
+	if e.mode == ExecReplay {
+		var err error
+		if cmd, err = e.replayCommand(ctx, spec); err != nil {
+			return startFailure(executionID, err)
+		}
+	} else if sandboxed {
+		var err error
+		if cmd, err = e.sandboxedCommand(ctx, spec, executionID, limits); err != nil {
+			limits.finish(nil)
+			return startFailure(executionID, err)
+		}
 	} else {
-		cmd = exec.CommandContext(ctx, "sh", "-c", command)
+		cmd = exec.CommandContext(ctx, spec.Binary, spec.Args...)
 		setUnixProcessGroup(cmd)
+		if len(spec.Env) > 0 {
+			cmd.Env = append(os.Environ(), spec.Env...)
+		}
+		if spec.WorkDir != "" {
+			cmd.Dir = spec.WorkDir
+		}
+	}
+	// Take down the whole process group, not only the direct child
+	cmd.Cancel = func() error {
+		return killProcessGroup(cmd.Process.Pid)
+	}
+	if spec.Stdin != "" {
+		cmd.Stdin = strings.NewReader(spec.Stdin)
 	}
 
-	stdout, err := cmd.StdoutPipe()
-	if err != nil {
-		return ExecutionResult{
-			Success:    false,
-			Stderr:     err.Error(),
-			ReturnCode: -1,
+	stream := newOutputStream(JobIDFromContext(parent))
+	stdout := e.newStreamWriter(executionID, "stdout", stream)
+	stderr := e.newStreamWriter(executionID, "stderr", stream)
+	defer stdout.Close()
+	defer stderr.Close()
+	recorder := e.newRecorder(executionID, spec, 0, 0, false)
+	defer recorder.Close()
+	stdout.recorder, stderr.recorder = recorder, recorder
+	fixture := newFixtureRecorder(e.mode)
+	stdout.fixture, stderr.fixture = fixture, fixture
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	// Background children may keep the pipes open after the process exits
+	cmd.WaitDelay = 5 * time.Second
+
+	if limits != nil {
+		if err := limits.attach(cmd, sandboxed); err != nil {
+			limits.finish(nil)
+			return startFailure(executionID, err)
 		}
 	}
-
-	stderr, err := cmd.StderrPipe()
-	if err != nil {
-		return ExecutionResult{
-			Success:    false,
-			Stderr:     err.Error(),
-			ReturnCode: -1,
+	// The helpers report why they could not start the tool
+	var helper *helperStatus
+	if sandboxed || (limits != nil && limits.needsHelper()) {
+		var err error
+		if helper, err = watchHelper(cmd); err != nil {
+			limits.finish(nil)
+			return startFailure(executionID, err)
 		}
 	}
 
 	if err := cmd.Start(); err != nil {
-		return ExecutionResult{
-			Success:    false,
-			Stderr:     err.Error(),
-			ReturnCode: -1,
+		if helper != nil {
+			helper.close()
+		}
+		limits.finish(nil)
+		return startFailure(executionID, err)
+	}
+	if limits != nil {
+		limits.started()
+	}
+	if helper != nil {
+		if err := helper.wait(); err != nil {
+			cmd.Wait()
+			limits.finish(nil)
+			return startFailure(executionID, err)
 		}
 	}
 
 	pid := cmd.Process.Pid
-	e.registerProcess(pid, command)
-
-	// Read output in parallel
-	var stdoutBytes, stderrBytes []byte
-	var wg sync.WaitGroup
-
-	wg.Add(2)
-	go func() {
-		defer wg.Done()
-		buf := make([]byte, 4096)
-		for {
-			n, err := stdout.Read(buf)
-			if n > 0 {
-				stdoutBytes = append(stdoutBytes, buf[:n]...)
-			}
-			if err != nil {
-				break
-			}
-		}
-	}()
-
-	go func() {
-		defer wg.Done()
-		buf := make([]byte, 4096)
-		for {
-			n, err := stderr.Read(buf)
-			if n > 0 {
-				stderrBytes = append(stderrBytes, buf[:n]...)
-			}
-			if err != nil {
-				break
-			}
-		}
-	}()
+	e.registerProcess(pid, executionID, spec.String(), JobIDFromContext(parent), deadline)
+	e.registerStream(pid, stream)
 
-	err = cmd.Wait()
-	wg.Wait()
+	err := cmd.Wait()
 
-	e.unregisterProcess(pid)
+	e.releaseStream(pid, stream)
 
 	returnCode := 0
+	outputIncomplete := false
 	if err != nil {
 		if exitError, ok := err.(*exec.ExitError); ok {
 			returnCode = exitError.ExitCode()
+		} else {
+			// The process exited but its output could not be read to the end
+			outputIncomplete = true
+			e.logger.Warn("Output of process may be incomplete",
+				zap.Int("pid", pid), zap.String("command", spec.String()), zap.Error(err))
 		}
 	}
 
-	return ExecutionResult{
-		Success:    returnCode == 0,
-		Stdout:     string(stdoutBytes),
-		Stderr:     string(stderrBytes),
-		ReturnCode: returnCode,
-		PID:        pid,
+	stdoutPreview, stdoutTruncated := stdout.Preview()
+	stderrPreview, stderrTruncated := stderr.Preview()
+
+	result := ExecutionResult{
+		Success:         returnCode == 0,
+		Stdout:          stdoutPreview,
+		Stderr:          stderrPreview,
+		ReturnCode:      returnCode,
+		PID:             pid,
+		ExecutionID:     executionID,
+		StdoutBytes:     stdout.Total(),
+		StderrBytes:     stderr.Total(),
+		StdoutTruncated: stdoutTruncated,
+		StderrTruncated: stderrTruncated,
+		Sandboxed:       sandboxed,
+	}
+	result.OutputIncomplete = outputIncomplete
+	if e.artifacts != nil {
+		result.ArtifactID = executionID
+	}
+	if recorder != nil {
+		result.Recording = RecordingName
+	}
+	if limits != nil {
+		result.LimitBreaches = limits.finish(cmd.ProcessState)
+		if len(result.LimitBreaches) > 0 {
+			e.logger.Warn("Process hit resource limits",
+				zap.Int("pid", pid),
+				zap.String("command", spec.String()),
+				zap.Strings("limits", result.LimitBreaches))
+		}
+	}
+
+	// The parent context is cancelled when the client or job goes away,
+	// our own deadline only fires for the execution timeout.
+	switch {
+	case parent.Err() == context.Canceled:
+		result.Success = false
+		result.Cancelled = true
+		e.logger.Info("Process cancelled", zap.Int("pid", pid), zap.String("command", spec.String()))
+	case deadline.Fired():
+		result.Success = false
+		result.TimedOut = true
+		e.logger.Warn("Process timed out",
+			zap.Int("pid", pid),
+			zap.String("command", spec.String()),
+			zap.Duration("timeout", timeout))
+	}
+
+	result.classifyExit(cmd.ProcessState)
+	e.finishProcess(pid, result)
+
+	// Interrupted executions and processes killed by a signal would not replay faithfully
+	if !result.Cancelled && !result.TimedOut && returnCode >= 0 && result.Signal == "" {
+		fixture.save(e, spec, returnCode)
+	}
+
+	return result
+}
+
+// policyDecision evaluates spec against the execution policy without logging or
+// recording anything. It returns the rule denying spec and why, or nil when allowed.
+func (e *Executor) policyDecision(ctx context.Context, spec CommandSpec) (string, error) {
+	if e.policy == nil {
+		return "", nil
+	}
+
+	err := e.policy.Check(policy.Request{
+		Role:   policy.RoleFromContext(ctx),
+		Binary: spec.Binary,
+		Args:   spec.Args,
+		Raw:    spec.Raw,
+		Stdin:  spec.Stdin,
+
+		Interactive: spec.interactive,
+	})
+	if err == nil {
+		return "", nil
+	}
+	rule := "policy"
+	var violation *policy.Violation
+	if errors.As(err, &violation) {
+		rule = violation.Rule
+	}
+	return rule, err
+}
+
+// evaluatePolicy is policyDecision for a spec about to run, denials are logged
+func (e *Executor) evaluatePolicy(ctx context.Context, spec CommandSpec) (string, error) {
+	rule, err := e.policyDecision(ctx, spec)
+	if err != nil {
+		e.logger.Warn("Execution denied by policy",
+			zap.String("command", spec.String()),
+			zap.String("role", policy.RoleFromContext(ctx)),
+			zap.String("requester", RequesterFromContext(ctx)),
+			zap.Error(err))
+	}
+	return rule, err
+}
+
+// checkPolicy evaluates spec against the execution policy, returning the result to
+// hand back if it is denied
+func (e *Executor) checkPolicy(ctx context.Context, spec CommandSpec) (ExecutionResult, bool) {
+	rule, err := e.evaluatePolicy(ctx, spec)
+	if err == nil {
+		return ExecutionResult{}, false
+	}
+
+	result := ExecutionResult{
+		Success:         false,
+		Stderr:          err.Error(),
+		ReturnCode:      -1,
+		ExecutionID:     utils.NewID(),
+		PolicyViolation: rule,
+		FailureReason:   FailurePolicyDenied,
+	}
+	e.recordHistory(ctx, spec, time.Now(), result)
+	return result, true
+}
+
+// recordHistory stores a finished execution in the history, if enabled
+func (e *Executor) recordHistory(ctx context.Context, spec CommandSpec, start time.Time, result ExecutionResult) {
+	if e.history == nil {
+		return
+	}
+
+	status := history.StatusFailed
+	switch {
+	case result.PolicyViolation != "":
+		status = history.StatusDenied
+	case result.PID == 0:
+		status = history.StatusError
+	case result.TimedOut:
+		status = history.StatusTimedOut
+	case result.Cancelled:
+		status = history.StatusCancelled
+	case result.Success:
+		status = history.StatusCompleted
+	}
+
+	rec := history.Record{
+		ID:            result.ExecutionID,
+		Tool:          spec.ToolName(),
+		Target:        spec.Target,
+		Command:       spec.String(),
+		Requester:     RequesterFromContext(ctx),
+		JobID:         JobIDFromContext(ctx),
+		Status:        status,
+		ReturnCode:    result.ReturnCode,
+		PID:           result.PID,
+		StartedAt:     start,
+		EndedAt:       start.Add(time.Duration(result.ExecutionTime * float64(time.Second))),
+		Duration:      result.ExecutionTime,
+		StdoutBytes:   result.StdoutBytes,
+		StderrBytes:   result.StderrBytes,
+		ArtifactID:    result.ArtifactID,
+		Recording:     result.Recording,
+		LimitBreaches: result.LimitBreaches,
+		FailureReason: string(result.FailureReason),
+		Signal:        result.Signal,
 	}
+	if err := e.history.Add(rec); err != nil {
+		e.logger.Warn("Failed to record execution history", zap.String("execution_id", rec.ID), zap.Error(err))
+	}
+}
+
+// newStreamWriter creates the capture for one output stream, spilling it to an artifact file if enabled
+func (e *Executor) newStreamWriter(executionID, name string, stream *OutputStream) *streamWriter {
+	w := &streamWriter{name: name, stream: stream, limit: e.maxOutput}
+	if e.artifacts == nil {
+		return w
+	}
+
+	file, err := e.artifacts.Create(executionID, name+".log")
+	if err != nil {
+		e.logger.Warn("Failed to create output artifact", zap.String("execution_id", executionID), zap.Error(err))
+		return w
+	}
+	w.file = file
+	return w
+}
+
+// Artifacts returns the artifact store, or nil when artifacts are disabled
+func (e *Executor) Artifacts() *ArtifactStore {
+	return e.artifacts
 }
 
-func (e *Executor) registerProcess(pid int, command string) {
+// registerProcess tracks a running process, deadline is paused along with it
+func (e *Executor) registerProcess(pid int, executionID, command string, jobID string, deadline *pausableTimer) {
 	e.processLock.Lock()
 	defer e.processLock.Unlock()
 
+	// A finished process kept for its PID is replaced once the PID is reused
 	e.processes[pid] = &ProcessInfo{
-		PID:       pid,
-		Command:   command,
-		StartTime: time.Now(),
-		Status:    "running",
+		PID:         pid,
+		Command:     command,
+		StartTime:   time.Now(),
+		Status:      ProcessRunning,
+		JobID:       jobID,
+		ExecutionID: executionID,
+		deadline:    deadline,
 	}
 }
 
-func (e *Executor) unregisterProcess(pid int) {
+// finishProcess records the outcome of a process and keeps it listed for the retention
+// period, so clients polling its status see how it ended
+func (e *Executor) finishProcess(pid int, result ExecutionResult) {
 	e.processLock.Lock()
 	defer e.processLock.Unlock()
 
-	if proc, exists := e.processes[pid]; exists {
-		proc.Status = "completed"
+	proc, exists := e.processes[pid]
+	if !exists || proc.EndTime != nil {
+		return
+	}
+
+	ended := time.Now()
+	if proc.PausedAt != nil {
+		proc.pausedTotal += ended.Sub(*proc.PausedAt)
+		proc.PausedAt = nil
+	}
+	exitCode := result.ReturnCode
+	proc.EndTime = &ended
+	proc.ExitCode = &exitCode
+	proc.FailureReason = result.FailureReason
+	proc.Status = processStatus(result)
+	if proc.terminated {
+		// Tools may exit cleanly on SIGTERM, the process was still killed
+		proc.Status = ProcessKilled
+	}
+	if e.history != nil {
+		proc.ResultURL = "/api/history/" + proc.ExecutionID
+	}
+
+	if e.retention <= 0 {
 		delete(e.processes, pid)
+		return
 	}
+	time.AfterFunc(e.retention, func() {
+		e.processLock.Lock()
+		defer e.processLock.Unlock()
+		if e.processes[pid] == proc {
+			delete(e.processes, pid)
+		}
+	})
 }
 
+// processStatus returns the final state of a process that ended with result
+func processStatus(result ExecutionResult) string {
+	switch {
+	case result.Success:
+		return ProcessCompleted
+	case result.TimedOut:
+		return ProcessTimedOut
+	case result.Cancelled, result.Signal != "":
+		return ProcessKilled
+	default:
+		return ProcessFailed
+	}
+}
+
+// ListProcesses returns the queued executions, the running processes with their resource
+// usage and the processes that finished within the retention period
 func (e *Executor) ListProcesses() []ProcessInfo {
+	processes := e.queuedProcesses()
+	active := e.activeProcesses()
+	addUsage(active)
+	processes = append(processes, active...)
+
+	e.processLock.RLock()
+	var finished []ProcessInfo
+	for _, proc := range e.processes {
+		if proc.EndTime != nil {
+			finished = append(finished, proc.snapshot())
+		}
+	}
+	e.processLock.RUnlock()
+
+	// Most recently finished first
+	sort.Slice(finished, func(i, j int) bool {
+		return finished[i].EndTime.After(*finished[j].EndTime)
+	})
+	return append(processes, finished...)
+}
+
+// activeProcesses returns the processes that are running or paused
+func (e *Executor) activeProcesses() []ProcessInfo {
 	e.processLock.RLock()
 	defer e.processLock.RUnlock()
 
 	processes := make([]ProcessInfo, 0, len(e.processes))
 	for _, proc := range e.processes {
-		processes = append(processes, *proc)
+		if proc.EndTime == nil {
+			processes = append(processes, proc.snapshot())
+		}
+	}
+	sort.Slice(processes, func(i, j int) bool {
+		return processes[i].StartTime.Before(processes[j].StartTime)
+	})
+	return processes
+}
+
+// queuedProcesses lists the executions waiting for a slot, they have no PID yet
+func (e *Executor) queuedProcesses() []ProcessInfo {
+	queued := e.scheduler.Queued()
+	processes := make([]ProcessInfo, len(queued))
+	for i, q := range queued {
+		queuedAt := q.QueuedAt
+		processes[i] = ProcessInfo{
+			Command:       q.Command,
+			Status:        ProcessQueued,
+			JobID:         q.JobID,
+			QueuedAt:      &queuedAt,
+			QueuePosition: q.Position,
+			ElapsedTime:   time.Since(queuedAt).Seconds(),
+		}
 	}
 	return processes
 }
 
 func (e *Executor) GetProcessStatus(pid int) *ProcessInfo {
+	e.processLock.RLock()
+	proc, exists := e.processes[pid]
+	if !exists {
+		e.processLock.RUnlock()
+		return nil
+	}
+	info := []ProcessInfo{proc.snapshot()}
+	e.processLock.RUnlock()
+
+	// The PID of a finished process may belong to something else by now
+	if info[0].EndTime == nil {
+		addUsage(info)
+	}
+	return &info[0]
+}
+
+// addUsage fills in the resource usage of each process tree from /proc
+func addUsage(processes []ProcessInfo) {
+	pids := make([]int, len(processes))
+	for i, proc := range processes {
+		pids[i] = proc.PID
+	}
+
+	trees, err := procstats.Trees(pids)
+	if err != nil {
+		return
+	}
+	for i := range processes {
+		if usage, found := trees[processes[i].PID]; found {
+			processes[i].Usage = &usage
+		}
+	}
+}
+
+// JobProcesses returns the PIDs of running processes spawned for jobID.
+func (e *Executor) JobProcesses(jobID string) []int {
 	e.processLock.RLock()
 	defer e.processLock.RUnlock()
 
-	if proc, exists := e.processes[pid]; exists {
-		return proc
+	pids := []int{}
+	for pid, proc := range e.processes {
+		if proc.JobID == jobID && proc.EndTime == nil {
+			pids = append(pids, pid)
+		}
 	}
-	return nil
+	return pids
 }
 
+// TerminateProcess kills the process group of pid, the process is then listed as killed
 func (e *Executor) TerminateProcess(pid int) error {
-	e.processLock.RLock()
-	_, exists := e.processes[pid]
-	e.processLock.RUnlock()
+	e.processLock.Lock()
+	proc, exists := e.processes[pid]
+	finished := exists && proc.EndTime != nil
+	if exists && !finished {
+		proc.terminated = true
+	}
+	e.processLock.Unlock()
 
 	if !exists {
-		return fmt.Errorf("process %d not found", pid)
+		return fmt.Errorf("process %d: %w", pid, ErrProcessNotFound)
+	}
+	if finished {
+		return fmt.Errorf("process %d: %w", pid, ErrProcessFinished)
 	}
 
 	// Platform-specific process termination
 	// Function implementation is in executor_windows.go or executor_unix.go
 	terminateProcess(pid)
-
-	e.unregisterProcess(pid)
 	return nil
 }
 
 func (e *Executor) GetDashboard() map[string]interface{} {
-	processes := e.ListProcesses()
+	processes := e.activeProcesses()
+	addUsage(processes)
+	queued := e.scheduler.Queued()
+
+	var total procstats.Usage
+	for _, proc := range processes {
+		if proc.Usage != nil {
+			total.Processes += proc.Usage.Processes
+			total.Threads += proc.Usage.Threads
+			total.CPUSeconds += proc.Usage.CPUSeconds
+			total.RSSBytes += proc.Usage.RSSBytes
+			total.ReadBytes += proc.Usage.ReadBytes
+			total.WriteBytes += proc.Usage.WriteBytes
+			total.DiskReadBytes += proc.Usage.DiskReadBytes
+			total.DiskWriteBytes += proc.Usage.DiskWriteBytes
+		}
+	}
+
 	return map[string]interface{}{
 		"active_processes": len(processes),
 		"processes":        processes,
+		"total_usage":      total,
+		"queued_processes": len(queued),
+		"queue":            queued,
+		"scheduler":        e.scheduler.Stats(),
 		"timestamp":        time.Now().Unix(),
 	}
 }
 
-func (e *Executor) handleCleanup() {
-	sigChan := make(chan os.Signal, 1)
-	// Platform-specific signal handling
-	if runtime.GOOS == "windows" {
-		signal.Notify(sigChan, os.Interrupt)
-	} else {
-		signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
+// Drain stops starting new executions, queued ones are rejected, and waits until
+// the running ones finish or ctx is done. It reports whether everything finished.
+func (e *Executor) Drain(ctx context.Context) bool {
+	e.scheduler.Close()
+	return e.waitIdle(ctx)
+}
+
+// TerminateAll kills the process groups of all running processes and waits for
+// them to exit until ctx is done. It returns the processes that were terminated.
+func (e *Executor) TerminateAll(ctx context.Context) []ProcessInfo {
+	processes := e.activeProcesses()
+	for _, proc := range processes {
+		e.logger.Warn("Terminating process", zap.Int("pid", proc.PID), zap.String("command", proc.Command))
+		go terminateProcess(proc.PID)
 	}
 
-	<-sigChan
-	e.logger.Info("Shutting down executor, terminating all processes")
+	e.waitIdle(ctx)
+	return processes
+}
 
-	e.processLock.Lock()
-	defer e.processLock.Unlock()
+// waitIdle waits until no execution holds a scheduler slot
+func (e *Executor) waitIdle(ctx context.Context) bool {
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
 
-	for pid := range e.processes {
-		e.TerminateProcess(pid)
+	for e.scheduler.Running() > 0 {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-ticker.C:
+		}
 	}
+	return true
 }
@@ -4,9 +4,12 @@
 package executor
 
 import (
+	"os"
 	"os/exec"
 	"syscall"
 	"time"
+
+	"golang.org/x/sys/unix"
 )
 
 // setUnixProcessGroup sets process group for Unix systems
@@ -22,12 +25,65 @@ func terminateProcess(pid int) {
 	pgid, err := syscall.Getpgid(pid)
 	if err == nil {
 		syscall.Kill(-pgid, syscall.SIGTERM)
+		// A paused group only handles SIGTERM once continued
+		syscall.Kill(-pgid, syscall.SIGCONT)
 		time.Sleep(2 * time.Second)
 		syscall.Kill(-pgid, syscall.SIGKILL)
 	} else {
 		// Fallback to direct kill
 		syscall.Kill(pid, syscall.SIGTERM)
+		syscall.Kill(pid, syscall.SIGCONT)
 		time.Sleep(2 * time.Second)
 		syscall.Kill(pid, syscall.SIGKILL)
 	}
 }
+
+// killProcessGroup kills the process group led by pid
+func killProcessGroup(pid int) error {
+	if err := syscall.Kill(-pid, syscall.SIGKILL); err != nil {
+		return syscall.Kill(pid, syscall.SIGKILL)
+	}
+	return nil
+}
+
+// suspendProcessGroup stops the process group led by pid
+func suspendProcessGroup(pid int) error {
+	return syscall.Kill(-pid, syscall.SIGSTOP)
+}
+
+// resumeProcessGroup continues the process group led by pid
+func resumeProcessGroup(pid int) error {
+	return syscall.Kill(-pid, syscall.SIGCONT)
+}
+
+// exitSignal returns the name of the signal that terminated the process, if any
+func exitSignal(state *os.ProcessState, sandboxed bool) string {
+	sig, ok := terminatingSignal(state, sandboxed)
+	if !ok {
+		return ""
+	}
+	if name := unix.SignalName(sig); name != "" {
+		return name
+	}
+	return sig.String()
+}
+
+// terminatingSignal returns the signal that terminated the process. The init helper of
+// a sandboxed tool cannot die of the tool's signal, it exits with 128 plus the signal
+// number instead, like a shell.
+func terminatingSignal(state *os.ProcessState, sandboxed bool) (syscall.Signal, bool) {
+	if state == nil {
+		return 0, false
+	}
+	status, ok := state.Sys().(syscall.WaitStatus)
+	if !ok {
+		return 0, false
+	}
+	if status.Signaled() {
+		return status.Signal(), true
+	}
+	if code := status.ExitStatus(); sandboxed && status.Exited() && code > 128 && code <= 128+64 {
+		return syscall.Signal(code - 128), true
+	}
+	return 0, false
+}
@@ -4,7 +4,9 @@
 package executor
 
 import (
+	"errors"
 	"fmt"
+	"os"
 	"os/exec"
 )
 
@@ -19,3 +21,26 @@ func terminateProcess(pid int) {
 	killCmd := exec.Command("taskkill", "/F", "/T", "/PID", fmt.Sprintf("%d", pid))
 	killCmd.Run() // Ignore errors, process might already be dead
 }
+
+// killProcessGroup kills the process tree rooted at pid
+func killProcessGroup(pid int) error {
+	return exec.Command("taskkill", "/F", "/T", "/PID", fmt.Sprintf("%d", pid)).Run()
+}
+
+// errPauseUnsupported is returned by pause and resume on Windows
+var errPauseUnsupported = errors.New("pausing processes is not supported on Windows")
+
+// suspendProcessGroup is not supported on Windows
+func suspendProcessGroup(pid int) error {
+	return errPauseUnsupported
+}
+
+// resumeProcessGroup is not supported on Windows
+func resumeProcessGroup(pid int) error {
+	return errPauseUnsupported
+}
+
+// exitSignal returns no signal, Windows processes are not terminated by signals
+func exitSignal(state *os.ProcessState, sandboxed bool) string {
+	return ""
+}
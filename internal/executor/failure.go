@@ -0,0 +1,68 @@
+package executor
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"os/exec"
+)
+
+// FailureReason says why an execution did not succeed
+type FailureReason string
+
+const (
+	// FailureNotFound means the binary does not exist, or no fixture was recorded for it
+	FailureNotFound FailureReason = "not_found"
+	// FailureTimeout means the process was killed when its timeout expired
+	FailureTimeout FailureReason = "timeout"
+	// FailureCancelled means the client, job or server stopped the execution
+	FailureCancelled FailureReason = "cancelled"
+	// FailureSignaled means the process was terminated by a signal, see Signal
+	FailureSignaled FailureReason = "signaled"
+	// FailureNonzeroExit means the process exited with a non-zero code
+	FailureNonzeroExit FailureReason = "nonzero_exit"
+	// FailureSpawnError means the process could not be started, e.g. not executable
+	FailureSpawnError FailureReason = "spawn_error"
+	// FailureResourceLimit means the process died after hitting one of its resource limits
+	FailureResourceLimit FailureReason = "resource_limit"
+	// FailurePolicyDenied means the policy denied the execution, nothing was spawned
+	FailurePolicyDenied FailureReason = "policy_denied"
+	// FailureQueueRejected means the execution queue was full or shutting down
+	FailureQueueRejected FailureReason = "queue_rejected"
+	// FailureInvalidRequest means the request could not be turned into a command
+	FailureInvalidRequest FailureReason = "invalid_request"
+)
+
+// startFailure is the result of an execution whose process could not be started
+func startFailure(executionID string, err error) ExecutionResult {
+	reason := FailureSpawnError
+	if errors.Is(err, exec.ErrNotFound) || errors.Is(err, fs.ErrNotExist) || errors.Is(err, ErrNoFixture) {
+		reason = FailureNotFound
+	}
+	return ExecutionResult{
+		Success:       false,
+		Stderr:        err.Error(),
+		ReturnCode:    -1,
+		ExecutionID:   executionID,
+		FailureReason: reason,
+	}
+}
+
+// classifyExit records why the process behind r ended unsuccessfully, if it did
+func (r *ExecutionResult) classifyExit(state *os.ProcessState) {
+	r.Signal = exitSignal(state, r.Sandboxed)
+	switch {
+	case r.Cancelled:
+		r.FailureReason = FailureCancelled
+	case r.TimedOut:
+		r.FailureReason = FailureTimeout
+	case r.Success:
+	// Limits are enforced with signals (SIGKILL, SIGXCPU) or failing allocations
+	case len(r.LimitBreaches) > 0:
+		r.FailureReason = FailureResourceLimit
+	case r.Signal != "":
+		r.FailureReason = FailureSignaled
+	default:
+		r.FailureReason = FailureNonzeroExit
+	}
+}
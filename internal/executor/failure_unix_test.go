@@ -0,0 +1,115 @@
+//go:build !windows
+// +build !windows
+
+package executor
+
+import (
+	"os"
+	"os/exec"
+	"testing"
+)
+
+// exitState runs a shell script and returns how it ended
+func exitState(t *testing.T, script string) *os.ProcessState {
+	t.Helper()
+	cmd := exec.Command("/bin/sh", "-c", script)
+	cmd.Run()
+	if cmd.ProcessState == nil {
+		t.Fatalf("%q did not run", script)
+	}
+	return cmd.ProcessState
+}
+
+func TestClassifyExit(t *testing.T) {
+	exited := exitState(t, "exit 0")
+	failed := exitState(t, "exit 3")
+	killed := exitState(t, "kill -KILL $$")
+	xcpu := exitState(t, "kill -XCPU $$")
+	// The sandbox's init helper exits with 128 plus the tool's signal
+	sandboxTerm := exitState(t, "exit 143")
+
+	tests := []struct {
+		name       string
+		result     ExecutionResult
+		state      *os.ProcessState
+		wantReason FailureReason
+		wantSignal string
+	}{
+		{
+			name:   "success",
+			result: ExecutionResult{Success: true},
+			state:  exited,
+		},
+		{
+			name:       "nonzero exit",
+			result:     ExecutionResult{ReturnCode: 3},
+			state:      failed,
+			wantReason: FailureNonzeroExit,
+		},
+		{
+			name:       "killed by a signal",
+			result:     ExecutionResult{ReturnCode: -1},
+			state:      killed,
+			wantReason: FailureSignaled,
+			wantSignal: "SIGKILL",
+		},
+		{
+			name:       "sandboxed tool killed by a signal",
+			result:     ExecutionResult{ReturnCode: 143, Sandboxed: true},
+			state:      sandboxTerm,
+			wantReason: FailureSignaled,
+			wantSignal: "SIGTERM",
+		},
+		{
+			name:       "exit status 143 outside the sandbox",
+			result:     ExecutionResult{ReturnCode: 143},
+			state:      sandboxTerm,
+			wantReason: FailureNonzeroExit,
+		},
+		{
+			name:       "resource limit",
+			result:     ExecutionResult{ReturnCode: -1, LimitBreaches: []string{"cpu_time"}},
+			state:      xcpu,
+			wantReason: FailureResourceLimit,
+			wantSignal: "SIGXCPU",
+		},
+		{
+			name:       "resource limit hit without a signal",
+			result:     ExecutionResult{ReturnCode: 3, LimitBreaches: []string{"memory"}},
+			state:      failed,
+			wantReason: FailureResourceLimit,
+		},
+		{
+			name:       "timeout wins over the signal",
+			result:     ExecutionResult{ReturnCode: -1, TimedOut: true},
+			state:      killed,
+			wantReason: FailureTimeout,
+			wantSignal: "SIGKILL",
+		},
+		{
+			name:       "cancellation wins over the timeout",
+			result:     ExecutionResult{ReturnCode: -1, Cancelled: true, TimedOut: true},
+			state:      killed,
+			wantReason: FailureCancelled,
+			wantSignal: "SIGKILL",
+		},
+		{
+			name:       "no process state",
+			result:     ExecutionResult{ReturnCode: 1},
+			wantReason: FailureNonzeroExit,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := tt.result
+			result.classifyExit(tt.state)
+			if result.FailureReason != tt.wantReason {
+				t.Errorf("FailureReason = %q, want %q", result.FailureReason, tt.wantReason)
+			}
+			if result.Signal != tt.wantSignal {
+				t.Errorf("Signal = %q, want %q", result.Signal, tt.wantSignal)
+			}
+		})
+	}
+}
@@ -0,0 +1,262 @@
+package executor
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"go.uber.org/zap"
+)
+
+// ExecMode selects whether tools are really run
+type ExecMode string
+
+const (
+	// ExecLive runs the tools
+	ExecLive ExecMode = "live"
+	// ExecRecord runs the tools and saves every finished execution as a fixture
+	ExecRecord ExecMode = "record"
+	// ExecReplay runs nothing, executions play back the fixture recorded for their command
+	ExecReplay ExecMode = "replay"
+)
+
+// ParseExecMode parses the value of the --exec-mode flag, empty meaning live
+func ParseExecMode(s string) (ExecMode, error) {
+	switch mode := ExecMode(s); mode {
+	case "":
+		return ExecLive, nil
+	case ExecLive, ExecRecord, ExecReplay:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("unknown execution mode %q, expected live, record or replay", s)
+	}
+}
+
+// ErrNoFixture is returned in replay mode for commands nothing was recorded for
+var ErrNoFixture = errors.New("no fixture recorded")
+
+const (
+	fixtureVersion = 1
+	// maxFixtureBytes bounds the output kept in a fixture, longer executions are not saved
+	maxFixtureBytes = 16 << 20
+	// replayInitName is argv[0] of the helper process that plays back a fixture
+	replayInitName = "h-ai-replay"
+)
+
+// Fixture is a recorded execution, replayed for commands with the same argv and stdin
+type Fixture struct {
+	Version    int       `json:"version"`
+	Tool       string    `json:"tool"`
+	Argv       []string  `json:"argv"`
+	Stdin      string    `json:"stdin,omitempty"`
+	ReturnCode int       `json:"return_code"`
+	Duration   float64   `json:"duration"`
+	RecordedAt time.Time `json:"recorded_at"`
+	// Output holds stdout and stderr in the order they were written
+	Output []FixtureChunk `json:"output"`
+}
+
+// FixtureChunk is a piece of output, Data holds it unless it is not valid UTF-8
+type FixtureChunk struct {
+	Stream string `json:"stream"`
+	Data   string `json:"data,omitempty"`
+	Base64 []byte `json:"base64,omitempty"`
+}
+
+func (c FixtureChunk) bytes() []byte {
+	if c.Base64 != nil {
+		return c.Base64
+	}
+	return []byte(c.Data)
+}
+
+// fixtureName identifies the fixture of spec. Only the binary name, the arguments and
+// stdin are matched, so fixtures recorded on one machine replay on another.
+func fixtureName(spec CommandSpec) string {
+	tool := filepath.Base(spec.Binary)
+	key, _ := json.Marshal(struct {
+		Binary string   `json:"binary"`
+		Args   []string `json:"args"`
+		Stdin  string   `json:"stdin"`
+	}{tool, spec.Args, spec.Stdin})
+	sum := sha256.Sum256(key)
+	return tool + "-" + hex.EncodeToString(sum[:8]) + ".json"
+}
+
+func (e *Executor) fixturePath(spec CommandSpec) string {
+	return filepath.Join(e.fixtureDir, fixtureName(spec))
+}
+
+// Mode returns the execution mode
+func (e *Executor) Mode() ExecMode {
+	return e.mode
+}
+
+// HasFixtures reports whether anything was recorded for tool
+func (e *Executor) HasFixtures(tool string) bool {
+	matches, _ := filepath.Glob(filepath.Join(e.fixtureDir, tool+"-*.json"))
+	for _, match := range matches {
+		// Skip tools whose name merely starts with this one
+		if len(filepath.Base(match)) == len(tool)+len("-0123456789abcdef.json") {
+			return true
+		}
+	}
+	return false
+}
+
+// fixtureRecorder collects the output of an execution in record mode, a nil recorder
+// collects nothing
+type fixtureRecorder struct {
+	mu       sync.Mutex
+	started  time.Time
+	chunks   []FixtureChunk
+	size     int
+	overflow bool
+}
+
+func newFixtureRecorder(mode ExecMode) *fixtureRecorder {
+	if mode != ExecRecord {
+		return nil
+	}
+	return &fixtureRecorder{started: time.Now()}
+}
+
+func (r *fixtureRecorder) output(stream string, p []byte) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.overflow {
+		return
+	}
+	if r.size += len(p); r.size > maxFixtureBytes {
+		r.overflow = true
+		r.chunks = nil
+		return
+	}
+	// Merge consecutive writes to the same stream to keep fixtures readable
+	if n := len(r.chunks); n > 0 && r.chunks[n-1].Stream == stream {
+		p = append(r.chunks[n-1].bytes(), p...)
+		r.chunks = r.chunks[:n-1]
+	}
+	chunk := FixtureChunk{Stream: stream}
+	if utf8.Valid(p) {
+		chunk.Data = string(p)
+	} else {
+		chunk.Base64 = append([]byte(nil), p...)
+	}
+	r.chunks = append(r.chunks, chunk)
+}
+
+// save writes the fixture of a finished execution, replacing an earlier recording
+func (r *fixtureRecorder) save(e *Executor, spec CommandSpec, returnCode int) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.overflow {
+		e.logger.Warn("Output too large to record a fixture",
+			zap.String("command", spec.String()), zap.Int("max_bytes", maxFixtureBytes))
+		return
+	}
+	fixture := Fixture{
+		Version:    fixtureVersion,
+		Tool:       filepath.Base(spec.Binary),
+		Argv:       spec.Argv(),
+		Stdin:      spec.Stdin,
+		ReturnCode: returnCode,
+		Duration:   time.Since(r.started).Seconds(),
+		RecordedAt: time.Now().UTC(),
+		Output:     r.chunks,
+	}
+	if fixture.Output == nil {
+		fixture.Output = []FixtureChunk{}
+	}
+
+	path := e.fixturePath(spec)
+	if err := writeFixture(path, fixture); err != nil {
+		e.logger.Error("Failed to record fixture", zap.String("command", spec.String()), zap.Error(err))
+		return
+	}
+	e.logger.Debug("Recorded fixture", zap.String("command", spec.String()), zap.String("fixture", path))
+}
+
+func writeFixture(path string, fixture Fixture) error {
+	// Fixtures are meant to be read and edited, keep shell syntax unescaped
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(fixture); err != nil {
+		return err
+	}
+	// Write to a temporary file first so a replay never sees half a fixture
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, buf.Bytes(), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// replayCommand returns a command playing back the fixture of spec instead of running it
+func (e *Executor) replayCommand(ctx context.Context, spec CommandSpec) (*exec.Cmd, error) {
+	path := e.fixturePath(spec)
+	if _, err := os.Stat(path); err != nil {
+		return nil, fmt.Errorf("%w for %s (%s)", ErrNoFixture, spec.String(), filepath.Base(path))
+	}
+	self, err := os.Executable()
+	if err != nil {
+		return nil, fmt.Errorf("failed to locate the server binary for replay: %w", err)
+	}
+
+	cmd := exec.CommandContext(ctx, self, path)
+	cmd.Args[0] = replayInitName
+	setUnixProcessGroup(cmd)
+	return cmd, nil
+}
+
+// ReplayInit must be called first thing in main. In the replay helper process it writes
+// the recorded output and exits with the recorded code, otherwise it returns at once.
+func ReplayInit() {
+	if filepath.Base(os.Args[0]) != replayInitName {
+		return
+	}
+	if len(os.Args) < 2 {
+		fmt.Fprintln(os.Stderr, "h-ai replay: missing fixture")
+		os.Exit(126)
+	}
+
+	data, err := os.ReadFile(os.Args[1])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "h-ai replay: %v\n", err)
+		os.Exit(126)
+	}
+	var fixture Fixture
+	if err := json.Unmarshal(data, &fixture); err != nil {
+		fmt.Fprintf(os.Stderr, "h-ai replay: invalid fixture %s: %v\n", os.Args[1], err)
+		os.Exit(126)
+	}
+
+	for _, chunk := range fixture.Output {
+		out := os.Stdout
+		if chunk.Stream == "stderr" {
+			out = os.Stderr
+		}
+		out.Write(chunk.bytes())
+	}
+	os.Exit(fixture.ReturnCode)
+}
@@ -0,0 +1,50 @@
+package executor
+
+import (
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+)
+
+// helperStatusFD is where the sandbox and limits helpers report why they could not
+// start the tool. It is closed on exec, nothing is read from it once the tool runs.
+const helperStatusFD = 3
+
+// helperStatus is the executor's end of the pipe a helper reports its failure on
+type helperStatus struct {
+	r, w *os.File
+}
+
+// watchHelper hands the status pipe to the helper cmd starts. Nothing else passes
+// extra files, so the helper gets it as helperStatusFD.
+func watchHelper(cmd *exec.Cmd) (*helperStatus, error) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	cmd.ExtraFiles = append(cmd.ExtraFiles, w)
+	return &helperStatus{r: r, w: w}, nil
+}
+
+// wait blocks until the helper executed the tool or gave up, and returns why it did
+func (s *helperStatus) wait() error {
+	s.w.Close()
+	msg, _ := io.ReadAll(s.r)
+	s.r.Close()
+	if len(msg) > 0 {
+		return errors.New(string(msg))
+	}
+	return nil
+}
+
+// close releases the pipe of a helper that was never started
+func (s *helperStatus) close() {
+	s.w.Close()
+	s.r.Close()
+}
+
+// reportHelperFailure is called in a helper process that could not start the tool
+func reportHelperFailure(err error) {
+	os.NewFile(helperStatusFD, "status").Write([]byte(err.Error()))
+}
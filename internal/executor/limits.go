@@ -0,0 +1,285 @@
+package executor
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// ResourceLimits caps the resources a spawned process may use. Zero values mean no limit.
+type ResourceLimits struct {
+	// CPUSeconds is the CPU time budget (RLIMIT_CPU)
+	CPUSeconds uint64 `json:"cpu_seconds,omitempty"`
+	// CPUQuota is the number of CPUs the process tree may use, cgroups only (cpu.max)
+	CPUQuota float64 `json:"cpu_quota,omitempty"`
+	// MemoryBytes is enforced with memory.max under cgroups, RLIMIT_AS otherwise
+	MemoryBytes uint64 `json:"memory_bytes,omitempty"`
+	// OpenFiles is the file descriptor limit (RLIMIT_NOFILE)
+	OpenFiles uint64 `json:"open_files,omitempty"`
+	// Processes is enforced with pids.max under cgroups, RLIMIT_NPROC (per user) otherwise
+	Processes uint64 `json:"processes,omitempty"`
+}
+
+// IsZero reports whether no limit is set
+func (l ResourceLimits) IsZero() bool {
+	return l == ResourceLimits{}
+}
+
+// merge returns l with every unset field taken from fallback
+func (l ResourceLimits) merge(fallback ResourceLimits) ResourceLimits {
+	if l.CPUSeconds == 0 {
+		l.CPUSeconds = fallback.CPUSeconds
+	}
+	if l.CPUQuota == 0 {
+		l.CPUQuota = fallback.CPUQuota
+	}
+	if l.MemoryBytes == 0 {
+		l.MemoryBytes = fallback.MemoryBytes
+	}
+	if l.OpenFiles == 0 {
+		l.OpenFiles = fallback.OpenFiles
+	}
+	if l.Processes == 0 {
+		l.Processes = fallback.Processes
+	}
+	return l
+}
+
+// LimitsConfig holds the default and per-tool resource limits
+type LimitsConfig struct {
+	Default ResourceLimits            `json:"default"`
+	Tools   map[string]ResourceLimits `json:"tools,omitempty"`
+	// CgroupRoot is a delegated cgroup v2 directory, one child cgroup is created per process.
+	// Empty disables cgroups and only rlimits are applied.
+	CgroupRoot string `json:"cgroup_root,omitempty"`
+}
+
+// LoadLimitsConfig reads a LimitsConfig from a JSON file
+func LoadLimitsConfig(path string) (LimitsConfig, error) {
+	var cfg LimitsConfig
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return cfg, fmt.Errorf("failed to read limits config: %w", err)
+	}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return cfg, fmt.Errorf("failed to parse limits config: %w", err)
+	}
+	return cfg, nil
+}
+
+// For returns the limits applying to tool
+func (c LimitsConfig) For(tool string) ResourceLimits {
+	if limits, ok := c.Tools[tool]; ok {
+		return limits.merge(c.Default)
+	}
+	return c.Default
+}
+
+// limitsInitName is argv[0] of the helper process that sets the rlimits of a tool
+// before replacing itself with it, so they hold from the tool's first instruction
+const limitsInitName = "h-ai-limits-init"
+
+// LimitsInit must be called first thing in main. In the limits helper process it
+// applies the rlimits and replaces itself with the tool, otherwise it returns at once.
+func LimitsInit() {
+	if filepath.Base(os.Args[0]) != limitsInitName {
+		return
+	}
+	if len(os.Args) < 4 {
+		fmt.Fprintln(os.Stderr, "h-ai limits: missing arguments")
+		os.Exit(126)
+	}
+
+	var limits ResourceLimits
+	if err := json.Unmarshal([]byte(os.Args[1]), &limits); err != nil {
+		fmt.Fprintf(os.Stderr, "h-ai limits: invalid limits: %v\n", err)
+		os.Exit(126)
+	}
+
+	// Only returns on failure
+	err := runLimitsInit(limits, os.Args[2], os.Args[3:])
+	reportHelperFailure(err)
+	fmt.Fprintf(os.Stderr, "h-ai limits: %v\n", err)
+	os.Exit(126)
+}
+
+// limitHandle tracks the limits applied to one process
+type limitHandle struct {
+	limits    ResourceLimits
+	cgroupDir string
+	// cgroupFD is the open cgroup directory the process is started in, -1 if none
+	cgroupFD int
+	// sandboxed is set when the process is the sandbox helper, which applies the rlimits
+	sandboxed bool
+	logger    *zap.Logger
+}
+
+// prepareLimits creates the per-process cgroup, if cgroups are enabled, and checks
+// the rlimits can be applied. The execution must not start when this fails.
+func (e *Executor) prepareLimits(executionID string, limits ResourceLimits) (*limitHandle, error) {
+	h := &limitHandle{limits: limits, cgroupFD: -1, logger: e.logger}
+	if e.limits.CgroupRoot != "" && cgroupsSupported {
+		dir := filepath.Join(e.limits.CgroupRoot, "h-ai-"+executionID)
+		if err := os.Mkdir(dir, 0755); err != nil {
+			return nil, fmt.Errorf("failed to create cgroup: %w", err)
+		}
+		h.cgroupDir = dir
+
+		if err := h.configureCgroup(); err != nil {
+			h.finish(nil)
+			return nil, err
+		}
+	}
+
+	if err := checkRlimits(h.rlimits()); err != nil {
+		h.finish(nil)
+		return nil, err
+	}
+	return h, nil
+}
+
+func (h *limitHandle) configureCgroup() error {
+	limits := h.limits
+	if limits.MemoryBytes > 0 {
+		if err := h.write("memory.max", strconv.FormatUint(limits.MemoryBytes, 10)); err != nil {
+			return err
+		}
+		if err := h.write("memory.swap.max", "0"); err != nil {
+			return err
+		}
+	}
+	if limits.Processes > 0 {
+		if err := h.write("pids.max", strconv.FormatUint(limits.Processes, 10)); err != nil {
+			return err
+		}
+	}
+	if limits.CPUQuota > 0 {
+		const period = 100000
+		if err := h.write("cpu.max", fmt.Sprintf("%d %d", int64(limits.CPUQuota*period), period)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rlimits returns the limits enforced with rlimits rather than the cgroup
+func (h *limitHandle) rlimits() ResourceLimits {
+	rlimits := h.limits
+	rlimits.CPUQuota = 0
+	if h.cgroupDir != "" {
+		// cgroups account for the whole tree, so the per-process rlimits are not needed
+		rlimits.MemoryBytes = 0
+		rlimits.Processes = 0
+	}
+	return rlimits
+}
+
+// attach makes cmd start inside the cgroup. Unless the command is sandboxed, which sets
+// the rlimits itself, cmd is also wrapped in the limits helper.
+func (h *limitHandle) attach(cmd *exec.Cmd, sandboxed bool) error {
+	h.sandboxed = sandboxed
+	if h.cgroupDir != "" {
+		if err := h.openCgroup(cmd); err != nil {
+			return err
+		}
+	}
+	if sandboxed || !h.needsHelper() {
+		return nil
+	}
+	return limitCommand(cmd, h.rlimits())
+}
+
+// needsHelper reports whether rlimits are set by a helper before the tool runs
+func (h *limitHandle) needsHelper() bool {
+	return !h.rlimits().IsZero()
+}
+
+// started releases what was only needed to start the process
+func (h *limitHandle) started() {
+	h.closeCgroup()
+}
+
+// finish reports which limits the process ran into and removes its cgroup
+func (h *limitHandle) finish(state *os.ProcessState) []string {
+	if h == nil {
+		return nil
+	}
+	h.closeCgroup()
+	breaches := []string{}
+
+	if h.limits.CPUSeconds > 0 && cpuLimitExceeded(state, h.sandboxed, h.limits.CPUSeconds) {
+		breaches = append(breaches, "cpu_time")
+	}
+
+	if h.cgroupDir != "" {
+		if h.readCounter("memory.events", "oom_kill") > 0 {
+			breaches = append(breaches, "memory")
+		}
+		if h.readCounter("pids.events", "max") > 0 {
+			breaches = append(breaches, "processes")
+		}
+		// Hitting cpu.max only throttles the process, it is not a breach
+
+		if err := os.Remove(h.cgroupDir); err != nil {
+			h.logger.Warn("Failed to remove cgroup", zap.String("cgroup", h.cgroupDir), zap.Error(err))
+		}
+	}
+
+	if len(breaches) == 0 {
+		return nil
+	}
+	return breaches
+}
+
+func (h *limitHandle) write(file, value string) error {
+	if err := os.WriteFile(filepath.Join(h.cgroupDir, file), []byte(value), 0644); err != nil {
+		return fmt.Errorf("failed to set %s of cgroup: %w", file, err)
+	}
+	return nil
+}
+
+// readCounter reads a "key value" counter from a cgroup flat-keyed file
+func (h *limitHandle) readCounter(file, key string) uint64 {
+	data, err := os.ReadFile(filepath.Join(h.cgroupDir, file))
+	if err != nil {
+		return 0
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 && fields[0] == key {
+			value, _ := strconv.ParseUint(fields[1], 10, 64)
+			return value
+		}
+	}
+	return 0
+}
+
+// initCgroupRoot creates the cgroup root and enables the controllers used for limits.
+// Controllers are enabled one by one so a partially delegated hierarchy still works.
+func initCgroupRoot(root string) error {
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return err
+	}
+
+	var enabled []string
+	var lastErr error
+	for _, controller := range []string{"memory", "pids", "cpu"} {
+		err := os.WriteFile(filepath.Join(root, "cgroup.subtree_control"), []byte("+"+controller), 0644)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		enabled = append(enabled, controller)
+	}
+	if len(enabled) == 0 {
+		return fmt.Errorf("no cgroup controller could be enabled: %w", lastErr)
+	}
+	return nil
+}
@@ -0,0 +1,145 @@
+//go:build linux
+// +build linux
+
+package executor
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+const cgroupsSupported = true
+
+// setRlimits applies rlimits to a process with prlimit(2), 0 is the calling process.
+// Children forked afterwards inherit them.
+func setRlimits(pid int, limits ResourceLimits) error {
+	set := func(resource int, value uint64) error {
+		if value == 0 {
+			return nil
+		}
+		return unix.Prlimit(pid, resource, &unix.Rlimit{Cur: value, Max: value}, nil)
+	}
+
+	// The soft CPU limit raises SIGXCPU, the hard limit one second later kills
+	// processes that ignore it.
+	if limits.CPUSeconds > 0 {
+		rlimit := unix.Rlimit{Cur: limits.CPUSeconds, Max: limits.CPUSeconds + 1}
+		if err := unix.Prlimit(pid, unix.RLIMIT_CPU, &rlimit, nil); err != nil {
+			return fmt.Errorf("failed to set CPU limit: %w", err)
+		}
+	}
+	if err := set(unix.RLIMIT_AS, limits.MemoryBytes); err != nil {
+		return fmt.Errorf("failed to set memory limit: %w", err)
+	}
+	if err := set(unix.RLIMIT_NOFILE, limits.OpenFiles); err != nil {
+		return fmt.Errorf("failed to set open file limit: %w", err)
+	}
+	if err := set(unix.RLIMIT_NPROC, limits.Processes); err != nil {
+		return fmt.Errorf("failed to set process limit: %w", err)
+	}
+	return nil
+}
+
+// checkRlimits reports an error if limits cannot be set by an unprivileged process
+// because they exceed the server's own hard limits
+func checkRlimits(limits ResourceLimits) error {
+	if os.Geteuid() == 0 {
+		return nil
+	}
+	check := func(name string, resource int, value uint64) error {
+		if value == 0 {
+			return nil
+		}
+		var current unix.Rlimit
+		if err := unix.Getrlimit(resource, &current); err != nil {
+			return fmt.Errorf("failed to read %s limit: %w", name, err)
+		}
+		if current.Max != unix.RLIM_INFINITY && value > current.Max {
+			return fmt.Errorf("%s limit %d exceeds the hard limit %d of the server", name, value, current.Max)
+		}
+		return nil
+	}
+
+	cpu := limits.CPUSeconds
+	if cpu > 0 {
+		cpu++
+	}
+	if err := check("CPU", unix.RLIMIT_CPU, cpu); err != nil {
+		return err
+	}
+	if err := check("memory", unix.RLIMIT_AS, limits.MemoryBytes); err != nil {
+		return err
+	}
+	if err := check("open file", unix.RLIMIT_NOFILE, limits.OpenFiles); err != nil {
+		return err
+	}
+	return check("process", unix.RLIMIT_NPROC, limits.Processes)
+}
+
+// limitCommand makes cmd run through the limits helper, which applies the rlimits to
+// itself and then execs the tool in place
+func limitCommand(cmd *exec.Cmd, limits ResourceLimits) error {
+	if cmd.Err != nil {
+		// Let Start report the missing binary
+		return nil
+	}
+	data, err := json.Marshal(limits)
+	if err != nil {
+		return err
+	}
+	cmd.Args = append([]string{limitsInitName, string(data), cmd.Path}, cmd.Args...)
+	cmd.Path = "/proc/self/exe"
+	return nil
+}
+
+// runLimitsInit runs in the helper process, it only returns on failure
+func runLimitsInit(limits ResourceLimits, binary string, argv []string) error {
+	unix.CloseOnExec(helperStatusFD)
+	if err := setRlimits(0, limits); err != nil {
+		return err
+	}
+	return unix.Exec(binary, argv, os.Environ())
+}
+
+// openCgroup makes cmd start in the cgroup, clone3(2) places the child there before
+// it runs anything
+func (h *limitHandle) openCgroup(cmd *exec.Cmd) error {
+	fd, err := unix.Open(h.cgroupDir, unix.O_PATH|unix.O_DIRECTORY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return fmt.Errorf("failed to open cgroup: %w", err)
+	}
+	h.cgroupFD = fd
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.UseCgroupFD = true
+	cmd.SysProcAttr.CgroupFD = fd
+	return nil
+}
+
+func (h *limitHandle) closeCgroup() {
+	if h.cgroupFD >= 0 {
+		unix.Close(h.cgroupFD)
+		h.cgroupFD = -1
+	}
+}
+
+// cpuLimitExceeded reports whether the process was killed for exceeding RLIMIT_CPU
+func cpuLimitExceeded(state *os.ProcessState, sandboxed bool, cpuSeconds uint64) bool {
+	sig, ok := terminatingSignal(state, sandboxed)
+	if !ok {
+		return false
+	}
+	if sig == syscall.SIGXCPU {
+		return true
+	}
+	// The usage of a sandboxed tool is included in its init helper's, which reaped it
+	used := state.UserTime() + state.SystemTime()
+	return sig == syscall.SIGKILL && used >= time.Duration(cpuSeconds)*time.Second
+}
@@ -0,0 +1,41 @@
+//go:build !linux
+// +build !linux
+
+package executor
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+)
+
+const cgroupsSupported = false
+
+var errLimitsUnsupported = errors.New("resource limits require Linux")
+
+// checkRlimits fails outside Linux, the limits could not be applied
+func checkRlimits(limits ResourceLimits) error {
+	if limits.IsZero() {
+		return nil
+	}
+	return errLimitsUnsupported
+}
+
+func limitCommand(cmd *exec.Cmd, limits ResourceLimits) error {
+	return errLimitsUnsupported
+}
+
+func runLimitsInit(limits ResourceLimits, binary string, argv []string) error {
+	return errLimitsUnsupported
+}
+
+func (h *limitHandle) openCgroup(cmd *exec.Cmd) error {
+	return errLimitsUnsupported
+}
+
+func (h *limitHandle) closeCgroup() {}
+
+// cpuLimitExceeded is never true outside Linux since no limit is applied
+func cpuLimitExceeded(state *os.ProcessState, sandboxed bool, cpuSeconds uint64) bool {
+	return false
+}
@@ -0,0 +1,125 @@
+package executor
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func TestLimitsFor(t *testing.T) {
+	cfg := LimitsConfig{
+		Default: ResourceLimits{CPUSeconds: 60, OpenFiles: 1024},
+		Tools: map[string]ResourceLimits{
+			"nmap":    {MemoryBytes: 1 << 30},
+			"masscan": {CPUSeconds: 600, Processes: 4},
+		},
+	}
+
+	tests := []struct {
+		tool string
+		want ResourceLimits
+	}{
+		{"ffuf", ResourceLimits{CPUSeconds: 60, OpenFiles: 1024}},
+		{"nmap", ResourceLimits{CPUSeconds: 60, OpenFiles: 1024, MemoryBytes: 1 << 30}},
+		{"masscan", ResourceLimits{CPUSeconds: 600, OpenFiles: 1024, Processes: 4}},
+	}
+	for _, tt := range tests {
+		if got := cfg.For(tt.tool); got != tt.want {
+			t.Errorf("For(%s) = %+v, want %+v", tt.tool, got, tt.want)
+		}
+	}
+}
+
+func TestLimitHandleRlimits(t *testing.T) {
+	limits := ResourceLimits{CPUSeconds: 60, CPUQuota: 0.5, MemoryBytes: 1 << 30, OpenFiles: 1024, Processes: 64}
+
+	tests := []struct {
+		name      string
+		cgroupDir string
+		want      ResourceLimits
+	}{
+		{
+			name: "without cgroup",
+			want: ResourceLimits{CPUSeconds: 60, MemoryBytes: 1 << 30, OpenFiles: 1024, Processes: 64},
+		},
+		{
+			name:      "with cgroup",
+			cgroupDir: "/sys/fs/cgroup/h-ai/h-ai-1",
+			want:      ResourceLimits{CPUSeconds: 60, OpenFiles: 1024},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &limitHandle{limits: limits, cgroupDir: tt.cgroupDir, cgroupFD: -1}
+			if got := h.rlimits(); got != tt.want {
+				t.Errorf("rlimits() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+
+	h := &limitHandle{limits: ResourceLimits{CPUQuota: 2}, cgroupDir: "/sys/fs/cgroup/h-ai/h-ai-1", cgroupFD: -1}
+	if h.needsHelper() {
+		t.Errorf("needsHelper() = true for limits the cgroup enforces on its own")
+	}
+}
+
+func TestLimitHandleFinishReportsBreaches(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[string]string
+		want  []string
+	}{
+		{
+			name: "none",
+			files: map[string]string{
+				"memory.events": "low 0\nhigh 0\nmax 0\noom 0\noom_kill 0\n",
+				"pids.events":   "max 0\n",
+			},
+		},
+		{
+			name: "memory",
+			files: map[string]string{
+				"memory.events": "low 0\nhigh 0\nmax 12\noom 1\noom_kill 1\n",
+				"pids.events":   "max 0\n",
+			},
+			want: []string{"memory"},
+		},
+		{
+			name: "memory and processes",
+			files: map[string]string{
+				"memory.events": "oom_kill 2\n",
+				"pids.events":   "max 5\n",
+			},
+			want: []string{"memory", "processes"},
+		},
+		{
+			// Hitting cpu.max only throttles the process
+			name: "throttling",
+			files: map[string]string{
+				"cpu.stat": "usage_usec 100\nnr_periods 10\nnr_throttled 8\nthrottled_usec 5000\n",
+			},
+		},
+		{
+			name: "counters missing",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for name, content := range tt.files {
+				if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			h := &limitHandle{limits: ResourceLimits{CPUQuota: 0.5}, cgroupDir: dir, cgroupFD: -1, logger: zap.NewNop()}
+			if got := h.finish(nil); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("finish() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,162 @@
+package executor
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+var (
+	// ErrProcessNotFound is returned for PIDs the executor is not running
+	ErrProcessNotFound = errors.New("process not found")
+	// ErrProcessPaused is returned when pausing a process that is already paused
+	ErrProcessPaused = errors.New("process is already paused")
+	// ErrProcessNotPaused is returned when resuming a process that is not paused
+	ErrProcessNotPaused = errors.New("process is not paused")
+	// ErrProcessFinished is returned for processes that already exited
+	ErrProcessFinished = errors.New("process already finished")
+)
+
+// SuspendProcess stops the process group of pid until ResumeProcess is called.
+// The execution timeout does not run while the process is paused.
+func (e *Executor) SuspendProcess(pid int) error {
+	e.processLock.Lock()
+	defer e.processLock.Unlock()
+
+	proc, exists := e.processes[pid]
+	if !exists {
+		return ErrProcessNotFound
+	}
+	if proc.EndTime != nil {
+		return ErrProcessFinished
+	}
+	if proc.PausedAt != nil {
+		return ErrProcessPaused
+	}
+
+	if err := suspendProcessGroup(pid); err != nil {
+		return err
+	}
+	proc.deadline.pause()
+
+	now := time.Now()
+	proc.PausedAt = &now
+	proc.Status = ProcessPaused
+
+	e.logger.Info("Process paused", zap.Int("pid", pid), zap.String("command", proc.Command))
+	return nil
+}
+
+// ResumeProcess continues a process group stopped by SuspendProcess
+func (e *Executor) ResumeProcess(pid int) error {
+	e.processLock.Lock()
+	defer e.processLock.Unlock()
+
+	proc, exists := e.processes[pid]
+	if !exists {
+		return ErrProcessNotFound
+	}
+	if proc.EndTime != nil {
+		return ErrProcessFinished
+	}
+	if proc.PausedAt == nil {
+		return ErrProcessNotPaused
+	}
+
+	if err := resumeProcessGroup(pid); err != nil {
+		return err
+	}
+	proc.deadline.resume()
+
+	paused := time.Since(*proc.PausedAt)
+	proc.pausedTotal += paused
+	proc.PausedAt = nil
+	proc.Status = ProcessRunning
+
+	e.logger.Info("Process resumed",
+		zap.Int("pid", pid),
+		zap.String("command", proc.Command),
+		zap.Duration("paused", paused))
+	return nil
+}
+
+// snapshot copies proc for callers, counting the current pause into PausedDuration
+func (proc *ProcessInfo) snapshot() ProcessInfo {
+	info := *proc
+	paused := proc.pausedTotal
+	if proc.PausedAt != nil {
+		paused += time.Since(*proc.PausedAt)
+	}
+	info.PausedDuration = paused.Seconds()
+	ended := time.Now()
+	if proc.EndTime != nil {
+		ended = *proc.EndTime
+	}
+	info.ElapsedTime = ended.Sub(proc.StartTime).Seconds()
+	return info
+}
+
+// pausableTimer calls its function once it has been running, not paused, for its duration
+type pausableTimer struct {
+	mu        sync.Mutex
+	timer     *time.Timer
+	remaining time.Duration
+	resumed   time.Time
+	paused    bool
+	fired     bool
+}
+
+func newPausableTimer(d time.Duration, f func()) *pausableTimer {
+	t := &pausableTimer{remaining: d, resumed: time.Now()}
+	t.timer = time.AfterFunc(d, func() {
+		t.mu.Lock()
+		t.fired = true
+		t.mu.Unlock()
+		f()
+	})
+	return t
+}
+
+func (t *pausableTimer) pause() {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.paused || t.fired {
+		return
+	}
+	if t.timer.Stop() {
+		t.remaining -= time.Since(t.resumed)
+	}
+	t.paused = true
+}
+
+func (t *pausableTimer) resume() {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if !t.paused || t.fired {
+		return
+	}
+	t.paused = false
+	t.resumed = time.Now()
+	t.timer.Reset(t.remaining)
+}
+
+func (t *pausableTimer) stop() {
+	t.timer.Stop()
+}
+
+// Fired reports whether the timer ran out
+func (t *pausableTimer) Fired() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.fired
+}
@@ -0,0 +1,101 @@
+package executor
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+// ExecutionPlan describes what ExecuteSpec would run for a spec
+type ExecutionPlan struct {
+	Argv    []string `json:"argv"`
+	Command string   `json:"command"`
+	// Path is where the binary was found in PATH, empty with PathError when it was not
+	Path      string `json:"path,omitempty"`
+	PathError string `json:"path_error,omitempty"`
+	WorkDir   string `json:"work_dir"`
+	// Env holds the variables added to the server environment
+	Env   []string `json:"env,omitempty"`
+	Stdin string   `json:"stdin,omitempty"`
+	// Timeout is in seconds
+	Timeout  float64 `json:"timeout"`
+	CacheKey string  `json:"cache_key"`
+	UseCache bool    `json:"use_cache"`
+	// Cached is true when a cached result would be returned instead of running anything
+	Cached    bool            `json:"cached"`
+	Sandboxed bool            `json:"sandboxed"`
+	Network   bool            `json:"network"`
+	Limits    *ResourceLimits `json:"limits,omitempty"`
+	// PolicyViolation is the rule that would deny the execution, with the reason in PolicyError
+	PolicyViolation string `json:"policy_violation,omitempty"`
+	PolicyError     string `json:"policy_error,omitempty"`
+	// Fixture is the recording written in record mode or played back in replay mode
+	Fixture      string `json:"fixture,omitempty"`
+	FixtureError string `json:"fixture_error,omitempty"`
+}
+
+// Plan resolves how spec would be executed, without spawning anything or recording it
+func (e *Executor) Plan(ctx context.Context, spec CommandSpec, useCache bool) ExecutionPlan {
+	tool := filepath.Base(spec.Binary)
+	plan := ExecutionPlan{
+		Argv:      spec.Argv(),
+		Command:   spec.String(),
+		WorkDir:   spec.WorkDir,
+		Env:       append([]string(nil), spec.Env...),
+		Stdin:     spec.Stdin,
+		Timeout:   e.timeoutFor(spec).Seconds(),
+		CacheKey:  spec.cacheKey(),
+		UseCache:  useCache,
+		Sandboxed: e.mode != ExecReplay && e.sandbox.enabledFor(tool),
+		Network:   true,
+	}
+
+	if path, err := exec.LookPath(spec.Binary); err != nil {
+		plan.PathError = err.Error()
+	} else {
+		plan.Path = path
+	}
+
+	if e.mode != ExecLive {
+		plan.Fixture = e.fixturePath(spec)
+		if _, err := os.Stat(plan.Fixture); err != nil && e.mode == ExecReplay {
+			plan.FixtureError = fmt.Sprintf("%v for %s", ErrNoFixture, plan.Command)
+		}
+	}
+
+	if useCache && !freshResults(ctx) {
+		plan.Cached = e.cache.Has(plan.CacheKey)
+	}
+
+	if plan.Sandboxed {
+		plan.Network = e.sandbox.networkFor(tool)
+		// The output directory is created per execution
+		if e.artifacts != nil {
+			outputDir := filepath.Join(e.artifacts.dir, "<execution_id>", outputDirName)
+			if abs, err := filepath.Abs(outputDir); err == nil {
+				outputDir = abs
+			}
+			plan.Env = append(plan.Env, "H_AI_OUTPUT_DIR="+outputDir)
+			if plan.WorkDir == "" {
+				plan.WorkDir = outputDir
+			}
+		}
+		if plan.WorkDir == "" {
+			plan.WorkDir = "/tmp"
+		}
+	} else if plan.WorkDir == "" {
+		plan.WorkDir, _ = os.Getwd()
+	}
+
+	if limits := e.limits.For(tool); !limits.IsZero() {
+		plan.Limits = &limits
+	}
+
+	if rule, err := e.policyDecision(ctx, spec); err != nil {
+		plan.PolicyViolation = rule
+		plan.PolicyError = err.Error()
+	}
+	return plan
+}
@@ -0,0 +1,154 @@
+package executor
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"go.uber.org/zap"
+)
+
+// RecordingName is the artifact holding the asciicast v2 recording of an execution
+const RecordingName = "recording.cast"
+
+// Terminal size recorded for executions that do not run on a terminal
+const (
+	recordingCols = 80
+	recordingRows = 24
+)
+
+// castHeader is the first line of an asciicast v2 file
+type castHeader struct {
+	Version   int               `json:"version"`
+	Width     uint16            `json:"width"`
+	Height    uint16            `json:"height"`
+	Timestamp int64             `json:"timestamp"`
+	Command   string            `json:"command,omitempty"`
+	Title     string            `json:"title,omitempty"`
+	Env       map[string]string `json:"env,omitempty"`
+}
+
+// castRecorder writes the output of an execution as timestamped asciicast events.
+// A nil recorder records nothing.
+type castRecorder struct {
+	mu    sync.Mutex
+	file  *os.File
+	start time.Time
+	// onTerminal is false for piped output, where no terminal turns \n into \r\n
+	onTerminal bool
+	// pending holds an incomplete UTF-8 sequence per stream until the rest arrives
+	pending map[string][]byte
+	err     error
+}
+
+// newRecorder starts the recording of an execution, nil when there is no artifact store
+func (e *Executor) newRecorder(executionID string, spec CommandSpec, cols, rows uint16, onTerminal bool) *castRecorder {
+	if e.artifacts == nil {
+		return nil
+	}
+
+	file, err := e.artifacts.Create(executionID, RecordingName)
+	if err != nil {
+		e.logger.Warn("Failed to create recording", zap.String("execution_id", executionID), zap.Error(err))
+		return nil
+	}
+
+	r := &castRecorder{
+		file:       file,
+		start:      time.Now(),
+		onTerminal: onTerminal,
+		pending:    make(map[string][]byte),
+	}
+	if cols == 0 || rows == 0 {
+		cols, rows = recordingCols, recordingRows
+	}
+
+	header, _ := json.Marshal(castHeader{
+		Version:   2,
+		Width:     cols,
+		Height:    rows,
+		Timestamp: r.start.Unix(),
+		Command:   spec.String(),
+		Title:     spec.ToolName(),
+		Env:       map[string]string{"TERM": "xterm-256color"},
+	})
+	r.writeLine(header)
+	return r
+}
+
+// output records data written by the process to stream
+func (r *castRecorder) output(stream string, data []byte) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	data = append(r.pending[stream], data...)
+	// Keep a trailing partial rune for the next write, invalid bytes are replaced when encoding
+	cut := len(data)
+	for i := len(data) - 1; i >= 0 && i >= len(data)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(data[i]) {
+			if !utf8.FullRune(data[i:]) {
+				cut = i
+			}
+			break
+		}
+	}
+	r.pending[stream] = append([]byte(nil), data[cut:]...)
+	r.event("o", data[:cut])
+}
+
+// resize records a change of the terminal size
+func (r *castRecorder) resize(cols, rows uint16) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.event("r", []byte(fmt.Sprintf("%dx%d", cols, rows)))
+}
+
+// Close flushes incomplete output and closes the file
+func (r *castRecorder) Close() error {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for stream, rest := range r.pending {
+		r.event("o", rest)
+		delete(r.pending, stream)
+	}
+	if err := r.file.Close(); err != nil && r.err == nil {
+		r.err = err
+	}
+	return r.err
+}
+
+// event appends one event line. Callers must hold r.mu.
+func (r *castRecorder) event(code string, data []byte) {
+	if len(data) == 0 {
+		return
+	}
+	if code == "o" && !r.onTerminal {
+		data = bytes.ReplaceAll(data, []byte("\n"), []byte("\r\n"))
+	}
+
+	encoded, _ := json.Marshal(string(data))
+	elapsed := time.Since(r.start).Seconds()
+	r.writeLine([]byte(fmt.Sprintf("[%.6f, %q, %s]", elapsed, code, encoded)))
+}
+
+func (r *castRecorder) writeLine(line []byte) {
+	if r.err != nil {
+		return
+	}
+	_, r.err = r.file.Write(append(line, '\n'))
+}
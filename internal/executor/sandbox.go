@@ -0,0 +1,124 @@
+package executor
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+// SandboxConfig configures the namespace sandbox tools are run in
+type SandboxConfig struct {
+	// ReadOnlyPaths are bind mounted read-only, e.g. wordlist directories
+	ReadOnlyPaths []string `json:"read_only_paths,omitempty"`
+	// Network is the default network access of sandboxed tools
+	Network bool `json:"network"`
+	// Tools overrides the defaults per tool
+	Tools map[string]SandboxToolConfig `json:"tools,omitempty"`
+}
+
+// SandboxToolConfig overrides the sandbox settings of one tool
+type SandboxToolConfig struct {
+	Network *bool `json:"network,omitempty"`
+	// Disabled runs the tool outside the sandbox, e.g. scanners that need raw sockets
+	Disabled bool `json:"disabled,omitempty"`
+}
+
+// DefaultSandboxReadOnlyPaths are the usual wordlist locations
+var DefaultSandboxReadOnlyPaths = []string{"/usr/share/wordlists", "/usr/share/seclists"}
+
+// LoadSandboxConfig reads a SandboxConfig from a JSON file
+func LoadSandboxConfig(path string) (*SandboxConfig, error) {
+	cfg := &SandboxConfig{ReadOnlyPaths: DefaultSandboxReadOnlyPaths}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read sandbox config: %w", err)
+	}
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse sandbox config: %w", err)
+	}
+	return cfg, nil
+}
+
+// enabledFor reports whether tool runs in the sandbox
+func (c *SandboxConfig) enabledFor(tool string) bool {
+	return c != nil && !c.Tools[tool].Disabled
+}
+
+// networkFor reports whether tool keeps network access in the sandbox
+func (c *SandboxConfig) networkFor(tool string) bool {
+	if override := c.Tools[tool].Network; override != nil {
+		return *override
+	}
+	return c.Network
+}
+
+// sandboxInitName is argv[0] of the helper process that sets up the sandbox
+// mounts from inside the new namespaces before running the tool
+const sandboxInitName = "h-ai-sandbox-init"
+
+// sandboxSetup is handed from the executor to the helper process
+type sandboxSetup struct {
+	// Binary is the resolved path of the tool, argv[0] stays as requested
+	Binary        string   `json:"binary"`
+	ReadOnlyPaths []string `json:"read_only_paths,omitempty"`
+	OutputDir     string   `json:"output_dir,omitempty"`
+	WorkDir       string   `json:"work_dir,omitempty"`
+	// Limits are the rlimits applied right before the tool is executed
+	Limits ResourceLimits `json:"limits,omitempty"`
+}
+
+// SandboxInit must be called first thing in main. In the sandbox helper process it
+// prepares the mounts and runs the tool under a minimal init, otherwise it returns at once.
+func SandboxInit() {
+	if filepath.Base(os.Args[0]) != sandboxInitName {
+		return
+	}
+	if len(os.Args) < 3 {
+		fmt.Fprintln(os.Stderr, "h-ai sandbox: missing arguments")
+		os.Exit(126)
+	}
+
+	var setup sandboxSetup
+	if err := json.Unmarshal([]byte(os.Args[1]), &setup); err != nil {
+		fmt.Fprintf(os.Stderr, "h-ai sandbox: invalid setup: %v\n", err)
+		os.Exit(126)
+	}
+
+	// Only returns on failure
+	err := runSandboxInit(setup, os.Args[2:])
+	reportHelperFailure(err)
+	fmt.Fprintf(os.Stderr, "h-ai sandbox: %v\n", err)
+	os.Exit(126)
+}
+
+// sandboxedCommand wraps spec in the sandbox, giving it the execution's output directory
+func (e *Executor) sandboxedCommand(ctx context.Context, spec CommandSpec, executionID string, limits *limitHandle) (*exec.Cmd, error) {
+	setup := sandboxSetup{
+		ReadOnlyPaths: e.sandbox.ReadOnlyPaths,
+		WorkDir:       spec.WorkDir,
+	}
+	if limits != nil {
+		setup.Limits = limits.rlimits()
+	}
+	if e.artifacts != nil {
+		dir, err := e.artifacts.OutputDir(executionID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create output directory: %w", err)
+		}
+		setup.OutputDir = dir
+		spec.Env = append(spec.Env, "H_AI_OUTPUT_DIR="+dir)
+	}
+
+	cmd, err := sandboxCommand(ctx, spec, setup, e.sandbox.networkFor(filepath.Base(spec.Binary)))
+	if err != nil {
+		return nil, err
+	}
+	if len(spec.Env) > 0 {
+		cmd.Env = append(os.Environ(), spec.Env...)
+	}
+	return cmd, nil
+}
@@ -0,0 +1,197 @@
+//go:build linux
+// +build linux
+
+package executor
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+const sandboxSupported = true
+
+// sandboxCommand builds a command that runs spec through the sandbox helper in
+// new user, mount and PID namespaces, and a new network namespace unless network is allowed
+func sandboxCommand(ctx context.Context, spec CommandSpec, setup sandboxSetup, network bool) (*exec.Cmd, error) {
+	binary, err := exec.LookPath(spec.Binary)
+	if err != nil {
+		return nil, err
+	}
+	setup.Binary = binary
+
+	data, err := json.Marshal(setup)
+	if err != nil {
+		return nil, err
+	}
+
+	// Re-exec the server binary as helper, the tool's argv follows the setup
+	cmd := exec.CommandContext(ctx, "/proc/self/exe", append([]string{string(data)}, spec.Argv()...)...)
+	cmd.Args[0] = sandboxInitName
+
+	flags := uintptr(unix.CLONE_NEWUSER | unix.CLONE_NEWNS | unix.CLONE_NEWPID)
+	if !network {
+		flags |= unix.CLONE_NEWNET
+	}
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Setpgid:    true,
+		Cloneflags: flags,
+		// The server user becomes root inside the namespace, with no privileges outside it
+		UidMappings:                []syscall.SysProcIDMap{{ContainerID: 0, HostID: os.Getuid(), Size: 1}},
+		GidMappings:                []syscall.SysProcIDMap{{ContainerID: 0, HostID: os.Getgid(), Size: 1}},
+		GidMappingsEnableSetgroups: false,
+	}
+	return cmd, nil
+}
+
+// runSandboxInit runs in the helper process inside the new namespaces. It makes the
+// filesystem read-only except for a private /tmp and the output directory, then runs
+// the tool under a minimal init.
+func runSandboxInit(setup sandboxSetup, argv []string) error {
+	unix.CloseOnExec(helperStatusFD)
+	if err := unix.Mount("", "/", "", unix.MS_REC|unix.MS_PRIVATE, ""); err != nil {
+		return fmt.Errorf("failed to make mounts private: %w", err)
+	}
+
+	readOnly := &unix.MountAttr{Attr_set: unix.MOUNT_ATTR_RDONLY}
+	if err := unix.MountSetattr(unix.AT_FDCWD, "/", unix.AT_RECURSIVE, readOnly); err != nil {
+		// Kernels before 5.12 cannot do this, the tool must not run with a writable root
+		return fmt.Errorf("failed to make the root read-only: %w", err)
+	}
+
+	// Hold on to the bind mount sources, the private /tmp may hide them
+	type bind struct {
+		path     string
+		fd       int
+		readOnly bool
+	}
+	var binds []bind
+	if setup.OutputDir != "" {
+		fd, err := unix.Open(setup.OutputDir, unix.O_PATH|unix.O_CLOEXEC, 0)
+		if err != nil {
+			return fmt.Errorf("failed to open output directory: %w", err)
+		}
+		binds = append(binds, bind{path: setup.OutputDir, fd: fd})
+	}
+	for _, path := range setup.ReadOnlyPaths {
+		fd, err := unix.Open(path, unix.O_PATH|unix.O_CLOEXEC, 0)
+		if err != nil {
+			continue // not installed on this host
+		}
+		binds = append(binds, bind{path: path, fd: fd, readOnly: true})
+	}
+
+	if err := unix.Mount("tmpfs", "/tmp", "tmpfs", unix.MS_NOSUID|unix.MS_NODEV, "mode=1777"); err != nil {
+		return fmt.Errorf("failed to mount private /tmp: %w", err)
+	}
+
+	for _, b := range binds {
+		if err := os.MkdirAll(b.path, 0750); err != nil {
+			return fmt.Errorf("failed to create mount point %s: %w", b.path, err)
+		}
+		source := fmt.Sprintf("/proc/self/fd/%d", b.fd)
+		if err := unix.Mount(source, b.path, "", unix.MS_BIND|unix.MS_REC, ""); err != nil {
+			return fmt.Errorf("failed to bind %s: %w", b.path, err)
+		}
+		unix.Close(b.fd)
+
+		if b.readOnly {
+			if err := unix.Mount("", b.path, "", unix.MS_BIND|unix.MS_REMOUNT|unix.MS_RDONLY, ""); err != nil {
+				return fmt.Errorf("failed to make %s read-only: %w", b.path, err)
+			}
+			continue
+		}
+		writable := &unix.MountAttr{Attr_clr: unix.MOUNT_ATTR_RDONLY}
+		if err := unix.MountSetattr(unix.AT_FDCWD, b.path, 0, writable); err != nil && err != unix.ENOSYS {
+			return fmt.Errorf("failed to make %s writable: %w", b.path, err)
+		}
+	}
+
+	// A fresh /proc only shows the sandbox's own processes. Not fatal where /proc is locked.
+	unix.Mount("proc", "/proc", "proc", unix.MS_NOSUID|unix.MS_NODEV|unix.MS_NOEXEC, "")
+
+	workDir := setup.WorkDir
+	if workDir == "" {
+		workDir = setup.OutputDir
+	}
+	if workDir == "" {
+		workDir = "/tmp"
+	}
+	if err := os.Chdir(workDir); err != nil {
+		return fmt.Errorf("failed to enter %s: %w", workDir, err)
+	}
+
+	return runInit(setup, argv)
+}
+
+// runInit starts the tool and stays as init of the PID namespace. The kernel only
+// delivers the signals PID 1 handles and makes it the parent of every orphan, so the
+// tool must not run as PID 1 itself. Only returns if the tool could not be started.
+func runInit(setup sandboxSetup, argv []string) error {
+	// Catch everything before the tool can exit, SIGCHLD included
+	signals := make(chan os.Signal, 32)
+	signal.Notify(signals)
+
+	cmd := exec.Command(setup.Binary)
+	cmd.Args = argv
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	status := os.NewFile(helperStatusFD, "status")
+	if !setup.Limits.IsZero() {
+		// The limits helper sets the rlimits in the tool's process, not in init, and
+		// reports its failures on the status pipe itself
+		if err := limitCommand(cmd, setup.Limits); err != nil {
+			return err
+		}
+		cmd.ExtraFiles = []*os.File{status}
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	status.Close()
+	tool := cmd.Process.Pid
+
+	for sig := range signals {
+		switch sig {
+		case unix.SIGCHLD:
+			if ws, done := reapChildren(tool); done {
+				if ws.Signaled() {
+					os.Exit(128 + int(ws.Signal()))
+				}
+				os.Exit(ws.ExitStatus())
+			}
+		// The terminal sends these to the whole foreground group, the tool included.
+		// SIGURG is the Go runtime's own.
+		case unix.SIGINT, unix.SIGQUIT, unix.SIGTSTP, unix.SIGTTIN, unix.SIGTTOU,
+			unix.SIGWINCH, unix.SIGURG, unix.SIGPIPE:
+		default:
+			unix.Kill(tool, sig.(syscall.Signal))
+		}
+	}
+	return nil
+}
+
+// reapChildren collects every exited child, true once the tool is among them.
+// Exiting then kills whatever the tool left running in the namespace.
+func reapChildren(tool int) (unix.WaitStatus, bool) {
+	var toolStatus unix.WaitStatus
+	toolExited := false
+	for {
+		var ws unix.WaitStatus
+		pid, err := unix.Wait4(-1, &ws, unix.WNOHANG, nil)
+		if err == unix.EINTR {
+			continue
+		}
+		if err != nil || pid <= 0 {
+			return toolStatus, toolExited
+		}
+		if pid == tool {
+			toolStatus, toolExited = ws, true
+		}
+	}
+}
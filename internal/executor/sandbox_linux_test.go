@@ -0,0 +1,103 @@
+//go:build linux
+// +build linux
+
+package executor
+
+import (
+	"context"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// The sandbox re-executes the test binary as its helper
+func TestMain(m *testing.M) {
+	SandboxInit()
+	LimitsInit()
+	os.Exit(m.Run())
+}
+
+// startSandboxed starts spec in the sandbox and waits until the tool runs
+func startSandboxed(t *testing.T, spec CommandSpec, setup sandboxSetup) *os.Process {
+	t.Helper()
+	cmd, err := sandboxCommand(context.Background(), spec, setup, true)
+	if err != nil {
+		t.Fatalf("sandboxCommand: %v", err)
+	}
+	helper, err := watchHelper(cmd)
+	if err != nil {
+		t.Fatalf("watchHelper: %v", err)
+	}
+	if err := cmd.Start(); err != nil {
+		helper.close()
+		t.Skipf("user namespaces are not available: %v", err)
+	}
+	if err := helper.wait(); err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		t.Skipf("sandbox is not supported here: %v", err)
+	}
+	return cmd.Process
+}
+
+func TestSandboxTerminate(t *testing.T) {
+	// terminateProcess gives the tool this long before it kills the group
+	const gracePeriod = 2 * time.Second
+
+	tests := []struct {
+		name   string
+		setup  sandboxSetup
+		signal func(pid int) error
+	}{
+		{
+			name:   "process group",
+			signal: func(pid int) error { return syscall.Kill(-pid, syscall.SIGTERM) },
+		},
+		{
+			name:   "helper only",
+			signal: func(pid int) error { return syscall.Kill(pid, syscall.SIGTERM) },
+		},
+		{
+			name:   "with rlimits",
+			setup:  sandboxSetup{Limits: ResourceLimits{OpenFiles: 64}},
+			signal: func(pid int) error { return syscall.Kill(-pid, syscall.SIGTERM) },
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			process := startSandboxed(t, CommandSpec{Binary: "sleep", Args: []string{"30"}}, tt.setup)
+			if err := tt.signal(process.Pid); err != nil {
+				t.Fatalf("signal: %v", err)
+			}
+
+			exited := make(chan *os.ProcessState, 1)
+			go func() {
+				state, _ := process.Wait()
+				exited <- state
+			}()
+			select {
+			case state := <-exited:
+				if got := exitSignal(state, true); got != "SIGTERM" {
+					t.Errorf("exitSignal = %q (%v), want SIGTERM", got, state)
+				}
+			case <-time.After(gracePeriod):
+				syscall.Kill(-process.Pid, syscall.SIGKILL)
+				<-exited
+				t.Fatalf("sandboxed sleep still running %v after SIGTERM", gracePeriod)
+			}
+		})
+	}
+}
+
+func TestSandboxExitStatus(t *testing.T) {
+	process := startSandboxed(t, CommandSpec{Binary: "sh", Args: []string{"-c", "sleep 0.1 & exit 3"}}, sandboxSetup{})
+	state, err := process.Wait()
+	if err != nil {
+		t.Fatalf("Wait: %v", err)
+	}
+	if state.ExitCode() != 3 || exitSignal(state, true) != "" {
+		t.Errorf("exit = %v, want exit status 3", state)
+	}
+}
@@ -0,0 +1,22 @@
+//go:build !linux
+// +build !linux
+
+package executor
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+)
+
+const sandboxSupported = false
+
+var errSandboxUnsupported = errors.New("the sandbox requires Linux namespaces")
+
+func sandboxCommand(ctx context.Context, spec CommandSpec, setup sandboxSetup, network bool) (*exec.Cmd, error) {
+	return nil, errSandboxUnsupported
+}
+
+func runSandboxInit(setup sandboxSetup, argv []string) error {
+	return errSandboxUnsupported
+}
@@ -0,0 +1,301 @@
+package executor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/LeHTVy/h_ai/internal/utils"
+)
+
+// Priority orders queued executions, higher values run first
+type Priority int
+
+const (
+	PriorityBackground  Priority = 0
+	PriorityInteractive Priority = 10
+)
+
+func (p Priority) String() string {
+	if p >= PriorityInteractive {
+		return "interactive"
+	}
+	return "background"
+}
+
+const priorityKey contextKey = "priority"
+
+// WithPriority sets the queue priority of processes spawned under ctx
+func WithPriority(ctx context.Context, priority Priority) context.Context {
+	return context.WithValue(ctx, priorityKey, priority)
+}
+
+// PriorityFromContext returns the priority attached to ctx, interactive by default
+func PriorityFromContext(ctx context.Context) Priority {
+	if priority, ok := ctx.Value(priorityKey).(Priority); ok {
+		return priority
+	}
+	return PriorityInteractive
+}
+
+// ErrQueueFull is returned when an execution is rejected because the queue is at capacity
+var ErrQueueFull = errors.New("execution queue is full")
+
+// ErrShuttingDown is returned for executions rejected because the server is shutting down
+var ErrShuttingDown = errors.New("server is shutting down")
+
+// SchedulerConfig holds the concurrency limits of the scheduler
+type SchedulerConfig struct {
+	// MaxConcurrent bounds the number of processes running at once, 0 means unlimited
+	MaxConcurrent int
+	// ToolLimits bounds concurrent processes per binary name
+	ToolLimits map[string]int
+	// MaxQueue bounds the number of waiting executions, 0 means unlimited
+	MaxQueue int
+}
+
+// QueuedExecution describes an execution waiting for a slot
+type QueuedExecution struct {
+	ID       string    `json:"id"`
+	Tool     string    `json:"tool"`
+	Command  string    `json:"command"`
+	Priority string    `json:"priority"`
+	JobID    string    `json:"job_id,omitempty"`
+	QueuedAt time.Time `json:"queued_at"`
+	Position int       `json:"position"`
+}
+
+type waiter struct {
+	info     QueuedExecution
+	priority Priority
+	seq      uint64
+	ready    chan struct{}
+	granted  bool
+	err      error
+}
+
+// Scheduler hands out execution slots subject to global and per-tool limits.
+// Waiting executions are served by priority, then in arrival order.
+type Scheduler struct {
+	cfg SchedulerConfig
+
+	mu          sync.Mutex
+	running     int
+	runningTool map[string]int
+	waiting     []*waiter
+	seq         uint64
+	closed      bool
+}
+
+func NewScheduler(cfg SchedulerConfig) *Scheduler {
+	if cfg.ToolLimits == nil {
+		cfg.ToolLimits = make(map[string]int)
+	}
+	return &Scheduler{
+		cfg:         cfg,
+		runningTool: make(map[string]int),
+	}
+}
+
+// Acquire blocks until the execution may start and returns a function releasing its slot.
+// It fails fast with ErrQueueFull when the queue is at capacity, or with ctx.Err()
+// when the caller gives up while waiting.
+func (s *Scheduler) Acquire(ctx context.Context, tool string, command string) (func(), error) {
+	priority := PriorityFromContext(ctx)
+
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil, ErrShuttingDown
+	}
+	if s.cfg.MaxQueue > 0 && len(s.waiting) >= s.cfg.MaxQueue && !s.canRun(tool) {
+		s.mu.Unlock()
+		return nil, ErrQueueFull
+	}
+
+	s.seq++
+	w := &waiter{
+		info: QueuedExecution{
+			ID:       utils.NewID(),
+			Tool:     tool,
+			Command:  command,
+			Priority: priority.String(),
+			JobID:    JobIDFromContext(ctx),
+			QueuedAt: time.Now(),
+		},
+		priority: priority,
+		seq:      s.seq,
+		ready:    make(chan struct{}),
+	}
+	s.enqueue(w)
+	s.dispatch()
+	s.mu.Unlock()
+
+	release := func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.running--
+		s.runningTool[tool]--
+		s.dispatch()
+	}
+
+	select {
+	case <-w.ready:
+		if w.err != nil {
+			return nil, w.err
+		}
+		return release, nil
+	case <-ctx.Done():
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if w.err != nil {
+			return nil, w.err
+		}
+		if w.granted {
+			// Lost the race with dispatch, hand the slot back
+			s.running--
+			s.runningTool[tool]--
+			s.dispatch()
+		} else {
+			s.remove(w)
+		}
+		return nil, ctx.Err()
+	}
+}
+
+// Close stops handing out slots. Waiting and future executions fail with ErrShuttingDown,
+// running ones keep their slot until released.
+func (s *Scheduler) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.closed = true
+	for _, w := range s.waiting {
+		w.err = ErrShuttingDown
+		close(w.ready)
+	}
+	s.waiting = nil
+}
+
+// Queued returns the waiting executions with their queue positions
+func (s *Scheduler) Queued() []QueuedExecution {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	queued := make([]QueuedExecution, len(s.waiting))
+	for i, w := range s.waiting {
+		queued[i] = w.info
+		queued[i].Position = i + 1
+	}
+	return queued
+}
+
+// Running returns the number of executions holding a slot
+func (s *Scheduler) Running() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.running
+}
+
+// Stats returns a summary of slot usage
+func (s *Scheduler) Stats() map[string]interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	runningTool := make(map[string]int)
+	for tool, count := range s.runningTool {
+		if count > 0 {
+			runningTool[tool] = count
+		}
+	}
+
+	return map[string]interface{}{
+		"running":         s.running,
+		"running_by_tool": runningTool,
+		"queued":          len(s.waiting),
+		"max_concurrent":  s.cfg.MaxConcurrent,
+		"max_queue":       s.cfg.MaxQueue,
+		"tool_limits":     s.cfg.ToolLimits,
+	}
+}
+
+// canRun reports whether a slot is free for tool. Callers must hold s.mu.
+func (s *Scheduler) canRun(tool string) bool {
+	if s.cfg.MaxConcurrent > 0 && s.running >= s.cfg.MaxConcurrent {
+		return false
+	}
+	if limit, ok := s.cfg.ToolLimits[tool]; ok && limit > 0 && s.runningTool[tool] >= limit {
+		return false
+	}
+	return true
+}
+
+// enqueue inserts w keeping the queue ordered by priority, then arrival. Callers must hold s.mu.
+func (s *Scheduler) enqueue(w *waiter) {
+	i := sort.Search(len(s.waiting), func(i int) bool {
+		other := s.waiting[i]
+		return other.priority < w.priority || (other.priority == w.priority && other.seq > w.seq)
+	})
+	s.waiting = append(s.waiting, nil)
+	copy(s.waiting[i+1:], s.waiting[i:])
+	s.waiting[i] = w
+}
+
+// dispatch grants slots to waiters in queue order. A waiter blocked by its tool
+// limit does not hold back waiters for other tools. Callers must hold s.mu.
+func (s *Scheduler) dispatch() {
+	if s.closed {
+		return
+	}
+	remaining := s.waiting[:0]
+	for _, w := range s.waiting {
+		if s.canRun(w.info.Tool) {
+			s.running++
+			s.runningTool[w.info.Tool]++
+			w.granted = true
+			close(w.ready)
+			continue
+		}
+		remaining = append(remaining, w)
+	}
+	for i := len(remaining); i < len(s.waiting); i++ {
+		s.waiting[i] = nil
+	}
+	s.waiting = remaining
+}
+
+// remove drops w from the queue. Callers must hold s.mu.
+func (s *Scheduler) remove(w *waiter) {
+	for i, other := range s.waiting {
+		if other == w {
+			s.waiting = append(s.waiting[:i], s.waiting[i+1:]...)
+			return
+		}
+	}
+}
+
+// ParseToolLimits parses a "tool=limit,tool=limit" list
+func ParseToolLimits(s string) (map[string]int, error) {
+	limits := make(map[string]int)
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		tool, value, found := strings.Cut(part, "=")
+		if !found {
+			return nil, fmt.Errorf("invalid tool limit %q, expected tool=limit", part)
+		}
+		limit, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil || limit < 0 {
+			return nil, fmt.Errorf("invalid limit for %s: %q", tool, value)
+		}
+		limits[strings.TrimSpace(tool)] = limit
+	}
+	return limits, nil
+}
@@ -0,0 +1,556 @@
+package executor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/creack/pty"
+	"go.uber.org/zap"
+
+	"github.com/LeHTVy/h_ai/internal/policy"
+	"github.com/LeHTVy/h_ai/internal/utils"
+)
+
+const (
+	// sessionScrollback bounds the output kept for incremental reads of a session
+	sessionScrollback = 1024 * 1024
+	// sessionIdleTimeout closes sessions that received no input for this long
+	sessionIdleTimeout = 30 * time.Minute
+	// sessionMaxLifetime closes sessions that ran this long, however busy. Time spent
+	// paused does not count.
+	sessionMaxLifetime = 8 * time.Hour
+	// sessionReadBuffer is the size of a single read from the terminal
+	sessionReadBuffer = 32 * 1024
+	// interruptKey is sent in place of a denied line, it discards what was typed of it
+	interruptKey = 0x03
+)
+
+// ErrSessionClosed is returned when interacting with a session whose process exited
+var ErrSessionClosed = errors.New("session is closed")
+
+// ErrTooManySessions is returned when opening a session while the session limit is reached
+var ErrTooManySessions = errors.New("too many interactive sessions are running")
+
+// SessionInfo describes an interactive terminal session
+type SessionInfo struct {
+	ID         string     `json:"id"`
+	Command    string     `json:"command"`
+	PID        int        `json:"pid"`
+	StartTime  time.Time  `json:"start_time"`
+	EndTime    *time.Time `json:"end_time,omitempty"`
+	Status     string     `json:"status"`
+	ExitCode   *int       `json:"exit_code,omitempty"`
+	Cols       uint16     `json:"cols"`
+	Rows       uint16     `json:"rows"`
+	ArtifactID string     `json:"artifact_id,omitempty"`
+	Recording  string     `json:"recording,omitempty"`
+	Sandboxed  bool       `json:"sandboxed,omitempty"`
+}
+
+// Session is a process attached to a pseudo-terminal, driven through Write and Resize.
+// Its output is published on an OutputStream and kept in a scrollback buffer.
+type Session struct {
+	executor *Executor
+	ctx      context.Context
+	cancel   context.CancelFunc
+	spec     CommandSpec
+	cmd      *exec.Cmd
+	pty      *os.File
+	stream   *OutputStream
+	output   *streamWriter
+	recorder *castRecorder
+	limits   *limitHandle
+	deadline *pausableTimer
+
+	// input serializes Write so lines are checked in the order they reach the terminal
+	input      sync.Mutex
+	line       []byte // what was typed since the last line ended
+	lineEdited bool   // the line was edited with keys screenInput cannot follow
+
+	mu         sync.Mutex
+	info       SessionInfo
+	scrollback []byte
+	base       int64 // output offset of scrollback[0]
+	lastInput  time.Time
+	done       chan struct{}
+}
+
+// OpenSession starts spec on a pseudo-terminal of the given size, failing with
+// ErrTooManySessions when the session limit is reached. The session
+// outlives ctx, which only provides the caller for the policy and the history.
+func (e *Executor) OpenSession(ctx context.Context, spec CommandSpec, cols, rows uint16) (SessionInfo, error) {
+	spec.interactive = true
+	if _, err := e.evaluatePolicy(ctx, spec); err != nil {
+		return SessionInfo{}, err
+	}
+	if cols == 0 {
+		cols = 80
+	}
+	if rows == 0 {
+		rows = 24
+	}
+
+	if !e.reserveSession() {
+		return SessionInfo{}, ErrTooManySessions
+	}
+	info, err := e.startSession(ctx, spec, cols, rows)
+	if err != nil {
+		e.releaseSession()
+	}
+	return info, err
+}
+
+// startSession spawns the session's process, it holds a reserved session slot
+func (e *Executor) startSession(ctx context.Context, spec CommandSpec, cols, rows uint16) (SessionInfo, error) {
+	id := utils.NewID()
+	sessionCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	sandboxed := e.sandbox.enabledFor(filepath.Base(spec.Binary))
+
+	// Sessions get the same resource limits as the executions of the tool
+	var limits *limitHandle
+	if toolLimits := e.limits.For(filepath.Base(spec.Binary)); !toolLimits.IsZero() {
+		var err error
+		if limits, err = e.prepareLimits(id, toolLimits); err != nil {
+			cancel()
+			return SessionInfo{}, fmt.Errorf("failed to start session: %w", err)
+		}
+	}
+
+	var cmd *exec.Cmd
+	if sandboxed {
+		var err error
+		if cmd, err = e.sandboxedCommand(sessionCtx, spec, id, limits); err != nil {
+			cancel()
+			limits.finish(nil)
+			return SessionInfo{}, err
+		}
+	} else {
+		cmd = exec.CommandContext(sessionCtx, spec.Binary, spec.Args...)
+		cmd.Env = append(os.Environ(), spec.Env...)
+		cmd.Dir = spec.WorkDir
+	}
+	if cmd.Env == nil {
+		cmd.Env = os.Environ()
+	}
+	cmd.Env = append(cmd.Env, "TERM=xterm-256color")
+	// The session leader's process group holds everything started from the terminal
+	cmd.Cancel = func() error {
+		return killProcessGroup(cmd.Process.Pid)
+	}
+
+	if limits != nil {
+		if err := limits.attach(cmd, sandboxed); err != nil {
+			cancel()
+			limits.finish(nil)
+			return SessionInfo{}, fmt.Errorf("failed to start session: %w", err)
+		}
+	}
+	var helper *helperStatus
+	if sandboxed || (limits != nil && limits.needsHelper()) {
+		var err error
+		if helper, err = watchHelper(cmd); err != nil {
+			cancel()
+			limits.finish(nil)
+			return SessionInfo{}, fmt.Errorf("failed to start session: %w", err)
+		}
+	}
+
+	stream := newOutputStream(JobIDFromContext(ctx))
+	s := &Session{
+		executor: e,
+		ctx:      sessionCtx,
+		cancel:   cancel,
+		spec:     spec,
+		cmd:      cmd,
+		stream:   stream,
+		output:   e.newStreamWriter(id, "pty", stream),
+		limits:   limits,
+		done:     make(chan struct{}),
+	}
+	s.recorder = e.newRecorder(id, spec, cols, rows, true)
+	s.output.recorder = s.recorder
+
+	terminal, err := startPTY(cmd, cols, rows)
+	if err != nil {
+		cancel()
+		if helper != nil {
+			helper.close()
+		}
+		limits.finish(nil)
+		s.output.Close()
+		s.recorder.Close()
+		return SessionInfo{}, fmt.Errorf("failed to start session: %w", err)
+	}
+	if limits != nil {
+		limits.started()
+	}
+	if helper != nil {
+		if err := helper.wait(); err != nil {
+			cancel()
+			terminal.Close()
+			cmd.Wait()
+			limits.finish(nil)
+			s.output.Close()
+			s.recorder.Close()
+			return SessionInfo{}, fmt.Errorf("failed to start session: %w", err)
+		}
+	}
+	s.pty = terminal
+	s.deadline = newPausableTimer(sessionMaxLifetime, cancel)
+
+	pid := cmd.Process.Pid
+	s.info = SessionInfo{
+		ID:        id,
+		Command:   spec.String(),
+		PID:       pid,
+		StartTime: time.Now(),
+		Status:    "running",
+		Cols:      cols,
+		Rows:      rows,
+		Sandboxed: sandboxed,
+	}
+	if e.artifacts != nil {
+		s.info.ArtifactID = id
+	}
+	if s.recorder != nil {
+		s.info.Recording = RecordingName
+	}
+	s.lastInput = s.info.StartTime
+
+	e.registerProcess(pid, id, spec.String(), JobIDFromContext(ctx), s.deadline)
+	e.registerStream(pid, stream)
+
+	e.sessionLock.Lock()
+	e.sessions[id] = s
+	e.sessionLock.Unlock()
+
+	e.logger.Info("Session opened", zap.String("session_id", id), zap.Int("pid", pid), zap.String("command", spec.String()))
+
+	readerDone := make(chan struct{})
+	go s.pump(readerDone)
+	go s.wait(readerDone)
+	go s.watchIdle()
+
+	return s.Info(), nil
+}
+
+// reserveSession takes a session slot, false when the session limit is reached
+func (e *Executor) reserveSession() bool {
+	e.sessionLock.Lock()
+	defer e.sessionLock.Unlock()
+	if e.maxSessions > 0 && e.running >= e.maxSessions {
+		return false
+	}
+	e.running++
+	return true
+}
+
+// releaseSession hands back the slot of a session whose process exited
+func (e *Executor) releaseSession() {
+	e.sessionLock.Lock()
+	defer e.sessionLock.Unlock()
+	e.running--
+}
+
+// Session returns the session with the given ID
+func (e *Executor) Session(id string) (*Session, bool) {
+	e.sessionLock.RLock()
+	defer e.sessionLock.RUnlock()
+
+	s, found := e.sessions[id]
+	return s, found
+}
+
+// ListSessions returns all open and recently closed sessions
+func (e *Executor) ListSessions() []SessionInfo {
+	e.sessionLock.RLock()
+	defer e.sessionLock.RUnlock()
+
+	sessions := make([]SessionInfo, 0, len(e.sessions))
+	for _, s := range e.sessions {
+		sessions = append(sessions, s.Info())
+	}
+	return sessions
+}
+
+// CloseSessions terminates every running session
+func (e *Executor) CloseSessions() {
+	e.sessionLock.RLock()
+	sessions := make([]*Session, 0, len(e.sessions))
+	for _, s := range e.sessions {
+		sessions = append(sessions, s)
+	}
+	e.sessionLock.RUnlock()
+
+	for _, s := range sessions {
+		s.Close()
+	}
+}
+
+// Info returns a snapshot of the session state
+func (s *Session) Info() SessionInfo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.info
+}
+
+// Stream returns the live output of the session
+func (s *Session) Stream() *OutputStream {
+	return s.stream
+}
+
+// Done is closed once the session's process exited
+func (s *Session) Done() <-chan struct{} {
+	return s.done
+}
+
+// Write sends input to the terminal. Lines are checked against the denied patterns of
+// the policy as they are entered, input completing a denied line is dropped and the
+// line discarded.
+func (s *Session) Write(data []byte) error {
+	select {
+	case <-s.done:
+		return ErrSessionClosed
+	default:
+	}
+
+	s.mu.Lock()
+	s.lastInput = time.Now()
+	s.mu.Unlock()
+
+	s.input.Lock()
+	defer s.input.Unlock()
+	if err := s.screenInput(data); err != nil {
+		s.executor.logger.Warn("Session input denied by policy", zap.String("session_id", s.Info().ID), zap.Error(err))
+		s.pty.Write([]byte{interruptKey})
+		return err
+	}
+	_, err := s.pty.Write(data)
+	return err
+}
+
+// screenInput follows the line being typed and checks every line data ends. A line
+// edited with keys whose effect depends on the program, like arrows, Tab or history
+// recall, cannot be checked and is refused. It holds s.input.
+func (s *Session) screenInput(data []byte) error {
+	engine := s.executor.policy
+	if engine == nil || !engine.ScreensInput() {
+		return nil
+	}
+
+	line, edited := s.line, s.lineEdited
+	for _, b := range data {
+		switch {
+		case b == '\r' || b == '\n':
+			err := engine.CheckInput(string(line))
+			if err == nil && edited {
+				err = &policy.Violation{Rule: "denied_patterns", Reason: "a line edited with control keys cannot be checked, retype it"}
+			}
+			if err != nil {
+				s.line, s.lineEdited = s.line[:0], false
+				return err
+			}
+			line, edited = line[:0], false
+		case b == 0x7f || b == '\b':
+			if len(line) > 0 {
+				_, size := utf8.DecodeLastRune(line)
+				line = line[:len(line)-size]
+			}
+		case b == interruptKey:
+			line, edited = line[:0], false
+		case b == 0x15 && !edited:
+			// Ctrl-U only clears the whole line with the cursor at its end
+			line = line[:0]
+		case b < 0x20:
+			edited = true
+		default:
+			line = append(line, b)
+		}
+	}
+	s.line, s.lineEdited = line, edited
+	return nil
+}
+
+// Resize changes the terminal size
+func (s *Session) Resize(cols, rows uint16) error {
+	select {
+	case <-s.done:
+		return ErrSessionClosed
+	default:
+	}
+	if cols == 0 || rows == 0 {
+		return fmt.Errorf("invalid terminal size %dx%d", cols, rows)
+	}
+
+	if err := pty.Setsize(s.pty, &pty.Winsize{Cols: cols, Rows: rows}); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.info.Cols, s.info.Rows = cols, rows
+	s.mu.Unlock()
+	s.recorder.resize(cols, rows)
+	return nil
+}
+
+// Read returns the output from offset on and the offset to continue from.
+// Output older than the scrollback is skipped, reported by skipped > 0.
+func (s *Session) Read(offset int64) (data []byte, next int64, skipped int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	end := s.base + int64(len(s.scrollback))
+	if offset < s.base {
+		skipped = s.base - offset
+		offset = s.base
+	}
+	if offset > end {
+		offset = end
+	}
+
+	data = make([]byte, end-offset)
+	copy(data, s.scrollback[offset-s.base:])
+	return data, end, skipped
+}
+
+// Attach returns the scrollback and a channel of the output that follows it.
+// The channel is closed when the session ends or cancel is called.
+func (s *Session) Attach() (scrollback []byte, chunks <-chan OutputChunk, cancel func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	scrollback = make([]byte, len(s.scrollback))
+	copy(scrollback, s.scrollback)
+	_, chunks, cancel = s.stream.Subscribe()
+	return scrollback, chunks, cancel
+}
+
+// Close terminates the session's process group and waits for it to exit
+func (s *Session) Close() {
+	s.cancel()
+	<-s.done
+}
+
+// pump copies terminal output to the stream and the scrollback
+func (s *Session) pump(readerDone chan<- struct{}) {
+	defer close(readerDone)
+
+	buf := make([]byte, sessionReadBuffer)
+	for {
+		n, err := s.pty.Read(buf)
+		if n > 0 {
+			// Publish under the lock so Attach sees every chunk exactly once
+			s.mu.Lock()
+			s.output.Write(buf[:n])
+			s.scrollback = append(s.scrollback, buf[:n]...)
+			if over := len(s.scrollback) - sessionScrollback; over > 0 {
+				s.scrollback = append(s.scrollback[:0], s.scrollback[over:]...)
+				s.base += int64(over)
+			}
+			s.mu.Unlock()
+		}
+		if err != nil {
+			// EIO once the last process holding the terminal exits
+			return
+		}
+	}
+}
+
+// wait reaps the process and releases everything the session holds
+func (s *Session) wait(readerDone <-chan struct{}) {
+	err := s.cmd.Wait()
+	s.deadline.stop()
+	timedOut := s.deadline.Fired()
+	closed := s.ctx.Err() != nil && !timedOut
+	breaches := s.limits.finish(s.cmd.ProcessState)
+	e := s.executor
+	e.releaseSession()
+
+	// Give the reader a moment to drain, background children may keep the terminal open
+	select {
+	case <-readerDone:
+	case <-time.After(2 * time.Second):
+	}
+	s.pty.Close()
+	<-readerDone
+	s.output.Close()
+	s.recorder.Close()
+	s.cancel()
+
+	pid := s.cmd.Process.Pid
+	e.releaseStream(pid, s.stream)
+
+	exitCode := 0
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		exitCode = exitErr.ExitCode()
+	}
+
+	ended := time.Now()
+	s.mu.Lock()
+	s.info.Status = "exited"
+	s.info.EndTime = &ended
+	s.info.ExitCode = &exitCode
+	info := s.info
+	s.mu.Unlock()
+	close(s.done)
+
+	e.logger.Info("Session closed", zap.String("session_id", info.ID), zap.Int("exit_code", exitCode))
+
+	result := ExecutionResult{
+		Success:       exitCode == 0,
+		ReturnCode:    exitCode,
+		ExecutionTime: ended.Sub(info.StartTime).Seconds(),
+		PID:           pid,
+		ExecutionID:   info.ID,
+		StdoutBytes:   s.output.Total(),
+		ArtifactID:    info.ArtifactID,
+		Recording:     info.Recording,
+		Sandboxed:     info.Sandboxed,
+		Cancelled:     closed,
+		TimedOut:      timedOut,
+		LimitBreaches: breaches,
+	}
+	if len(breaches) > 0 {
+		e.logger.Warn("Session hit resource limits", zap.String("session_id", info.ID), zap.Strings("limits", breaches))
+	}
+	result.classifyExit(s.cmd.ProcessState)
+	e.finishProcess(pid, result)
+	e.recordHistory(s.ctx, s.spec, info.StartTime, result)
+
+	// Keep the session around for late readers, like finished output streams
+	time.AfterFunc(streamRetention, func() {
+		e.sessionLock.Lock()
+		defer e.sessionLock.Unlock()
+		delete(e.sessions, info.ID)
+	})
+}
+
+// watchIdle closes the session once it received no input for sessionIdleTimeout
+func (s *Session) watchIdle() {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			s.mu.Lock()
+			idle := time.Since(s.lastInput)
+			s.mu.Unlock()
+			if idle >= sessionIdleTimeout {
+				s.executor.logger.Info("Closing idle session", zap.String("session_id", s.info.ID))
+				s.cancel()
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,20 @@
+//go:build !windows
+// +build !windows
+
+package executor
+
+import (
+	"os"
+	"os/exec"
+
+	"github.com/creack/pty"
+)
+
+// startPTY starts cmd as session leader with a pseudo-terminal as controlling terminal
+func startPTY(cmd *exec.Cmd, cols, rows uint16) (*os.File, error) {
+	// setsid(2) fails for process group leaders
+	if cmd.SysProcAttr != nil {
+		cmd.SysProcAttr.Setpgid = false
+	}
+	return pty.StartWithSize(cmd, &pty.Winsize{Cols: cols, Rows: rows})
+}
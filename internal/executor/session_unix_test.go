@@ -0,0 +1,122 @@
+//go:build !windows
+// +build !windows
+
+package executor
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/LeHTVy/h_ai/internal/cache"
+	"github.com/LeHTVy/h_ai/internal/policy"
+)
+
+func TestOpenSessionLimit(t *testing.T) {
+	results := cache.New(cache.NewMemory(), 0, time.Minute, time.Minute)
+	defer results.Close()
+	cfg := DefaultConfig()
+	cfg.MaxSessions = 2
+	e := New(zap.NewNop(), results, cfg)
+	defer e.CloseSessions()
+
+	spec := CommandSpec{Binary: "sleep", Args: []string{"30"}}
+	open := func() (SessionInfo, error) {
+		return e.OpenSession(context.Background(), spec, 80, 24)
+	}
+
+	first, err := open()
+	if err != nil {
+		t.Fatalf("OpenSession: %v", err)
+	}
+	if _, err := open(); err != nil {
+		t.Fatalf("OpenSession: %v", err)
+	}
+	if _, err := open(); !errors.Is(err, ErrTooManySessions) {
+		t.Fatalf("OpenSession over the limit = %v, want ErrTooManySessions", err)
+	}
+
+	// A closed session hands its slot back
+	session, _ := e.Session(first.ID)
+	session.Close()
+	if _, err := open(); err != nil {
+		t.Fatalf("OpenSession after closing one: %v", err)
+	}
+
+	// So does one that failed to start
+	e.CloseSessions()
+	missing := CommandSpec{Binary: "/nonexistent/h-ai-test"}
+	for i := 0; i < 3; i++ {
+		if _, err := e.OpenSession(context.Background(), missing, 80, 24); err == nil || errors.Is(err, ErrTooManySessions) {
+			t.Fatalf("OpenSession of a missing binary = %v, want a start error", err)
+		}
+	}
+	if _, err := open(); err != nil {
+		t.Fatalf("OpenSession after failed starts: %v", err)
+	}
+}
+
+func TestSessionScreensInput(t *testing.T) {
+	engine, err := policy.New(policy.Config{DeniedPatterns: []string{`^\s*!`}})
+	if err != nil {
+		t.Fatalf("policy.New: %v", err)
+	}
+	results := cache.New(cache.NewMemory(), 0, time.Minute, time.Minute)
+	defer results.Close()
+	cfg := DefaultConfig()
+	cfg.Policy = engine
+	e := New(zap.NewNop(), results, cfg)
+	defer e.CloseSessions()
+
+	// Denied lines are discarded with Ctrl-C, which must not end the session
+	spec := CommandSpec{Binary: "sh", Args: []string{"-c", `trap "" INT; echo ready; exec cat`}}
+	info, err := e.OpenSession(context.Background(), spec, 80, 24)
+	if err != nil {
+		t.Fatalf("OpenSession: %v", err)
+	}
+	session, _ := e.Session(info.ID)
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		if output, _, _ := session.Read(0); strings.Contains(string(output), "ready") {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("session did not start")
+		}
+	}
+
+	tests := []struct {
+		name   string
+		input  []string
+		denied bool
+	}{
+		{name: "plain line", input: []string{"use auxiliary/x\r"}},
+		{name: "denied line", input: []string{"!sh\r"}, denied: true},
+		{name: "typed in pieces", input: []string{"!", "s", "h", "\r"}, denied: true},
+		{name: "several lines at once", input: []string{"help\r!sh\r"}, denied: true},
+		{name: "backspace", input: []string{"x\x7f!sh\r"}, denied: true},
+		{name: "backspace over the pattern", input: []string{"!\x7fsh\r"}},
+		{name: "interrupted", input: []string{"!sh\x03help\r"}},
+		{name: "cursor moved", input: []string{"sh\x1b[H!\r"}, denied: true},
+		{name: "tab completion", input: []string{"us\t\r"}, denied: true},
+		{name: "line discarded after a denial", input: []string{"\r"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var err error
+			for _, data := range tt.input {
+				if err = session.Write([]byte(data)); err != nil {
+					break
+				}
+			}
+			var violation *policy.Violation
+			if tt.denied != errors.As(err, &violation) {
+				t.Fatalf("Write = %v, want denied %v", err, tt.denied)
+			}
+		})
+	}
+}
@@ -0,0 +1,15 @@
+//go:build windows
+// +build windows
+
+package executor
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+)
+
+// startPTY is not available, Windows has no pseudo-terminals
+func startPTY(cmd *exec.Cmd, cols, rows uint16) (*os.File, error) {
+	return nil, errors.New("interactive sessions are not supported on Windows")
+}
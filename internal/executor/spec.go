@@ -0,0 +1,71 @@
+package executor
+
+import (
+	"path/filepath"
+	"runtime"
+	"time"
+
+	"github.com/LeHTVy/h_ai/internal/utils"
+)
+
+// CommandSpec describes a process to spawn directly, without a shell in between
+type CommandSpec struct {
+	Binary  string   `json:"binary"`
+	Args    []string `json:"args"`
+	Env     []string `json:"env,omitempty"` // KEY=VALUE pairs added to the server environment
+	WorkDir string   `json:"work_dir,omitempty"`
+	Stdin   string   `json:"stdin,omitempty"`
+
+	// Timeout bounds the run time of the process, 0 uses the executor default
+	Timeout time.Duration `json:"-"`
+
+	// Raw is the original command line when the spec wraps the shell
+	Raw string `json:"raw,omitempty"`
+
+	// Tool and Target describe the execution in the history, they do not affect the process
+	Tool   string `json:"tool,omitempty"`
+	Target string `json:"target,omitempty"`
+
+	// CacheKey is what the result is cached under, empty uses the command line.
+	// CacheTTL is how long it stays cached, 0 uses the cache default.
+	CacheKey string        `json:"-"`
+	CacheTTL time.Duration `json:"-"`
+
+	// interactive marks specs opened as sessions, which the caller's role must allow
+	interactive bool
+}
+
+// cacheKey returns the key the result of the spec is cached under
+func (s CommandSpec) cacheKey() string {
+	if s.CacheKey != "" {
+		return s.CacheKey
+	}
+	return s.String()
+}
+
+// ShellCommand wraps a raw command line so it is interpreted by the platform shell.
+// Only the raw /api/command endpoint should need this.
+func ShellCommand(command string) CommandSpec {
+	if runtime.GOOS == "windows" {
+		return CommandSpec{Binary: "cmd", Args: []string{"/c", command}, Raw: command}
+	}
+	return CommandSpec{Binary: "sh", Args: []string{"-c", command}, Raw: command}
+}
+
+// ToolName returns the tool recorded for the spec, defaulting to the binary name
+func (s CommandSpec) ToolName() string {
+	if s.Tool != "" {
+		return s.Tool
+	}
+	return filepath.Base(s.Binary)
+}
+
+// Argv returns the binary followed by its arguments
+func (s CommandSpec) Argv() []string {
+	return append([]string{s.Binary}, s.Args...)
+}
+
+// String renders the spec as a shell-quoted command line for logs and the process list
+func (s CommandSpec) String() string {
+	return utils.JoinArgs(s.Argv())
+}
@@ -0,0 +1,267 @@
+package executor
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	// maxStreamHistory bounds how much output is kept for replay to late subscribers
+	maxStreamHistory = 4 * 1024 * 1024
+	// streamRetention is how long a finished stream stays available for replay
+	streamRetention = 5 * time.Minute
+	// subscriberBuffer is the number of chunks a subscriber may lag behind before it is dropped
+	subscriberBuffer = 256
+)
+
+// OutputChunk is a piece of process output, tagged with the stream it was read from
+type OutputChunk struct {
+	Stream    string    `json:"stream"`
+	Data      string    `json:"data"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// OutputStream fans out process output to live subscribers and keeps the
+// prefix of the output around so late subscribers can catch up.
+type OutputStream struct {
+	PID       int
+	JobID     string
+	StartTime time.Time
+
+	mu          sync.Mutex
+	history     []OutputChunk
+	historySize int
+	subscribers map[chan OutputChunk]struct{}
+	closed      bool
+}
+
+func newOutputStream(jobID string) *OutputStream {
+	return &OutputStream{
+		JobID:       jobID,
+		subscribers: make(map[chan OutputChunk]struct{}),
+	}
+}
+
+func (s *OutputStream) publish(stream string, data []byte) {
+	chunk := OutputChunk{
+		Stream:    stream,
+		Data:      string(data),
+		Timestamp: time.Now(),
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.historySize+len(data) <= maxStreamHistory {
+		s.history = append(s.history, chunk)
+		s.historySize += len(data)
+	}
+
+	for ch := range s.subscribers {
+		select {
+		case ch <- chunk:
+		default:
+			// Subscriber is too slow, drop it rather than block the process
+			delete(s.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// Subscribe returns the buffered output so far and a channel of subsequent chunks.
+// The channel is closed when the process exits or when cancel is called.
+func (s *OutputStream) Subscribe() (replay []OutputChunk, chunks <-chan OutputChunk, cancel func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	replay = make([]OutputChunk, len(s.history))
+	copy(replay, s.history)
+
+	ch := make(chan OutputChunk, subscriberBuffer)
+	if s.closed {
+		close(ch)
+		return replay, ch, func() {}
+	}
+
+	s.subscribers[ch] = struct{}{}
+	return replay, ch, func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if _, ok := s.subscribers[ch]; ok {
+			delete(s.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// Closed reports whether the process behind the stream has exited
+func (s *OutputStream) Closed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closed
+}
+
+func (s *OutputStream) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return
+	}
+	s.closed = true
+	for ch := range s.subscribers {
+		close(ch)
+	}
+	s.subscribers = nil
+}
+
+// streamWriter is an io.Writer that captures one output stream of a process.
+// Every write is published to the process' OutputStream and appended to the
+// artifact file, while only a bounded head and tail are kept in memory.
+type streamWriter struct {
+	name     string
+	stream   *OutputStream
+	file     *os.File
+	recorder *castRecorder
+	fixture  *fixtureRecorder
+	limit    int
+
+	mu      sync.Mutex
+	head    []byte
+	tail    []byte
+	total   int64
+	fileErr error
+}
+
+func (w *streamWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	w.total += int64(len(p))
+	if w.file != nil && w.fileErr == nil {
+		_, w.fileErr = w.file.Write(p)
+	}
+	w.capture(p)
+	w.mu.Unlock()
+
+	w.recorder.output(w.name, p)
+	w.fixture.output(w.name, p)
+	w.stream.publish(w.name, p)
+	return len(p), nil
+}
+
+// capture keeps the first and last limit/2 bytes of the output. Callers must hold w.mu.
+func (w *streamWriter) capture(p []byte) {
+	if w.limit <= 0 {
+		w.head = append(w.head, p...)
+		return
+	}
+
+	headCap := w.limit / 2
+	if room := headCap - len(w.head); room > 0 {
+		n := len(p)
+		if n > room {
+			n = room
+		}
+		w.head = append(w.head, p[:n]...)
+		p = p[n:]
+	}
+	if len(p) == 0 {
+		return
+	}
+
+	tailCap := w.limit - headCap
+	w.tail = append(w.tail, p...)
+	// Compact once the slice holds twice what we keep, so trimming stays amortized
+	if len(w.tail) > 2*tailCap {
+		w.tail = append([]byte(nil), w.tail[len(w.tail)-tailCap:]...)
+	}
+}
+
+// Preview returns the captured output, with a marker where bytes were dropped
+func (w *streamWriter) Preview() (preview string, truncated bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	tail := w.tail
+	if w.limit > 0 {
+		if tailCap := w.limit - w.limit/2; len(tail) > tailCap {
+			tail = tail[len(tail)-tailCap:]
+		}
+	}
+
+	kept := int64(len(w.head) + len(tail))
+	if kept >= w.total {
+		return string(w.head) + string(tail), false
+	}
+	return fmt.Sprintf("%s\n... [%d bytes truncated] ...\n%s", w.head, w.total-kept, tail), true
+}
+
+// Total returns the number of bytes written to the stream
+func (w *streamWriter) Total() int64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.total
+}
+
+// Close closes the artifact file, reporting any write error that happened on the way
+func (w *streamWriter) Close() error {
+	if w.file == nil {
+		return nil
+	}
+	err := w.file.Close()
+	if w.fileErr != nil {
+		return w.fileErr
+	}
+	return err
+}
+
+func (e *Executor) registerStream(pid int, stream *OutputStream) {
+	e.streamLock.Lock()
+	defer e.streamLock.Unlock()
+
+	stream.PID = pid
+	stream.StartTime = time.Now()
+	e.streams[pid] = stream
+}
+
+// releaseStream closes the stream and forgets it once the retention window has passed
+func (e *Executor) releaseStream(pid int, stream *OutputStream) {
+	stream.close()
+
+	time.AfterFunc(streamRetention, func() {
+		e.streamLock.Lock()
+		defer e.streamLock.Unlock()
+		if e.streams[pid] == stream {
+			delete(e.streams, pid)
+		}
+	})
+}
+
+// ProcessStream returns the output stream of a running or recently finished process
+func (e *Executor) ProcessStream(pid int) (*OutputStream, bool) {
+	e.streamLock.RLock()
+	defer e.streamLock.RUnlock()
+
+	stream, exists := e.streams[pid]
+	return stream, exists
+}
+
+// JobStream returns the output stream of the most recent process spawned for jobID
+func (e *Executor) JobStream(jobID string) (*OutputStream, bool) {
+	e.streamLock.RLock()
+	defer e.streamLock.RUnlock()
+
+	var latest *OutputStream
+	for _, stream := range e.streams {
+		if stream.JobID != jobID {
+			continue
+		}
+		// Prefer a live stream, then the most recently started one
+		if latest == nil || (latest.Closed() && !stream.Closed()) ||
+			(latest.Closed() == stream.Closed() && stream.StartTime.After(latest.StartTime)) {
+			latest = stream
+		}
+	}
+	return latest, latest != nil
+}
@@ -0,0 +1,229 @@
+package history
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	executionsBucket = []byte("executions")
+	idsBucket        = []byte("ids")
+)
+
+// Status values recorded for finished executions
+const (
+	StatusCompleted = "completed"
+	StatusFailed    = "failed"
+	StatusTimedOut  = "timed_out"
+	StatusCancelled = "cancelled"
+	StatusError     = "error"  // the process could not be started
+	StatusDenied    = "denied" // rejected by the execution policy
+)
+
+// Record describes one finished execution
+type Record struct {
+	ID            string    `json:"id"`
+	Tool          string    `json:"tool"`
+	Target        string    `json:"target,omitempty"`
+	Command       string    `json:"command"`
+	Requester     string    `json:"requester,omitempty"`
+	JobID         string    `json:"job_id,omitempty"`
+	Status        string    `json:"status"`
+	ReturnCode    int       `json:"return_code"`
+	PID           int       `json:"pid,omitempty"`
+	StartedAt     time.Time `json:"started_at"`
+	EndedAt       time.Time `json:"ended_at"`
+	Duration      float64   `json:"duration"`
+	StdoutBytes   int64     `json:"stdout_bytes"`
+	StderrBytes   int64     `json:"stderr_bytes"`
+	ArtifactID    string    `json:"artifact_id,omitempty"`
+	Recording     string    `json:"recording,omitempty"`
+	LimitBreaches []string  `json:"limit_breaches,omitempty"`
+	// FailureReason says why the execution did not succeed, see executor.FailureReason
+	FailureReason string `json:"failure_reason,omitempty"`
+	Signal        string `json:"signal,omitempty"`
+}
+
+// Filter selects records in Query. Zero values match everything.
+type Filter struct {
+	Tool string
+	// Target matches records whose target or command contains it
+	Target    string
+	Requester string
+	JobID     string
+	Status    string
+	Since     time.Time
+	Until     time.Time
+	Limit     int
+}
+
+const (
+	defaultLimit = 100
+	maxLimit     = 1000
+)
+
+// Store persists execution records in a bolt database.
+// Records are keyed by start time so time range queries are range scans.
+type Store struct {
+	db *bolt.DB
+}
+
+// Open opens or creates the history database at path
+func Open(path string) (*Store, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0750); err != nil {
+		return nil, fmt.Errorf("failed to create history directory: %w", err)
+	}
+
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open history database: %w", err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(executionsBucket); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucketIfNotExists(idsBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to initialise history database: %w", err)
+	}
+
+	return &Store{db: db}, nil
+}
+
+// Close closes the database
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// Add stores rec, replacing any record with the same ID
+func (s *Store) Add(rec Record) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	key := recordKey(rec.StartedAt, rec.ID)
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		ids := tx.Bucket(idsBucket)
+		executions := tx.Bucket(executionsBucket)
+		if old := ids.Get([]byte(rec.ID)); old != nil {
+			if err := executions.Delete(old); err != nil {
+				return err
+			}
+		}
+		if err := ids.Put([]byte(rec.ID), key); err != nil {
+			return err
+		}
+		return executions.Put(key, data)
+	})
+}
+
+// Get returns the record with the given execution ID
+func (s *Store) Get(id string) (Record, bool, error) {
+	var rec Record
+	var found bool
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		key := tx.Bucket(idsBucket).Get([]byte(id))
+		if key == nil {
+			return nil
+		}
+		data := tx.Bucket(executionsBucket).Get(key)
+		if data == nil {
+			return nil
+		}
+		found = true
+		return json.Unmarshal(data, &rec)
+	})
+	return rec, found, err
+}
+
+// Query returns the records matching f, newest first
+func (s *Store) Query(f Filter) ([]Record, error) {
+	limit := f.Limit
+	if limit <= 0 {
+		limit = defaultLimit
+	}
+	if limit > maxLimit {
+		limit = maxLimit
+	}
+
+	records := []Record{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(executionsBucket).Cursor()
+
+		var k, v []byte
+		if f.Until.IsZero() {
+			k, v = c.Last()
+		} else {
+			// Position on the last key at or before Until
+			k, v = c.Seek(timeKey(f.Until.Add(time.Nanosecond)))
+			if k == nil {
+				k, v = c.Last()
+			} else {
+				k, v = c.Prev()
+			}
+		}
+
+		for ; k != nil && len(records) < limit; k, v = c.Prev() {
+			if !f.Since.IsZero() && keyTime(k).Before(f.Since) {
+				break
+			}
+
+			var rec Record
+			if err := json.Unmarshal(v, &rec); err != nil {
+				return err
+			}
+			if f.matches(rec) {
+				records = append(records, rec)
+			}
+		}
+		return nil
+	})
+	return records, err
+}
+
+func (f Filter) matches(rec Record) bool {
+	if f.Tool != "" && rec.Tool != f.Tool {
+		return false
+	}
+	if f.Target != "" && !strings.Contains(rec.Target, f.Target) && !strings.Contains(rec.Command, f.Target) {
+		return false
+	}
+	if f.Requester != "" && rec.Requester != f.Requester {
+		return false
+	}
+	if f.JobID != "" && rec.JobID != f.JobID {
+		return false
+	}
+	if f.Status != "" && rec.Status != f.Status {
+		return false
+	}
+	return true
+}
+
+// recordKey orders records by start time, the ID keeps keys unique
+func recordKey(t time.Time, id string) []byte {
+	return append(timeKey(t), id...)
+}
+
+func timeKey(t time.Time) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, uint64(t.UnixNano()))
+	return key
+}
+
+func keyTime(key []byte) time.Time {
+	return time.Unix(0, int64(binary.BigEndian.Uint64(key[:8])))
+}
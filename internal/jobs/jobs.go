@@ -0,0 +1,339 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/LeHTVy/h_ai/internal/executor"
+	"github.com/LeHTVy/h_ai/internal/utils"
+)
+
+// Status represents the lifecycle state of a job
+type Status string
+
+const (
+	StatusPending   Status = "pending"
+	StatusRunning   Status = "running"
+	StatusCompleted Status = "completed"
+	StatusFailed    Status = "failed"
+	StatusCancelled Status = "cancelled"
+	// StatusInterrupted marks jobs stopped by a server shutdown
+	StatusInterrupted Status = "interrupted"
+)
+
+// Func is the unit of work run by a job. It must honour ctx cancellation.
+type Func func(ctx context.Context) map[string]interface{}
+
+// Job represents an asynchronous tool execution
+type Job struct {
+	ID        string                 `json:"id"`
+	Tool      string                 `json:"tool"`
+	Status    Status                 `json:"status"`
+	CreatedAt time.Time              `json:"created_at"`
+	StartedAt *time.Time             `json:"started_at,omitempty"`
+	EndedAt   *time.Time             `json:"ended_at,omitempty"`
+	PIDs      []int                  `json:"pids,omitempty"`
+	Result    map[string]interface{} `json:"result,omitempty"`
+	Error     string                 `json:"error,omitempty"`
+
+	cancel context.CancelFunc
+}
+
+// Finished reports whether the job reached a terminal state
+func (j *Job) Finished() bool {
+	return j.Status == StatusCompleted || j.Status == StatusFailed || j.Status == StatusCancelled ||
+		j.Status == StatusInterrupted
+}
+
+// Manager runs jobs in the background and keeps track of their state
+type Manager struct {
+	logger    *zap.Logger
+	executor  *executor.Executor
+	jobs      map[string]*Job
+	mu        sync.RWMutex
+	retention time.Duration
+	running   sync.WaitGroup
+	closed    bool
+}
+
+func New(logger *zap.Logger, exec *executor.Executor) *Manager {
+	m := &Manager{
+		logger:    logger,
+		executor:  exec,
+		jobs:      make(map[string]*Job),
+		retention: 24 * time.Hour,
+	}
+
+	go m.startCleanup(10 * time.Minute)
+	return m
+}
+
+// Submit starts fn in the background and returns a snapshot of the new job.
+// It fails with executor.ErrShuttingDown once the manager is closed.
+func (m *Manager) Submit(tool string, fn Func) (Job, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	job := &Job{
+		ID:        utils.NewID(),
+		Tool:      tool,
+		Status:    StatusPending,
+		CreatedAt: time.Now(),
+		cancel:    cancel,
+	}
+
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		cancel()
+		return Job{}, executor.ErrShuttingDown
+	}
+	m.jobs[job.ID] = job
+	m.running.Add(1)
+	m.mu.Unlock()
+
+	m.logger.Info("Job submitted", zap.String("job_id", job.ID), zap.String("tool", tool))
+
+	// Jobs yield to interactive requests when the executor is saturated
+	ctx = executor.WithPriority(executor.WithJobID(ctx, job.ID), executor.PriorityBackground)
+	go m.run(ctx, job, fn)
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.snapshot(job), nil
+}
+
+func (m *Manager) run(ctx context.Context, job *Job, fn Func) {
+	defer m.running.Done()
+	defer job.cancel()
+
+	m.mu.Lock()
+	if job.Finished() {
+		m.mu.Unlock()
+		return
+	}
+	started := time.Now()
+	job.Status = StatusRunning
+	job.StartedAt = &started
+	m.mu.Unlock()
+
+	result := fn(ctx)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	ended := time.Now()
+	job.EndedAt = &ended
+	job.Result = result
+
+	switch {
+	case job.Status == StatusCancelled || job.Status == StatusInterrupted:
+		// Stopped while running, keep the partial result
+	case ctx.Err() != nil:
+		job.Status = StatusCancelled
+	case result["success"] == true:
+		job.Status = StatusCompleted
+	default:
+		job.Status = StatusFailed
+		if errMsg, ok := result["error"].(string); ok {
+			job.Error = errMsg
+		}
+	}
+
+	m.logger.Info("Job finished",
+		zap.String("job_id", job.ID),
+		zap.String("tool", job.Tool),
+		zap.String("status", string(job.Status)),
+		zap.Duration("duration", ended.Sub(started)))
+}
+
+// Get returns a snapshot of the job with the given ID
+func (m *Manager) Get(id string) (Job, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	job, exists := m.jobs[id]
+	if !exists {
+		return Job{}, false
+	}
+	return m.snapshot(job), true
+}
+
+// List returns snapshots of all known jobs, newest first
+func (m *Manager) List() []Job {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	jobs := make([]Job, 0, len(m.jobs))
+	for _, job := range m.jobs {
+		jobs = append(jobs, m.snapshot(job))
+	}
+
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].CreatedAt.After(jobs[j].CreatedAt)
+	})
+	return jobs
+}
+
+// Cancel stops a pending or running job and terminates its process groups
+func (m *Manager) Cancel(id string) error {
+	m.mu.Lock()
+	job, exists := m.jobs[id]
+	if !exists {
+		m.mu.Unlock()
+		return fmt.Errorf("job %s not found", id)
+	}
+	if job.Finished() {
+		m.mu.Unlock()
+		return fmt.Errorf("job %s already %s", id, job.Status)
+	}
+
+	ended := time.Now()
+	job.Status = StatusCancelled
+	job.EndedAt = &ended
+	job.cancel()
+	m.mu.Unlock()
+
+	// Cancelling the context only kills the direct child, so also take down
+	// the whole process group through the executor's registry.
+	for _, pid := range m.executor.JobProcesses(id) {
+		go func(pid int) {
+			if err := m.executor.TerminateProcess(pid); err != nil {
+				m.logger.Debug("Failed to terminate job process", zap.Int("pid", pid), zap.Error(err))
+			}
+		}(pid)
+	}
+
+	m.logger.Info("Job cancelled", zap.String("job_id", id))
+	return nil
+}
+
+// Close stops accepting new jobs
+func (m *Manager) Close() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.closed = true
+}
+
+// Interrupt stops every unfinished job for a shutdown and returns their IDs.
+// The executor is expected to take down the processes.
+func (m *Manager) Interrupt() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	interrupted := []string{}
+	ended := time.Now()
+	for id, job := range m.jobs {
+		if job.Finished() {
+			continue
+		}
+		job.Status = StatusInterrupted
+		job.EndedAt = &ended
+		job.Error = "interrupted by server shutdown"
+		job.cancel()
+		interrupted = append(interrupted, id)
+	}
+	sort.Strings(interrupted)
+	return interrupted
+}
+
+// Wait waits until every job goroutine returned or ctx is done
+func (m *Manager) Wait(ctx context.Context) bool {
+	done := make(chan struct{})
+	go func() {
+		m.running.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// Checkpoint writes all jobs to path so they can be restored after a restart
+func (m *Manager) Checkpoint(path string) error {
+	jobs := m.List()
+	data, err := json.MarshalIndent(jobs, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0640); err != nil {
+		return fmt.Errorf("failed to write job checkpoint: %w", err)
+	}
+	return os.Rename(tmp, path)
+}
+
+// Restore loads the jobs of a checkpoint. Jobs that were still pending or
+// running when it was written are marked interrupted.
+func (m *Manager) Restore(path string) (int, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("failed to read job checkpoint: %w", err)
+	}
+
+	var jobs []Job
+	if err := json.Unmarshal(data, &jobs); err != nil {
+		return 0, fmt.Errorf("failed to parse job checkpoint: %w", err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i := range jobs {
+		job := jobs[i]
+		if !job.Finished() {
+			job.Status = StatusInterrupted
+			job.Error = "interrupted by server shutdown"
+			if job.EndedAt == nil {
+				ended := time.Now()
+				job.EndedAt = &ended
+			}
+		}
+		job.PIDs = nil
+		if _, exists := m.jobs[job.ID]; !exists {
+			m.jobs[job.ID] = &job
+		}
+	}
+	return len(jobs), nil
+}
+
+// snapshot copies job so it can be handed out without holding the lock.
+// Callers must hold m.mu.
+func (m *Manager) snapshot(job *Job) Job {
+	snap := *job
+	snap.cancel = nil
+	if !job.Finished() {
+		snap.PIDs = m.executor.JobProcesses(job.ID)
+	}
+	return snap
+}
+
+func (m *Manager) startCleanup(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		m.mu.Lock()
+		cutoff := time.Now().Add(-m.retention)
+		for id, job := range m.jobs {
+			if job.Finished() && job.EndedAt != nil && job.EndedAt.Before(cutoff) {
+				delete(m.jobs, id)
+			}
+		}
+		m.mu.Unlock()
+	}
+}
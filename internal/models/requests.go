@@ -1,9 +1,22 @@
 package models
 
+import "encoding/json"
+
+// ExecutionOptions holds per-request execution settings shared by every tool request
+type ExecutionOptions struct {
+	// Timeout in seconds, 0 uses the server default
+	Timeout int `json:"timeout,omitempty"`
+	// DryRun returns the resolved command instead of running it
+	DryRun bool `json:"dry_run,omitempty"`
+	// UseRecovery retries failed executions following the tool's recovery strategy
+	UseRecovery bool `json:"use_recovery,omitempty"`
+}
+
 // CommandRequest represents a generic command execution request
 type CommandRequest struct {
 	Command  string `json:"command"`
 	UseCache bool   `json:"use_cache,omitempty"`
+	ExecutionOptions
 }
 
 // NmapRequest represents an Nmap scan request
@@ -12,7 +25,7 @@ type NmapRequest struct {
 	ScanType      string `json:"scan_type,omitempty"`
 	Ports         string `json:"ports,omitempty"`
 	AdditionalArgs string `json:"additional_args,omitempty"`
-	UseRecovery   bool   `json:"use_recovery,omitempty"`
+	ExecutionOptions
 }
 
 // NmapAdvancedRequest represents an advanced Nmap scan request
@@ -27,12 +40,14 @@ type NmapAdvancedRequest struct {
 	Aggressive     bool   `json:"aggressive,omitempty"`
 	Stealth        bool   `json:"stealth,omitempty"`
 	AdditionalArgs string `json:"additional_args,omitempty"`
+	ExecutionOptions
 }
 
 // MetasploitRequest represents a Metasploit module execution request
 type MetasploitRequest struct {
 	Module  string            `json:"module"`
 	Options map[string]string `json:"options,omitempty"`
+	ExecutionOptions
 }
 
 // GobusterRequest represents a Gobuster scan request
@@ -41,6 +56,7 @@ type GobusterRequest struct {
 	Mode          string `json:"mode,omitempty"`
 	Wordlist      string `json:"wordlist,omitempty"`
 	AdditionalArgs string `json:"additional_args,omitempty"`
+	ExecutionOptions
 }
 
 // NucleiRequest represents a Nuclei scan request
@@ -49,6 +65,7 @@ type NucleiRequest struct {
 	Templates     string `json:"templates,omitempty"`
 	Severity      string `json:"severity,omitempty"`
 	AdditionalArgs string `json:"additional_args,omitempty"`
+	ExecutionOptions
 }
 
 // SqlmapRequest represents a SQLMap scan request
@@ -57,6 +74,7 @@ type SqlmapRequest struct {
 	Data          string `json:"data,omitempty"`
 	Cookies       string `json:"cookies,omitempty"`
 	AdditionalArgs string `json:"additional_args,omitempty"`
+	ExecutionOptions
 }
 
 // HydraRequest represents a Hydra brute force request
@@ -66,6 +84,7 @@ type HydraRequest struct {
 	Username      string `json:"username,omitempty"`
 	PasswordList  string `json:"password_list,omitempty"`
 	AdditionalArgs string `json:"additional_args,omitempty"`
+	ExecutionOptions
 }
 
 // FFufRequest represents an FFuf fuzzing request
@@ -74,6 +93,7 @@ type FFufRequest struct {
 	Wordlist      string `json:"wordlist,omitempty"`
 	Headers       map[string]string `json:"headers,omitempty"`
 	AdditionalArgs string `json:"additional_args,omitempty"`
+	ExecutionOptions
 }
 
 // NetexecRequest represents a NetExec request
@@ -85,12 +105,14 @@ type NetexecRequest struct {
 	Hash          string `json:"hash,omitempty"`
 	Module        string `json:"module,omitempty"`
 	AdditionalArgs string `json:"additional_args,omitempty"`
+	ExecutionOptions
 }
 
 // AmassRequest represents an Amass enumeration request
 type AmassRequest struct {
 	Domain        string `json:"domain"`
 	AdditionalArgs string `json:"additional_args,omitempty"`
+	ExecutionOptions
 }
 
 // MasscanRequest represents a Masscan scan request
@@ -99,12 +121,14 @@ type MasscanRequest struct {
 	Ports         string `json:"ports,omitempty"`
 	Rate          string `json:"rate,omitempty"`
 	AdditionalArgs string `json:"additional_args,omitempty"`
+	ExecutionOptions
 }
 
 // AutoReconRequest represents an AutoRecon request
 type AutoReconRequest struct {
 	Target        string `json:"target"`
 	AdditionalArgs string `json:"additional_args,omitempty"`
+	ExecutionOptions
 }
 
 // MSFVenomRequest represents an MSFVenom payload generation request
@@ -115,12 +139,15 @@ type MSFVenomRequest struct {
 	Encoder       string `json:"encoder,omitempty"`
 	Iterations    string `json:"iterations,omitempty"`
 	AdditionalArgs string `json:"additional_args,omitempty"`
+	ExecutionOptions
 }
 
 // Intelligence requests
 type AnalyzeTargetRequest struct {
 	Target       string `json:"target"`
 	AnalysisType string `json:"analysis_type,omitempty"`
+	// DryRun adds the commands the planned steps would run
+	DryRun bool `json:"dry_run,omitempty"`
 }
 
 type SelectToolsRequest struct {
@@ -132,4 +159,46 @@ type OptimizeParametersRequest struct {
 	Tool      string                 `json:"tool"`
 	Parameters map[string]interface{} `json:"parameters"`
 	Context   map[string]interface{} `json:"context,omitempty"`
+	// DryRun adds the command the optimized parameters would run
+	DryRun bool `json:"dry_run,omitempty"`
+}
+
+// SessionRequest opens an interactive terminal session. Command is split into
+// arguments like additional_args and run without a shell.
+type SessionRequest struct {
+	Command string `json:"command"`
+	Cols    uint16 `json:"cols,omitempty"`
+	Rows    uint16 `json:"rows,omitempty"`
+}
+
+// SessionInputRequest sends input to a session
+type SessionInputRequest struct {
+	Data string `json:"data"`
+}
+
+// SessionResizeRequest changes the terminal size of a session
+type SessionResizeRequest struct {
+	Cols uint16 `json:"cols"`
+	Rows uint16 `json:"rows"`
+}
+
+// ScheduleRequest creates or updates a scheduled tool request. Tool names the
+// request type like the /api/tools routes, or "command" for a CommandRequest.
+type ScheduleRequest struct {
+	Name     string          `json:"name,omitempty"`
+	Tool     string          `json:"tool"`
+	Cron     string          `json:"cron"`
+	Timezone string          `json:"timezone,omitempty"`
+	Enabled  *bool           `json:"enabled,omitempty"`
+	Request  json.RawMessage `json:"request"`
+}
+
+// DispatchRequest sends a tool request to worker nodes. Without Worker or Segment any
+// worker having the tool runs it, All runs it on every matching worker.
+type DispatchRequest struct {
+	Tool    string          `json:"tool"`
+	Request json.RawMessage `json:"request"`
+	Worker  string          `json:"worker,omitempty"`
+	Segment string          `json:"segment,omitempty"`
+	All     bool            `json:"all,omitempty"`
 }
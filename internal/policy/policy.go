@@ -0,0 +1,404 @@
+package policy
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Config is the policy file format
+type Config struct {
+	// AllowedBinaries lists the programs that may be run, empty allows any. Bare names
+	// are looked up in PATH, a program given by path must be listed by that exact path.
+	AllowedBinaries []string `json:"allowed_binaries,omitempty"`
+	// DeniedPatterns are regular expressions rejected anywhere in a command line or argument
+	DeniedPatterns []string `json:"denied_patterns,omitempty"`
+	// Roles restricts what each role may run, empty disables role checks
+	Roles map[string]Role `json:"roles,omitempty"`
+	// DefaultRole applies to requests that do not name a role
+	DefaultRole string `json:"default_role,omitempty"`
+	// Tokens maps API tokens, sent as "Authorization: Bearer <token>", to the role
+	// of their holder
+	Tokens map[string]string `json:"tokens,omitempty"`
+	// TrustedProxies are the addresses or CIDR ranges of authenticating proxies whose
+	// X-Role header is honoured, requests from anywhere else get the default role
+	TrustedProxies []string `json:"trusted_proxies,omitempty"`
+}
+
+// Role holds the permissions of one role
+type Role struct {
+	// Tools lists the binaries the role may run, like AllowedBinaries. "*" allows every
+	// allowed binary.
+	Tools []string `json:"tools"`
+	// RawCommands allows the role to use the raw /api/command endpoint
+	RawCommands bool `json:"raw_commands"`
+	// Sessions allows the role to open interactive sessions, whose input is only
+	// screened line by line against DeniedPatterns
+	Sessions bool `json:"sessions"`
+}
+
+// Request describes an execution to check
+type Request struct {
+	Role   string
+	Binary string
+	Args   []string
+	// Raw is the command line handed to the shell for raw commands, empty otherwise
+	Raw string
+	// Stdin is the input fed to the program, such as a Metasploit resource script
+	Stdin string
+	// Interactive is set when the program is opened as an interactive session
+	Interactive bool
+}
+
+// Violation is returned when a request is rejected by the policy
+type Violation struct {
+	Rule   string `json:"rule"`
+	Reason string `json:"reason"`
+}
+
+func (v *Violation) Error() string {
+	return "policy violation: " + v.Reason
+}
+
+// Engine evaluates requests against a policy
+type Engine struct {
+	cfg      Config
+	allowed  map[string]bool
+	patterns []*regexp.Regexp
+	proxies  []*net.IPNet
+}
+
+// New compiles cfg into an engine
+func New(cfg Config) (*Engine, error) {
+	e := &Engine{cfg: cfg}
+
+	if len(cfg.AllowedBinaries) > 0 {
+		e.allowed = make(map[string]bool)
+		for _, binary := range cfg.AllowedBinaries {
+			e.allowed[binary] = true
+		}
+	}
+
+	for _, pattern := range cfg.DeniedPatterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid denied pattern %q: %w", pattern, err)
+		}
+		e.patterns = append(e.patterns, re)
+	}
+
+	if cfg.DefaultRole != "" && len(cfg.Roles) > 0 {
+		if _, ok := cfg.Roles[cfg.DefaultRole]; !ok {
+			return nil, fmt.Errorf("default role %q is not defined", cfg.DefaultRole)
+		}
+	}
+
+	for token, role := range cfg.Tokens {
+		if token == "" {
+			return nil, fmt.Errorf("empty API token for role %q", role)
+		}
+		if _, ok := cfg.Roles[role]; !ok && len(cfg.Roles) > 0 {
+			return nil, fmt.Errorf("API token role %q is not defined", role)
+		}
+	}
+
+	for _, proxy := range cfg.TrustedProxies {
+		if !strings.Contains(proxy, "/") {
+			if strings.Contains(proxy, ":") {
+				proxy += "/128"
+			} else {
+				proxy += "/32"
+			}
+		}
+		_, network, err := net.ParseCIDR(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %w", proxy, err)
+		}
+		e.proxies = append(e.proxies, network)
+	}
+
+	return e, nil
+}
+
+// Load reads a policy file and compiles it
+func Load(path string) (*Engine, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read policy: %w", err)
+	}
+
+	var cfg Config
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse policy: %w", err)
+	}
+	return New(cfg)
+}
+
+// Check returns a *Violation if the request is not allowed
+func (e *Engine) Check(req Request) error {
+	role, err := e.role(req.Role)
+	if err != nil {
+		return err
+	}
+	if req.Interactive && role != nil && !role.Sessions {
+		return &Violation{Rule: "sessions", Reason: fmt.Sprintf("role %q may not open interactive sessions", e.roleName(req.Role))}
+	}
+
+	// Programs like msfconsole run the commands they read from their input
+	if req.Stdin != "" {
+		if err := e.checkPatterns(req.Stdin); err != nil {
+			return err
+		}
+		for _, line := range strings.Split(req.Stdin, "\n") {
+			if err := e.checkPatterns(line); err != nil {
+				return err
+			}
+		}
+	}
+
+	binaries := []string{req.Binary}
+	if req.Raw != "" {
+		if role != nil && !role.RawCommands {
+			return &Violation{Rule: "raw_commands", Reason: fmt.Sprintf("role %q may not run raw commands", e.roleName(req.Role))}
+		}
+		if err := e.checkPatterns(req.Raw); err != nil {
+			return err
+		}
+
+		// The allowlist only means something if every program of the command line is known
+		if e.allowed != nil || role != nil {
+			if binaries, err = commandBinaries(req.Raw); err != nil {
+				return &Violation{Rule: "raw_commands", Reason: err.Error()}
+			}
+		} else {
+			binaries = nil
+		}
+	} else {
+		if err := e.checkPatterns(strings.Join(append([]string{req.Binary}, req.Args...), " ")); err != nil {
+			return err
+		}
+		for _, arg := range req.Args {
+			if err := e.checkPatterns(arg); err != nil {
+				return err
+			}
+		}
+	}
+
+	if e.allowed == nil && role == nil {
+		return nil
+	}
+	for _, binary := range binaries {
+		name, err := binaryName(binary)
+		if err != nil {
+			return &Violation{Rule: "allowed_binaries", Reason: err.Error()}
+		}
+		if e.allowed != nil && !e.allowed[name] {
+			return &Violation{Rule: "allowed_binaries", Reason: fmt.Sprintf("%q is not an allowed binary", name)}
+		}
+		if role != nil && !role.allows(name) {
+			return &Violation{Rule: "role", Reason: fmt.Sprintf("role %q may not run %q", e.roleName(req.Role), name)}
+		}
+	}
+	return nil
+}
+
+// CheckInput returns a *Violation if a line typed into an interactive session matches a
+// denied pattern
+func (e *Engine) CheckInput(line string) error {
+	return e.checkPatterns(line)
+}
+
+// ScreensInput reports whether there are denied patterns to check session input against
+func (e *Engine) ScreensInput() bool {
+	return len(e.patterns) > 0
+}
+
+// TokenRole returns the role of the holder of an API token
+func (e *Engine) TokenRole(token string) (string, bool) {
+	for known, role := range e.cfg.Tokens {
+		if subtle.ConstantTimeCompare([]byte(known), []byte(token)) == 1 {
+			return role, true
+		}
+	}
+	return "", false
+}
+
+// TrustsProxy reports whether requests from ip may name their role in a header
+func (e *Engine) TrustsProxy(ip net.IP) bool {
+	for _, network := range e.proxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// role resolves the permissions for name, nil when roles are not configured
+func (e *Engine) role(name string) (*Role, error) {
+	if len(e.cfg.Roles) == 0 {
+		return nil, nil
+	}
+
+	name = e.roleName(name)
+	if name == "" {
+		return nil, &Violation{Rule: "role", Reason: "no role given and no default role configured"}
+	}
+	role, ok := e.cfg.Roles[name]
+	if !ok {
+		return nil, &Violation{Rule: "role", Reason: fmt.Sprintf("unknown role %q", name)}
+	}
+	return &role, nil
+}
+
+func (e *Engine) roleName(name string) string {
+	if name == "" {
+		return e.cfg.DefaultRole
+	}
+	return name
+}
+
+func (e *Engine) checkPatterns(s string) error {
+	for _, re := range e.patterns {
+		if re.MatchString(s) {
+			return &Violation{Rule: "denied_patterns", Reason: fmt.Sprintf("command matches denied pattern %q", re.String())}
+		}
+	}
+	return nil
+}
+
+// binaryName returns the name binary is checked under. Bare names are what PATH
+// resolves, anything else must be an absolute path that is listed as is, so a copy of
+// an allowed tool dropped in another directory does not pass for it.
+func binaryName(binary string) (string, error) {
+	if !strings.ContainsAny(binary, `/\`) {
+		if _, err := exec.LookPath(binary); errors.Is(err, exec.ErrDot) {
+			return "", fmt.Errorf("%q resolves to the current directory", binary)
+		}
+		return binary, nil
+	}
+	if !filepath.IsAbs(binary) {
+		return "", fmt.Errorf("%q is a relative path", binary)
+	}
+	return filepath.Clean(binary), nil
+}
+
+func (r *Role) allows(binary string) bool {
+	for _, tool := range r.Tools {
+		if tool == "*" || tool == binary {
+			return true
+		}
+	}
+	return false
+}
+
+// commandBinaries returns the program of every simple command in a shell command line.
+// Constructs whose programs cannot be determined statically are rejected.
+func commandBinaries(raw string) ([]string, error) {
+	var binaries []string
+	var word strings.Builder
+	var quote rune
+	inWord, first := false, true
+
+	endWord := func() {
+		if !inWord {
+			return
+		}
+		w := word.String()
+		word.Reset()
+		inWord = false
+		// Leading VAR=value assignments are not the program
+		if first && !isAssignment(w) {
+			binaries = append(binaries, w)
+			first = false
+		}
+	}
+
+	runes := []rune(raw)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else if r == '\\' && quote == '"' && i+1 < len(runes) {
+				i++
+				word.WriteRune(runes[i])
+			} else if quote == '"' && (r == '`' || (r == '$' && i+1 < len(runes) && runes[i+1] == '(')) {
+				return nil, fmt.Errorf("command substitution is not allowed")
+			} else {
+				word.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote = r
+			inWord = true
+		case r == '\\' && i+1 < len(runes):
+			i++
+			word.WriteRune(runes[i])
+			inWord = true
+		case r == '`' || (r == '$' && i+1 < len(runes) && runes[i+1] == '('):
+			return nil, fmt.Errorf("command substitution is not allowed")
+		case r == ';' || r == '&' || r == '|' || r == '\n' || r == '(' || r == ')':
+			endWord()
+			first = true
+		case r == '<' || r == '>':
+			// The redirection target is not a program
+			endWord()
+			if first {
+				return nil, fmt.Errorf("a command may not start with a redirection")
+			}
+			if i+1 < len(runes) && (runes[i+1] == '&' || runes[i+1] == '>' || runes[i+1] == '|') {
+				i++
+			}
+		case r == ' ' || r == '\t':
+			endWord()
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated quote")
+	}
+	endWord()
+
+	if len(binaries) == 0 {
+		return nil, fmt.Errorf("no command given")
+	}
+	return binaries, nil
+}
+
+func isAssignment(word string) bool {
+	name, _, found := strings.Cut(word, "=")
+	if !found || name == "" {
+		return false
+	}
+	for i, r := range name {
+		if r != '_' && !(r >= 'a' && r <= 'z') && !(r >= 'A' && r <= 'Z') && !(i > 0 && r >= '0' && r <= '9') {
+			return false
+		}
+	}
+	return true
+}
+
+type contextKey string
+
+const roleKey contextKey = "role"
+
+// WithRole attaches the caller's role to ctx
+func WithRole(ctx context.Context, role string) context.Context {
+	return context.WithValue(ctx, roleKey, role)
+}
+
+// RoleFromContext returns the role attached to ctx, if any
+func RoleFromContext(ctx context.Context) string {
+	role, _ := ctx.Value(roleKey).(string)
+	return role
+}
@@ -0,0 +1,119 @@
+package policy
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCheckBinaries(t *testing.T) {
+	engine, err := New(Config{
+		AllowedBinaries: []string{"nmap", "sh", "/opt/tools/amass"},
+		Roles: map[string]Role{
+			"admin":   {Tools: []string{"*"}, RawCommands: true},
+			"analyst": {Tools: []string{"nmap", "/opt/tools/amass"}},
+		},
+		DefaultRole: "analyst",
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		req      Request
+		wantRule string
+	}{
+		{name: "bare name", req: Request{Binary: "nmap", Args: []string{"-sV", "x"}}},
+		{name: "configured path", req: Request{Binary: "/opt/tools/amass"}},
+		{name: "configured path, uncleaned", req: Request{Binary: "/opt/tools/../tools/amass"}},
+		{name: "allowed name elsewhere", req: Request{Binary: "/tmp/evil/nmap"}, wantRule: "allowed_binaries"},
+		{name: "allowed name, relative", req: Request{Binary: "./nmap"}, wantRule: "allowed_binaries"},
+		{name: "configured path by name", req: Request{Binary: "amass"}, wantRule: "allowed_binaries"},
+		{name: "not allowed", req: Request{Binary: "nc"}, wantRule: "allowed_binaries"},
+		{name: "not for the role", req: Request{Binary: "sh"}, wantRule: "role"},
+		{name: "raw command", req: Request{Role: "admin", Binary: "sh", Raw: "nmap x | sh"}},
+		{name: "raw command elsewhere", req: Request{Role: "admin", Binary: "sh", Raw: "/tmp/evil/nmap x"}, wantRule: "allowed_binaries"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := engine.Check(tt.req)
+			if tt.wantRule == "" {
+				if err != nil {
+					t.Fatalf("Check = %v, want allowed", err)
+				}
+				return
+			}
+			var violation *Violation
+			if !errors.As(err, &violation) || violation.Rule != tt.wantRule {
+				t.Fatalf("Check = %v, want a %s violation", err, tt.wantRule)
+			}
+		})
+	}
+}
+
+func TestCheckRoleWithoutAllowlist(t *testing.T) {
+	engine, err := New(Config{Roles: map[string]Role{"analyst": {Tools: []string{"nmap"}}}, DefaultRole: "analyst"})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	if err := engine.Check(Request{Binary: "/tmp/evil/nmap"}); err == nil {
+		t.Errorf("Check allowed /tmp/evil/nmap for a role limited to nmap")
+	}
+	if err := engine.Check(Request{Binary: "nmap"}); err != nil {
+		t.Errorf("Check(nmap) = %v, want allowed", err)
+	}
+}
+
+func TestCheckStdin(t *testing.T) {
+	engine, err := New(Config{DeniedPatterns: []string{`^\s*(!|irb\b)`, `rm\s+-rf`}})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	tests := []struct {
+		stdin   string
+		allowed bool
+	}{
+		{stdin: "use auxiliary/x\nset RHOSTS 10.0.0.1\nexploit\n", allowed: true},
+		{stdin: "use auxiliary/x\n!sh\nexploit\n"},
+		{stdin: "use auxiliary/x\nirb\n"},
+		{stdin: "set RHOSTS x rm -rf /"},
+	}
+	for _, tt := range tests {
+		err := engine.Check(Request{Binary: "msfconsole", Args: []string{"-q", "-r", "-"}, Stdin: tt.stdin})
+		if (err == nil) != tt.allowed {
+			t.Errorf("Check(stdin %q) = %v, want allowed %v", tt.stdin, err, tt.allowed)
+		}
+	}
+}
+
+func TestCheckSessions(t *testing.T) {
+	engine, err := New(Config{
+		Roles: map[string]Role{
+			"admin":   {Tools: []string{"*"}, Sessions: true},
+			"analyst": {Tools: []string{"*"}},
+		},
+		DefaultRole: "analyst",
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	if err := engine.Check(Request{Role: "admin", Binary: "msfconsole", Interactive: true}); err != nil {
+		t.Errorf("Check(admin session) = %v, want allowed", err)
+	}
+	var violation *Violation
+	if err := engine.Check(Request{Binary: "msfconsole", Interactive: true}); !errors.As(err, &violation) || violation.Rule != "sessions" {
+		t.Errorf("Check(analyst session) = %v, want a sessions violation", err)
+	}
+	if err := engine.Check(Request{Binary: "msfconsole"}); err != nil {
+		t.Errorf("Check(analyst execution) = %v, want allowed", err)
+	}
+
+	// Without roles anyone may open sessions
+	open, _ := New(Config{})
+	if err := open.Check(Request{Binary: "msfconsole", Interactive: true}); err != nil {
+		t.Errorf("Check without roles = %v, want allowed", err)
+	}
+}
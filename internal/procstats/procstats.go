@@ -0,0 +1,160 @@
+// Package procstats reads resource usage of process trees and of the host.
+// Only Linux is supported, other platforms return ErrUnsupported.
+package procstats
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrUnsupported is returned on platforms without /proc
+var ErrUnsupported = errors.New("resource accounting is only supported on Linux")
+
+// Usage is the resource usage of a process and all of its descendants
+type Usage struct {
+	// Processes is the number of live processes in the tree
+	Processes  int     `json:"processes"`
+	Threads    int     `json:"threads"`
+	CPUSeconds float64 `json:"cpu_seconds"`
+	RSSBytes   int64   `json:"rss_bytes"`
+	// ReadBytes and WriteBytes count all read and write I/O, including sockets and pipes
+	ReadBytes  uint64 `json:"read_bytes"`
+	WriteBytes uint64 `json:"write_bytes"`
+	// DiskReadBytes and DiskWriteBytes only count storage I/O
+	DiskReadBytes  uint64 `json:"disk_read_bytes"`
+	DiskWriteBytes uint64 `json:"disk_write_bytes"`
+}
+
+// HostStats is the host-level resource usage
+type HostStats struct {
+	CPUCount int `json:"cpu_count"`
+	// CPUPercent is the busy share of all CPUs since the previous sample
+	CPUPercent      float64 `json:"cpu_percent"`
+	Load1           float64 `json:"load_1"`
+	Load5           float64 `json:"load_5"`
+	Load15          float64 `json:"load_15"`
+	MemoryTotal     uint64  `json:"memory_total"`
+	MemoryAvailable uint64  `json:"memory_available"`
+	MemoryUsed      uint64  `json:"memory_used"`
+	MemoryPercent   float64 `json:"memory_percent"`
+	// Uptime is the host uptime in seconds
+	Uptime float64 `json:"uptime"`
+}
+
+// cpuSampleInterval is how long Host samples the CPU counters when it has no recent sample
+const cpuSampleInterval = 200 * time.Millisecond
+
+// cpuSampleMaxAge is the age after which a previous sample is too old to compare against
+const cpuSampleMaxAge = time.Minute
+
+// cpuTimes are the cumulative busy and total jiffies of all CPUs
+type cpuTimes struct {
+	busy, total uint64
+	at          time.Time
+}
+
+// HostSampler computes host CPU usage as the difference between successive calls
+type HostSampler struct {
+	mu   sync.Mutex
+	last cpuTimes
+}
+
+// NewHostSampler creates a sampler
+func NewHostSampler() *HostSampler {
+	return &HostSampler{}
+}
+
+// Host returns the current host statistics
+func (h *HostSampler) Host() (HostStats, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	stats, err := readHost()
+	if err != nil {
+		return stats, err
+	}
+
+	current, err := readCPUTimes()
+	if err != nil {
+		return stats, err
+	}
+	previous := h.last
+	if previous.at.IsZero() || current.at.Sub(previous.at) > cpuSampleMaxAge {
+		previous = current
+		time.Sleep(cpuSampleInterval)
+		if current, err = readCPUTimes(); err != nil {
+			return stats, err
+		}
+	}
+	h.last = current
+
+	if total := current.total - previous.total; total > 0 {
+		stats.CPUPercent = float64(current.busy-previous.busy) / float64(total) * 100
+	}
+	return stats, nil
+}
+
+// Trees returns the usage of the process tree rooted at each of pids.
+// PIDs whose process is gone are left out.
+func Trees(pids []int) (map[int]Usage, error) {
+	if len(pids) == 0 {
+		return map[int]Usage{}, nil
+	}
+
+	procs, err := readProcesses()
+	if err != nil {
+		return nil, err
+	}
+
+	children := make(map[int][]int)
+	for pid, p := range procs {
+		children[p.ppid] = append(children[p.ppid], pid)
+	}
+
+	trees := make(map[int]Usage, len(pids))
+	for _, root := range pids {
+		if _, alive := procs[root]; !alive {
+			continue
+		}
+
+		var usage Usage
+		queue := []int{root}
+		seen := map[int]bool{root: true}
+		for len(queue) > 0 {
+			pid := queue[0]
+			queue = queue[1:]
+
+			p := procs[pid]
+			usage.Processes++
+			usage.Threads += p.threads
+			usage.CPUSeconds += p.cpuSeconds
+			usage.RSSBytes += p.rssBytes
+			usage.ReadBytes += p.readBytes
+			usage.WriteBytes += p.writeBytes
+			usage.DiskReadBytes += p.diskReadBytes
+			usage.DiskWriteBytes += p.diskWriteBytes
+
+			for _, child := range children[pid] {
+				if !seen[child] {
+					seen[child] = true
+					queue = append(queue, child)
+				}
+			}
+		}
+		trees[root] = usage
+	}
+	return trees, nil
+}
+
+// process is the usage of a single process
+type process struct {
+	ppid           int
+	threads        int
+	cpuSeconds     float64
+	rssBytes       int64
+	readBytes      uint64
+	writeBytes     uint64
+	diskReadBytes  uint64
+	diskWriteBytes uint64
+}
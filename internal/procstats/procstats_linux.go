@@ -0,0 +1,176 @@
+//go:build linux
+// +build linux
+
+package procstats
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// clockTicks is USER_HZ, which is 100 on every Linux architecture Go supports
+const clockTicks = 100
+
+var pageSize = int64(os.Getpagesize())
+
+// readProcesses reads the usage of every process visible in /proc
+func readProcesses() (map[int]process, error) {
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return nil, err
+	}
+
+	procs := make(map[int]process, len(entries))
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+		// Processes exit while we read, skip whatever is gone
+		p, err := readProcess(pid)
+		if err != nil {
+			continue
+		}
+		procs[pid] = p
+	}
+	return procs, nil
+}
+
+func readProcess(pid int) (process, error) {
+	var p process
+
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return p, err
+	}
+	// The command name may contain spaces and parentheses, fields start after the last ')'
+	end := bytes.LastIndexByte(data, ')')
+	if end < 0 {
+		return p, fmt.Errorf("malformed stat of process %d", pid)
+	}
+	fields := strings.Fields(string(data[end+1:]))
+	// fields[0] is field 3 (state) of proc(5)
+	if len(fields) < 22 {
+		return p, fmt.Errorf("malformed stat of process %d", pid)
+	}
+
+	p.ppid, _ = strconv.Atoi(fields[1])
+	utime, _ := strconv.ParseUint(fields[11], 10, 64)
+	stime, _ := strconv.ParseUint(fields[12], 10, 64)
+	// Children that already exited and were waited for are accounted to their parent
+	cutime, _ := strconv.ParseUint(fields[13], 10, 64)
+	cstime, _ := strconv.ParseUint(fields[14], 10, 64)
+	p.cpuSeconds = float64(utime+stime+cutime+cstime) / clockTicks
+	p.threads, _ = strconv.Atoi(fields[17])
+	rss, _ := strconv.ParseInt(fields[21], 10, 64)
+	p.rssBytes = rss * pageSize
+
+	// Not readable for processes of other users, which the executor never starts
+	if io, err := os.ReadFile(fmt.Sprintf("/proc/%d/io", pid)); err == nil {
+		for _, line := range strings.Split(string(io), "\n") {
+			key, value, found := strings.Cut(line, ":")
+			if !found {
+				continue
+			}
+			n, _ := strconv.ParseUint(strings.TrimSpace(value), 10, 64)
+			switch key {
+			case "rchar":
+				p.readBytes = n
+			case "wchar":
+				p.writeBytes = n
+			case "read_bytes":
+				p.diskReadBytes = n
+			case "write_bytes":
+				p.diskWriteBytes = n
+			}
+		}
+	}
+	return p, nil
+}
+
+// readHost reads memory, load and uptime, CPU usage is filled in by the sampler
+func readHost() (HostStats, error) {
+	stats := HostStats{CPUCount: runtime.NumCPU()}
+
+	meminfo, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return stats, err
+	}
+	defer meminfo.Close()
+
+	scanner := bufio.NewScanner(meminfo)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		kb, _ := strconv.ParseUint(fields[1], 10, 64)
+		switch fields[0] {
+		case "MemTotal:":
+			stats.MemoryTotal = kb * 1024
+		case "MemAvailable:":
+			stats.MemoryAvailable = kb * 1024
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return stats, err
+	}
+	if stats.MemoryTotal > 0 {
+		stats.MemoryUsed = stats.MemoryTotal - stats.MemoryAvailable
+		stats.MemoryPercent = float64(stats.MemoryUsed) / float64(stats.MemoryTotal) * 100
+	}
+
+	loadavg, err := os.ReadFile("/proc/loadavg")
+	if err != nil {
+		return stats, err
+	}
+	if fields := strings.Fields(string(loadavg)); len(fields) >= 3 {
+		stats.Load1, _ = strconv.ParseFloat(fields[0], 64)
+		stats.Load5, _ = strconv.ParseFloat(fields[1], 64)
+		stats.Load15, _ = strconv.ParseFloat(fields[2], 64)
+	}
+
+	uptime, err := os.ReadFile("/proc/uptime")
+	if err != nil {
+		return stats, err
+	}
+	if fields := strings.Fields(string(uptime)); len(fields) >= 1 {
+		stats.Uptime, _ = strconv.ParseFloat(fields[0], 64)
+	}
+
+	return stats, nil
+}
+
+// readCPUTimes reads the aggregate CPU line of /proc/stat
+func readCPUTimes() (cpuTimes, error) {
+	data, err := os.ReadFile("/proc/stat")
+	if err != nil {
+		return cpuTimes{}, err
+	}
+
+	line, _, _ := strings.Cut(string(data), "\n")
+	fields := strings.Fields(line)
+	if len(fields) < 5 || fields[0] != "cpu" {
+		return cpuTimes{}, fmt.Errorf("malformed /proc/stat")
+	}
+
+	times := cpuTimes{at: time.Now()}
+	// user nice system idle iowait irq softirq steal, guest time is already in user
+	for i, field := range fields[1:] {
+		if i >= 8 {
+			break
+		}
+		n, _ := strconv.ParseUint(field, 10, 64)
+		times.total += n
+		if i != 3 && i != 4 {
+			times.busy += n
+		}
+	}
+	return times, nil
+}
@@ -0,0 +1,18 @@
+//go:build !linux
+// +build !linux
+
+package procstats
+
+import "runtime"
+
+func readProcesses() (map[int]process, error) {
+	return nil, ErrUnsupported
+}
+
+func readHost() (HostStats, error) {
+	return HostStats{CPUCount: runtime.NumCPU()}, ErrUnsupported
+}
+
+func readCPUTimes() (cpuTimes, error) {
+	return cpuTimes{}, ErrUnsupported
+}
@@ -2,6 +2,7 @@ package recovery
 
 import (
 	"fmt"
+	"math"
 	"time"
 
 	"go.uber.org/zap"
@@ -95,7 +96,8 @@ func (rm *RecoveryManager) CalculateBackoff(attempt int, strategy RecoveryStrate
 		maxDelay = time.Duration(delay) * time.Second
 	}
 
-	backoff := float64(initialDelay) * (strategy.BackoffMultiplier * float64(attempt-1))
+	// attempt is the number of attempts that failed so far, the first retry waits initialDelay
+	backoff := float64(initialDelay) * math.Pow(strategy.BackoffMultiplier, float64(attempt-1))
 	if backoff > float64(maxDelay) {
 		backoff = float64(maxDelay)
 	}
@@ -0,0 +1,173 @@
+package schedules
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCron(t *testing.T) {
+	tests := []struct {
+		expr    string
+		wantErr bool
+	}{
+		{expr: "* * * * *"},
+		{expr: "*/15 * * * *"},
+		{expr: "30 2 * * 1-5"},
+		{expr: "0 9 1,15 * *"},
+		{expr: "5/20 * * * *"},
+		{expr: "0 0 * jan-mar sun"},
+		{expr: "0 0 * * 7"},
+		{expr: "@daily"},
+		{expr: " @Hourly "},
+		{expr: "* * * *", wantErr: true},
+		{expr: "* * * * * *", wantErr: true},
+		{expr: "60 * * * *", wantErr: true},
+		{expr: "* 24 * * *", wantErr: true},
+		{expr: "* * 0 * *", wantErr: true},
+		{expr: "* * * 13 *", wantErr: true},
+		{expr: "* * * * 8", wantErr: true},
+		{expr: "*/0 * * * *", wantErr: true},
+		{expr: "10-5 * * * *", wantErr: true},
+		{expr: "x * * * *", wantErr: true},
+		{expr: "@sometimes", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		_, err := ParseCron(tt.expr)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseCron(%q) error = %v, want error %v", tt.expr, err, tt.wantErr)
+		}
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	utc := func(year int, month time.Month, day, hour, min int) time.Time {
+		return time.Date(year, month, day, hour, min, 0, 0, time.UTC)
+	}
+
+	tests := []struct {
+		name string
+		expr string
+		from time.Time
+		want time.Time
+	}{
+		{"every minute", "* * * * *", utc(2026, 1, 1, 10, 0), utc(2026, 1, 1, 10, 1)},
+		{"seconds are dropped", "* * * * *", utc(2026, 1, 1, 10, 0).Add(59 * time.Second), utc(2026, 1, 1, 10, 1)},
+		{"step", "*/15 * * * *", utc(2026, 1, 1, 10, 7), utc(2026, 1, 1, 10, 15)},
+		{"step from offset", "5/20 * * * *", utc(2026, 1, 1, 10, 30), utc(2026, 1, 1, 10, 45)},
+		{"later today", "30 14 * * *", utc(2026, 1, 1, 10, 0), utc(2026, 1, 1, 14, 30)},
+		{"tomorrow", "30 2 * * *", utc(2026, 1, 1, 10, 0), utc(2026, 1, 2, 2, 30)},
+		{"same minute is not next", "0 10 * * *", utc(2026, 1, 1, 10, 0), utc(2026, 1, 2, 10, 0)},
+		{"weekdays skip the weekend", "0 9 * * 1-5", utc(2026, 1, 2, 10, 0), utc(2026, 1, 5, 9, 0)},
+		{"sunday as 7", "0 0 * * 7", utc(2026, 1, 1, 0, 0), utc(2026, 1, 4, 0, 0)},
+		{"month names", "0 0 1 jun *", utc(2026, 1, 1, 0, 0), utc(2026, 6, 1, 0, 0)},
+		{"year rollover", "@yearly", utc(2026, 12, 31, 23, 59), utc(2027, 1, 1, 0, 0)},
+		{"leap day", "0 0 29 2 *", utc(2026, 3, 1, 0, 0), utc(2028, 2, 29, 0, 0)},
+		// Both day fields restricted, either may match
+		{"day of month or week", "0 0 15 * 1", utc(2026, 1, 6, 0, 0), utc(2026, 1, 12, 0, 0)},
+		{"never", "0 0 30 2 *", utc(2026, 1, 1, 0, 0), time.Time{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cron, err := ParseCron(tt.expr)
+			if err != nil {
+				t.Fatalf("ParseCron(%q): %v", tt.expr, err)
+			}
+			if got := cron.Next(tt.from); !got.Equal(tt.want) {
+				t.Errorf("Next(%v) = %v, want %v", tt.from, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCronNextInZone(t *testing.T) {
+	newYork := loadLocation(t, "America/New_York")
+	kolkata := loadLocation(t, "Asia/Kolkata")
+	date := func(loc *time.Location, year int, month time.Month, day, hour, min int) time.Time {
+		return time.Date(year, month, day, hour, min, 0, 0, loc)
+	}
+
+	tests := []struct {
+		name string
+		expr string
+		from time.Time
+		// want lists the following fire times in order
+		want []time.Time
+	}{
+		{
+			name: "half hour offset",
+			expr: "0 * * * *",
+			from: date(kolkata, 2026, 1, 1, 10, 10),
+			want: []time.Time{date(kolkata, 2026, 1, 1, 11, 0), date(kolkata, 2026, 1, 1, 12, 0)},
+		},
+		{
+			// 02:30 does not exist on 2026-03-08, the clock jumps from 02:00 to 03:00
+			name: "skipped by spring forward",
+			expr: "30 2 * * *",
+			from: date(newYork, 2026, 3, 7, 12, 0),
+			want: []time.Time{
+				time.Date(2026, 3, 8, 7, 0, 0, 0, time.UTC),
+				date(newYork, 2026, 3, 9, 2, 30),
+			},
+		},
+		{
+			name: "hourly across spring forward",
+			expr: "15 * * * *",
+			from: date(newYork, 2026, 3, 8, 1, 0),
+			want: []time.Time{
+				time.Date(2026, 3, 8, 6, 15, 0, 0, time.UTC),
+				time.Date(2026, 3, 8, 7, 15, 0, 0, time.UTC),
+			},
+		},
+		{
+			// 01:30 happens twice on 2026-11-01, first at -04:00 then at -05:00
+			name: "repeated by fall back",
+			expr: "30 1 * * *",
+			from: date(newYork, 2026, 11, 1, 0, 0),
+			want: []time.Time{
+				time.Date(2026, 11, 1, 5, 30, 0, 0, time.UTC),
+				time.Date(2026, 11, 2, 6, 30, 0, 0, time.UTC),
+			},
+		},
+		{
+			name: "every half hour across fall back",
+			expr: "*/30 * * * *",
+			from: date(newYork, 2026, 11, 1, 1, 10),
+			want: []time.Time{
+				time.Date(2026, 11, 1, 5, 30, 0, 0, time.UTC),
+				time.Date(2026, 11, 1, 6, 0, 0, 0, time.UTC),
+				time.Date(2026, 11, 1, 6, 30, 0, 0, time.UTC),
+				time.Date(2026, 11, 1, 7, 0, 0, 0, time.UTC),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cron, err := ParseCron(tt.expr)
+			if err != nil {
+				t.Fatalf("ParseCron(%q): %v", tt.expr, err)
+			}
+			next := tt.from
+			for i, want := range tt.want {
+				next = cron.Next(next)
+				if !next.Equal(want) {
+					t.Fatalf("fire %d = %v, want %v", i+1, next, want.In(tt.from.Location()))
+				}
+				if next.Location() != tt.from.Location() {
+					t.Fatalf("fire %d is in %v, want %v", i+1, next.Location(), tt.from.Location())
+				}
+			}
+		})
+	}
+}
+
+func loadLocation(t *testing.T, name string) *time.Location {
+	t.Helper()
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		t.Skipf("time zone data unavailable: %v", err)
+	}
+	return loc
+}
@@ -0,0 +1,430 @@
+// Package schedules runs stored tool requests on cron schedules.
+package schedules
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/LeHTVy/h_ai/internal/utils"
+)
+
+var (
+	// ErrNotFound is returned for unknown schedule IDs
+	ErrNotFound = errors.New("schedule not found")
+	// ErrStillRunning is returned when a run is due while the previous one has not finished
+	ErrStillRunning = errors.New("previous run is still running")
+	// ErrForbidden is returned when a caller changes or runs a schedule of another role
+	ErrForbidden = errors.New("schedule belongs to another role")
+)
+
+// Schedule is a tool request that is run on a cron schedule
+type Schedule struct {
+	ID   string `json:"id"`
+	Name string `json:"name,omitempty"`
+	// Tool names the request type like the /api/tools routes, or "command"
+	Tool    string          `json:"tool"`
+	Request json.RawMessage `json:"request"`
+	Cron    string          `json:"cron"`
+	// Timezone is the IANA zone the cron expression is evaluated in, empty for server local time
+	Timezone string `json:"timezone,omitempty"`
+	Enabled  bool   `json:"enabled"`
+	// CreatedBy and Role are taken from the request that created or last updated the
+	// schedule, runs execute under Role
+	CreatedBy string    `json:"created_by,omitempty"`
+	Role      string    `json:"role,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	NextRun   *time.Time `json:"next_run,omitempty"`
+	LastRun   *time.Time `json:"last_run,omitempty"`
+	LastJobID string     `json:"last_job_id,omitempty"`
+	LastError string     `json:"last_error,omitempty"`
+	RunCount  int        `json:"run_count"`
+}
+
+// Runner starts the runs of schedules
+type Runner interface {
+	// Validate checks that request is a valid request for tool
+	Validate(tool string, request json.RawMessage) error
+	// Run starts the schedule's request, normally as a background job, and returns the job ID
+	Run(s Schedule) (string, error)
+	// Running reports whether the job of a previous run is still going
+	Running(jobID string) bool
+}
+
+// maxWait bounds how long the loop sleeps, so clock changes are picked up
+const maxWait = time.Minute
+
+type entry struct {
+	Schedule
+	cron *Cron
+	loc  *time.Location
+}
+
+// Manager keeps the schedules, persists them and starts their runs when due
+type Manager struct {
+	logger *zap.Logger
+	path   string
+	runner Runner
+
+	mu        sync.Mutex
+	schedules map[string]*entry
+
+	wake chan struct{}
+	stop chan struct{}
+	done chan struct{}
+}
+
+// New loads the schedules stored at path. An empty path keeps them in memory only.
+func New(logger *zap.Logger, path string, runner Runner) (*Manager, error) {
+	m := &Manager{
+		logger:    logger,
+		path:      path,
+		runner:    runner,
+		schedules: make(map[string]*entry),
+		wake:      make(chan struct{}, 1),
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	if path == "" {
+		return m, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return m, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read schedules: %w", err)
+	}
+
+	var stored []Schedule
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return nil, fmt.Errorf("failed to parse schedules: %w", err)
+	}
+
+	now := time.Now()
+	for _, s := range stored {
+		e, err := newEntry(s)
+		if err != nil {
+			logger.Warn("Skipping invalid schedule", zap.String("schedule_id", s.ID), zap.Error(err))
+			continue
+		}
+		// Runs missed while the server was down are not caught up
+		e.plan(now)
+		m.schedules[s.ID] = e
+	}
+	return m, nil
+}
+
+func newEntry(s Schedule) (*entry, error) {
+	cron, err := ParseCron(s.Cron)
+	if err != nil {
+		return nil, err
+	}
+	loc := time.Local
+	if s.Timezone != "" {
+		if loc, err = time.LoadLocation(s.Timezone); err != nil {
+			return nil, fmt.Errorf("invalid timezone %q: %w", s.Timezone, err)
+		}
+	}
+	if cron.Next(time.Now().In(loc)).IsZero() {
+		return nil, fmt.Errorf("cron expression %q never matches", s.Cron)
+	}
+	return &entry{Schedule: s, cron: cron, loc: loc}, nil
+}
+
+// plan sets the next run after now, none when disabled
+func (e *entry) plan(now time.Time) {
+	e.NextRun = nil
+	if !e.Enabled {
+		return
+	}
+	if next := e.cron.Next(now.In(e.loc)); !next.IsZero() {
+		e.NextRun = &next
+	}
+}
+
+// Start runs due schedules in the background until Close is called
+func (m *Manager) Start() {
+	go m.loop()
+}
+
+// Close stops starting runs, runs already started are left to their jobs
+func (m *Manager) Close() {
+	select {
+	case <-m.stop:
+		return
+	default:
+		close(m.stop)
+	}
+	<-m.done
+}
+
+func (m *Manager) loop() {
+	defer close(m.done)
+
+	for {
+		wait := maxWait
+		if next, ok := m.nextRun(); ok {
+			if until := time.Until(next); until < wait {
+				wait = until
+			}
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-m.stop:
+			timer.Stop()
+			return
+		case <-m.wake:
+			timer.Stop()
+		case <-timer.C:
+			m.runDue(time.Now())
+		}
+	}
+}
+
+func (m *Manager) nextRun() (time.Time, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var next time.Time
+	for _, e := range m.schedules {
+		if e.NextRun != nil && (next.IsZero() || e.NextRun.Before(next)) {
+			next = *e.NextRun
+		}
+	}
+	return next, !next.IsZero()
+}
+
+func (m *Manager) runDue(now time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	ran := false
+	for _, e := range m.schedules {
+		if e.NextRun == nil || e.NextRun.After(now) {
+			continue
+		}
+		m.run(e, now)
+		e.plan(now)
+		ran = true
+	}
+	if ran {
+		m.save()
+	}
+}
+
+// run starts one run of e. Callers must hold m.mu.
+func (m *Manager) run(e *entry, now time.Time) error {
+	if e.LastJobID != "" && m.runner.Running(e.LastJobID) {
+		m.logger.Warn("Skipping scheduled run, previous run is still going",
+			zap.String("schedule_id", e.ID), zap.String("job_id", e.LastJobID))
+		e.LastError = ErrStillRunning.Error()
+		return ErrStillRunning
+	}
+
+	jobID, err := m.runner.Run(e.Schedule)
+	e.LastRun = &now
+	if err != nil {
+		m.logger.Error("Scheduled run failed to start", zap.String("schedule_id", e.ID), zap.Error(err))
+		e.LastError = err.Error()
+		return err
+	}
+
+	m.logger.Info("Started scheduled run",
+		zap.String("schedule_id", e.ID),
+		zap.String("tool", e.Tool),
+		zap.String("job_id", jobID))
+	e.LastJobID = jobID
+	e.LastError = ""
+	e.RunCount++
+	return nil
+}
+
+// Create validates and adds a schedule
+func (m *Manager) Create(s Schedule) (Schedule, error) {
+	if err := m.runner.Validate(s.Tool, s.Request); err != nil {
+		return Schedule{}, err
+	}
+
+	now := time.Now()
+	s.ID = utils.NewID()
+	s.CreatedAt, s.UpdatedAt = now, now
+	s.NextRun, s.LastRun, s.LastJobID, s.LastError, s.RunCount = nil, nil, "", "", 0
+
+	e, err := newEntry(s)
+	if err != nil {
+		return Schedule{}, err
+	}
+	e.plan(now)
+
+	m.mu.Lock()
+	m.schedules[s.ID] = e
+	m.save()
+	m.mu.Unlock()
+
+	m.notify()
+	return e.Schedule, nil
+}
+
+// Update replaces the request, timing and state of a schedule, keeping its run statistics.
+// s.Role and s.CreatedBy are the caller's, who must have the role of the schedule.
+func (m *Manager) Update(id string, s Schedule) (Schedule, error) {
+	if err := m.runner.Validate(s.Tool, s.Request); err != nil {
+		return Schedule{}, err
+	}
+
+	m.mu.Lock()
+	defer m.notify()
+	defer m.mu.Unlock()
+
+	old, ok := m.schedules[id]
+	if !ok {
+		return Schedule{}, ErrNotFound
+	}
+	if old.Role != s.Role {
+		return Schedule{}, ErrForbidden
+	}
+
+	updated := old.Schedule
+	updated.CreatedBy = s.CreatedBy
+	updated.Name, updated.Tool, updated.Request = s.Name, s.Tool, s.Request
+	updated.Cron, updated.Timezone, updated.Enabled = s.Cron, s.Timezone, s.Enabled
+	updated.UpdatedAt = time.Now()
+
+	e, err := newEntry(updated)
+	if err != nil {
+		return Schedule{}, err
+	}
+	e.plan(updated.UpdatedAt)
+	m.schedules[id] = e
+	m.save()
+	return e.Schedule, nil
+}
+
+// SetEnabled enables or disables a schedule of role
+func (m *Manager) SetEnabled(id, role string, enabled bool) (Schedule, error) {
+	m.mu.Lock()
+	defer m.notify()
+	defer m.mu.Unlock()
+
+	e, ok := m.schedules[id]
+	if !ok {
+		return Schedule{}, ErrNotFound
+	}
+	if e.Role != role {
+		return Schedule{}, ErrForbidden
+	}
+	e.Enabled = enabled
+	e.UpdatedAt = time.Now()
+	e.plan(e.UpdatedAt)
+	m.save()
+	return e.Schedule, nil
+}
+
+// RunNow starts a run of a schedule of role immediately, enabled or not. The planned
+// runs are unchanged.
+func (m *Manager) RunNow(id, role string) (Schedule, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e, ok := m.schedules[id]
+	if !ok {
+		return Schedule{}, ErrNotFound
+	}
+	if e.Role != role {
+		return Schedule{}, ErrForbidden
+	}
+	err := m.run(e, time.Now())
+	m.save()
+	return e.Schedule, err
+}
+
+// Delete removes a schedule of role
+func (m *Manager) Delete(id, role string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e, ok := m.schedules[id]
+	if !ok {
+		return ErrNotFound
+	}
+	if e.Role != role {
+		return ErrForbidden
+	}
+	delete(m.schedules, id)
+	m.save()
+	return nil
+}
+
+// Get returns a schedule of role by ID, schedules of other roles are not found
+func (m *Manager) Get(id, role string) (Schedule, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e, ok := m.schedules[id]
+	if !ok || e.Role != role {
+		return Schedule{}, false
+	}
+	return e.Schedule, true
+}
+
+// List returns the schedules of role, oldest first
+func (m *Manager) List(role string) []Schedule {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	schedules := []Schedule{}
+	for _, s := range m.list() {
+		if s.Role == role {
+			schedules = append(schedules, s)
+		}
+	}
+	return schedules
+}
+
+func (m *Manager) list() []Schedule {
+	schedules := make([]Schedule, 0, len(m.schedules))
+	for _, e := range m.schedules {
+		schedules = append(schedules, e.Schedule)
+	}
+	sort.Slice(schedules, func(i, j int) bool {
+		return schedules[i].CreatedAt.Before(schedules[j].CreatedAt)
+	})
+	return schedules
+}
+
+// save writes the schedules to disk. Callers must hold m.mu.
+func (m *Manager) save() {
+	if m.path == "" {
+		return
+	}
+
+	data, err := json.MarshalIndent(m.list(), "", "  ")
+	if err == nil {
+		tmp := m.path + ".tmp"
+		if err = os.WriteFile(tmp, data, 0640); err == nil {
+			err = os.Rename(tmp, m.path)
+		}
+	}
+	if err != nil {
+		m.logger.Error("Failed to save schedules", zap.Error(err))
+	}
+}
+
+// notify wakes the loop to recompute the next run
+func (m *Manager) notify() {
+	select {
+	case m.wake <- struct{}{}:
+	default:
+	}
+}
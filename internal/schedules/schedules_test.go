@@ -0,0 +1,62 @@
+package schedules
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+// nopRunner accepts every request and never runs anything
+type nopRunner struct{}
+
+func (nopRunner) Validate(tool string, request json.RawMessage) error { return nil }
+func (nopRunner) Run(s Schedule) (string, error)                      { return "", errors.New("not running") }
+func (nopRunner) Running(jobID string) bool                           { return false }
+
+func TestSchedulesAreScopedToTheirRole(t *testing.T) {
+	m, err := New(zap.NewNop(), "", nopRunner{})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	create := func(role string) Schedule {
+		t.Helper()
+		s, err := m.Create(Schedule{Tool: "nmap", Request: json.RawMessage(`{}`), Cron: "@daily", Role: role})
+		if err != nil {
+			t.Fatalf("Create: %v", err)
+		}
+		return s
+	}
+	admin := create("admin")
+	analyst := create("")
+
+	tests := []struct {
+		role  string
+		own   Schedule
+		other Schedule
+	}{
+		{role: "admin", own: admin, other: analyst},
+		{role: "", own: analyst, other: admin},
+	}
+	for _, tt := range tests {
+		list := m.List(tt.role)
+		if len(list) != 1 || list[0].ID != tt.own.ID {
+			t.Errorf("List(%q) = %v, want only %s", tt.role, list, tt.own.ID)
+		}
+		if _, found := m.Get(tt.own.ID, tt.role); !found {
+			t.Errorf("Get(own schedule, %q) not found", tt.role)
+		}
+		if _, found := m.Get(tt.other.ID, tt.role); found {
+			t.Errorf("Get(schedule of another role, %q) found it", tt.role)
+		}
+		if err := m.Delete(tt.other.ID, tt.role); !errors.Is(err, ErrForbidden) {
+			t.Errorf("Delete(schedule of another role, %q) = %v, want ErrForbidden", tt.role, err)
+		}
+	}
+
+	if list := m.List("auditor"); len(list) != 0 {
+		t.Errorf("List(auditor) = %v, want none", list)
+	}
+}
@@ -0,0 +1,249 @@
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/LeHTVy/h_ai/internal/cluster"
+	"github.com/LeHTVy/h_ai/internal/executor"
+	"github.com/LeHTVy/h_ai/internal/models"
+)
+
+// clusterAuth only lets worker nodes presenting the cluster token through
+func (s *Server) clusterAuth() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if s.clusterToken == "" {
+			c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{
+				"error": "worker registration is disabled, start the server with --cluster-token",
+			})
+			return
+		}
+		token := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(s.clusterToken)) != 1 {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid cluster token"})
+			return
+		}
+		c.Next()
+	}
+}
+
+func (s *Server) handleWorkerList(c *gin.Context) {
+	workers := s.cluster.Workers()
+	c.JSON(http.StatusOK, gin.H{"workers": workers, "count": len(workers)})
+}
+
+// handleClusterDispatch sends a tool request to worker nodes as a background job. The
+// job finishes once every task has, its result holds the result of each worker.
+func (s *Server) handleClusterDispatch(c *gin.Context) {
+	var req models.DispatchRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Tool == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "tool is required"})
+		return
+	}
+	// Catch malformed requests here rather than on every worker
+	if _, err := s.tools.Prepare(req.Tool, req.Request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	// Workers may run without a policy, the coordinator's applies to everything it sends
+	if rule, err := s.tools.Authorize(c.Request.Context(), req.Tool, req.Request); err != nil {
+		if rule == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusForbidden, gin.H{"success": false, "error": err.Error(), "policy_violation": rule})
+		return
+	}
+
+	sel := cluster.Selector{Worker: req.Worker, Segment: req.Segment, All: req.All}
+	workers, err := s.cluster.Match(req.Tool, sel)
+	if err != nil {
+		c.JSON(clusterErrorStatus(err), gin.H{"success": false, "error": err.Error()})
+		return
+	}
+
+	caller := c.Request.Context()
+	job, err := s.jobs.Submit(req.Tool, func(ctx context.Context) map[string]interface{} {
+		ctx = withCaller(ctx, caller)
+		tasks, err := s.cluster.Dispatch(ctx, req.Tool, req.Request, sel)
+		if err != nil {
+			return map[string]interface{}{"success": false, "error": err.Error()}
+		}
+		ids := make([]string, len(tasks))
+		for i, task := range tasks {
+			ids[i] = task.ID
+		}
+		return clusterResult(req.Tool, s.cluster.Wait(ctx, ids))
+	})
+	if err != nil {
+		c.Header("Retry-After", "30")
+		c.JSON(http.StatusServiceUnavailable, gin.H{"success": false, "error": err.Error()})
+		return
+	}
+
+	names := make([]string, len(workers))
+	for i, w := range workers {
+		names[i] = w.Name
+	}
+	c.JSON(http.StatusAccepted, gin.H{
+		"success":    true,
+		"job_id":     job.ID,
+		"status":     job.Status,
+		"status_url": "/api/jobs/" + job.ID,
+		"tasks_url":  "/api/cluster/tasks?job_id=" + job.ID,
+		"workers":    names,
+	})
+}
+
+// clusterResult aggregates the tasks of a dispatch into a job result
+func clusterResult(tool string, tasks []cluster.Task) map[string]interface{} {
+	results := make([]map[string]interface{}, 0, len(tasks))
+	failed := 0
+	for _, task := range tasks {
+		if task.Status != cluster.TaskCompleted {
+			failed++
+		}
+		results = append(results, map[string]interface{}{
+			"task_id": task.ID,
+			"worker":  task.WorkerName,
+			"segment": task.Segment,
+			"status":  task.Status,
+			"result":  task.Result,
+			"error":   task.Error,
+		})
+	}
+
+	result := map[string]interface{}{
+		"success": len(tasks) > 0 && failed == 0,
+		"tool":    tool,
+		"tasks":   results,
+		"total":   len(tasks),
+		"failed":  failed,
+	}
+	if failed > 0 {
+		result["error"] = fmt.Sprintf("%d of %d worker tasks did not complete", failed, len(tasks))
+	}
+	return result
+}
+
+func (s *Server) handleClusterTaskList(c *gin.Context) {
+	tasks := s.cluster.Tasks(c.Query("job_id"))
+	c.JSON(http.StatusOK, gin.H{"tasks": tasks, "count": len(tasks)})
+}
+
+func (s *Server) handleClusterTask(c *gin.Context) {
+	task, found := s.cluster.Task(c.Param("id"))
+	if !found {
+		c.JSON(http.StatusNotFound, gin.H{"error": cluster.ErrTaskNotFound.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, task)
+}
+
+func (s *Server) handleWorkerRegister(c *gin.Context) {
+	var reg cluster.Registration
+	if err := c.ShouldBindJSON(&reg); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	worker, err := s.cluster.Register(reg, c.ClientIP())
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{
+		"worker":             worker,
+		"heartbeat_interval": cluster.HeartbeatInterval.Seconds(),
+	})
+}
+
+func (s *Server) handleWorkerLeave(c *gin.Context) {
+	if err := s.cluster.Deregister(c.Param("id")); err != nil {
+		c.JSON(clusterErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
+
+func (s *Server) handleWorkerHeartbeat(c *gin.Context) {
+	var hb cluster.Heartbeat
+	if err := c.ShouldBindJSON(&hb); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	resp, err := s.cluster.Heartbeat(c.Param("id"), hb)
+	if err != nil {
+		c.JSON(clusterErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// handleWorkerNext hands a worker its next task. The request is held open for up to
+// ?wait (a duration, capped at cluster.MaxPollWait) and gets 204 when nothing came up.
+func (s *Server) handleWorkerNext(c *gin.Context) {
+	wait := cluster.MaxPollWait
+	if raw := c.Query("wait"); raw != "" {
+		parsed, err := time.ParseDuration(raw)
+		if err != nil || parsed < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "wait must be a duration like 20s"})
+			return
+		}
+		if parsed < wait {
+			wait = parsed
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), wait)
+	defer cancel()
+	task, found, err := s.cluster.Next(ctx, c.Param("id"))
+	if err != nil {
+		c.JSON(clusterErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	if !found {
+		c.Status(http.StatusNoContent)
+		return
+	}
+	if c.Request.Context().Err() != nil {
+		// The worker gave up on the poll, nobody would receive the task
+		s.cluster.Release(c.Param("id"), task.ID)
+		return
+	}
+	c.JSON(http.StatusOK, task)
+}
+
+func (s *Server) handleWorkerTaskResult(c *gin.Context) {
+	var res cluster.TaskResult
+	if err := c.ShouldBindJSON(&res); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := s.cluster.Complete(c.Param("id"), c.Param("task"), res); err != nil {
+		c.JSON(clusterErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
+
+func clusterErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, cluster.ErrUnknownWorker), errors.Is(err, cluster.ErrTaskNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, cluster.ErrNoWorker), errors.Is(err, executor.ErrShuttingDown):
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusBadRequest
+	}
+}
@@ -1,15 +1,22 @@
 package server
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"net/http"
 	"path/filepath"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"go.uber.org/zap"
 
 	"github.com/LeHTVy/h_ai/internal/ai"
+	"github.com/LeHTVy/h_ai/internal/executor"
+	"github.com/LeHTVy/h_ai/internal/history"
+	"github.com/LeHTVy/h_ai/internal/jobs"
 	"github.com/LeHTVy/h_ai/internal/models"
 )
 
@@ -20,6 +27,7 @@ func (s *Server) handleHealth(c *gin.Context) {
 		"timestamp":                     "",
 		"tools_status":                  s.tools.CheckToolsAvailability(),
 		"all_essential_tools_available": true,
+		"exec_mode":                     s.executor.Mode(),
 	}
 	c.JSON(http.StatusOK, health)
 }
@@ -31,8 +39,65 @@ func (s *Server) handleCommand(c *gin.Context) {
 		return
 	}
 
-	result := s.executor.Execute(req.Command, req.UseCache)
-	c.JSON(http.StatusOK, result)
+	s.runTool(c, "command", func(ctx context.Context) map[string]interface{} {
+		return s.tools.ExecuteCommand(ctx, req)
+	})
+}
+
+// runTool runs fn inline, or as a background job when the request carries ?async=true.
+// Async requests get 202 Accepted with the job ID to poll under /api/jobs/:id.
+// Inline runs are bound to the request context, so a disconnecting client kills the process.
+func (s *Server) runTool(c *gin.Context, tool string, fn jobs.Func) {
+	if async, _ := strconv.ParseBool(c.Query("async")); async {
+		// The job outlives the request, carry the caller over for the history and policy
+		caller := c.Request.Context()
+		job, err := s.jobs.Submit(tool, func(ctx context.Context) map[string]interface{} {
+			return fn(withCaller(ctx, caller))
+		})
+		if err != nil {
+			c.Header("Retry-After", "30")
+			c.JSON(http.StatusServiceUnavailable, gin.H{"success": false, "error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusAccepted, gin.H{
+			"success":    true,
+			"job_id":     job.ID,
+			"status":     job.Status,
+			"status_url": "/api/jobs/" + job.ID,
+		})
+		return
+	}
+
+	result := fn(c.Request.Context())
+	reason, _ := result["failure_reason"].(executor.FailureReason)
+	if reason == executor.FailureQueueRejected {
+		c.Header("Retry-After", "30")
+	}
+	c.JSON(failureStatus(reason), result)
+}
+
+// statusClientClosedRequest is the nginx status for requests the client gave up on
+const statusClientClosedRequest = 499
+
+// failureStatus maps the failure reason of a tool result to the status of the response.
+// A tool exiting non-zero still ran, its output is the result.
+func failureStatus(reason executor.FailureReason) int {
+	switch reason {
+	case executor.FailureInvalidRequest:
+		return http.StatusBadRequest
+	case executor.FailurePolicyDenied:
+		return http.StatusForbidden
+	case executor.FailureQueueRejected, executor.FailureNotFound:
+		return http.StatusServiceUnavailable
+	case executor.FailureTimeout:
+		return http.StatusGatewayTimeout
+	case executor.FailureCancelled:
+		return statusClientClosedRequest
+	case executor.FailureSignaled, executor.FailureSpawnError, executor.FailureResourceLimit:
+		return http.StatusInternalServerError
+	default:
+		return http.StatusOK
+	}
 }
 
 // Nmap handler
@@ -48,8 +113,9 @@ func (s *Server) handleNmap(c *gin.Context) {
 		return
 	}
 
-	result := s.tools.ExecuteNmap(req)
-	c.JSON(http.StatusOK, result)
+	s.runTool(c, "nmap", func(ctx context.Context) map[string]interface{} {
+		return s.tools.ExecuteNmap(ctx, req)
+	})
 }
 
 // Nmap Advanced handler
@@ -65,8 +131,9 @@ func (s *Server) handleNmapAdvanced(c *gin.Context) {
 		return
 	}
 
-	result := s.tools.ExecuteNmapAdvanced(req)
-	c.JSON(http.StatusOK, result)
+	s.runTool(c, "nmap-advanced", func(ctx context.Context) map[string]interface{} {
+		return s.tools.ExecuteNmapAdvanced(ctx, req)
+	})
 }
 
 // Metasploit handler
@@ -82,8 +149,9 @@ func (s *Server) handleMetasploit(c *gin.Context) {
 		return
 	}
 
-	result := s.tools.ExecuteMetasploit(req)
-	c.JSON(http.StatusOK, result)
+	s.runTool(c, "metasploit", func(ctx context.Context) map[string]interface{} {
+		return s.tools.ExecuteMetasploit(ctx, req)
+	})
 }
 
 // Gobuster handler
@@ -94,8 +162,9 @@ func (s *Server) handleGobuster(c *gin.Context) {
 		return
 	}
 
-	result := s.tools.ExecuteGobuster(req)
-	c.JSON(http.StatusOK, result)
+	s.runTool(c, "gobuster", func(ctx context.Context) map[string]interface{} {
+		return s.tools.ExecuteGobuster(ctx, req)
+	})
 }
 
 // Nuclei handler
@@ -106,8 +175,9 @@ func (s *Server) handleNuclei(c *gin.Context) {
 		return
 	}
 
-	result := s.tools.ExecuteNuclei(req)
-	c.JSON(http.StatusOK, result)
+	s.runTool(c, "nuclei", func(ctx context.Context) map[string]interface{} {
+		return s.tools.ExecuteNuclei(ctx, req)
+	})
 }
 
 // SQLMap handler
@@ -118,8 +188,9 @@ func (s *Server) handleSqlmap(c *gin.Context) {
 		return
 	}
 
-	result := s.tools.ExecuteSqlmap(req)
-	c.JSON(http.StatusOK, result)
+	s.runTool(c, "sqlmap", func(ctx context.Context) map[string]interface{} {
+		return s.tools.ExecuteSqlmap(ctx, req)
+	})
 }
 
 // Hydra handler
@@ -130,8 +201,9 @@ func (s *Server) handleHydra(c *gin.Context) {
 		return
 	}
 
-	result := s.tools.ExecuteHydra(req)
-	c.JSON(http.StatusOK, result)
+	s.runTool(c, "hydra", func(ctx context.Context) map[string]interface{} {
+		return s.tools.ExecuteHydra(ctx, req)
+	})
 }
 
 // FFuf handler
@@ -142,8 +214,9 @@ func (s *Server) handleFFuf(c *gin.Context) {
 		return
 	}
 
-	result := s.tools.ExecuteFFuf(req)
-	c.JSON(http.StatusOK, result)
+	s.runTool(c, "ffuf", func(ctx context.Context) map[string]interface{} {
+		return s.tools.ExecuteFFuf(ctx, req)
+	})
 }
 
 // NetExec handler
@@ -154,8 +227,9 @@ func (s *Server) handleNetexec(c *gin.Context) {
 		return
 	}
 
-	result := s.tools.ExecuteNetexec(req)
-	c.JSON(http.StatusOK, result)
+	s.runTool(c, "netexec", func(ctx context.Context) map[string]interface{} {
+		return s.tools.ExecuteNetexec(ctx, req)
+	})
 }
 
 // Amass handler
@@ -166,8 +240,9 @@ func (s *Server) handleAmass(c *gin.Context) {
 		return
 	}
 
-	result := s.tools.ExecuteAmass(req)
-	c.JSON(http.StatusOK, result)
+	s.runTool(c, "amass", func(ctx context.Context) map[string]interface{} {
+		return s.tools.ExecuteAmass(ctx, req)
+	})
 }
 
 // Masscan handler
@@ -178,8 +253,9 @@ func (s *Server) handleMasscan(c *gin.Context) {
 		return
 	}
 
-	result := s.tools.ExecuteMasscan(req)
-	c.JSON(http.StatusOK, result)
+	s.runTool(c, "masscan", func(ctx context.Context) map[string]interface{} {
+		return s.tools.ExecuteMasscan(ctx, req)
+	})
 }
 
 // AutoRecon handler
@@ -190,8 +266,9 @@ func (s *Server) handleAutoRecon(c *gin.Context) {
 		return
 	}
 
-	result := s.tools.ExecuteAutoRecon(req)
-	c.JSON(http.StatusOK, result)
+	s.runTool(c, "autorecon", func(ctx context.Context) map[string]interface{} {
+		return s.tools.ExecuteAutoRecon(ctx, req)
+	})
 }
 
 // MSFVenom handler
@@ -202,8 +279,9 @@ func (s *Server) handleMSFVenom(c *gin.Context) {
 		return
 	}
 
-	result := s.tools.ExecuteMSFVenom(req)
-	c.JSON(http.StatusOK, result)
+	s.runTool(c, "msfvenom", func(ctx context.Context) map[string]interface{} {
+		return s.tools.ExecuteMSFVenom(ctx, req)
+	})
 }
 
 // Intelligence handlers
@@ -281,6 +359,9 @@ func (s *Server) handleOptimizeParameters(c *gin.Context) {
 		"tool":             req.Tool,
 		"optimized_params": optimizedParams,
 	}
+	if req.DryRun {
+		result["dry_run"] = s.plannedCommand(c.Request.Context(), req.Tool, optimizedParams)
+	}
 	c.JSON(http.StatusOK, result)
 }
 
@@ -307,6 +388,13 @@ func (s *Server) handleCreateAttackChain(c *gin.Context) {
 		"attack_chain": chain,
 		"objective":    objective,
 	}
+	if req.DryRun {
+		planned := make([]map[string]interface{}, 0, len(chain.Steps))
+		for _, step := range chain.Steps {
+			planned = append(planned, s.plannedCommand(c.Request.Context(), step.Tool, step.Parameters))
+		}
+		result["dry_run"] = planned
+	}
 	c.JSON(http.StatusOK, result)
 }
 
@@ -345,9 +433,26 @@ func (s *Server) handleSmartScan(c *gin.Context) {
 		"objective":      objective,
 		"message":        "Smart scan prepared. Tools are ready for execution.",
 	}
+	if req.DryRun {
+		planned := make([]map[string]interface{}, 0, len(selectedTools))
+		for _, tool := range selectedTools {
+			params := s.engine.OptimizeParameters(tool, profile, map[string]interface{}{"target": req.Target})
+			planned = append(planned, s.plannedCommand(c.Request.Context(), tool, params))
+		}
+		result["dry_run"] = planned
+	}
 	c.JSON(http.StatusOK, result)
 }
 
+// plannedCommand resolves the command tool would run with params, for dry runs of
+// the intelligence endpoints
+func (s *Server) plannedCommand(ctx context.Context, tool string, params map[string]interface{}) map[string]interface{} {
+	planned := s.tools.DryRun(ctx, tool, params)
+	planned["tool"] = tool
+	planned["params"] = params
+	return planned
+}
+
 func (s *Server) handleAnalyzeResults(c *gin.Context) {
 	var req struct {
 		Tool    string `json:"tool" binding:"required"`
@@ -506,8 +611,19 @@ func (s *Server) handleListModels(c *gin.Context) {
 }
 
 // Process management handlers
+// handleProcessList lists queued, running and recently finished processes, ?status
+// keeps only those in one state
 func (s *Server) handleProcessList(c *gin.Context) {
 	processes := s.executor.ListProcesses()
+	if status := c.Query("status"); status != "" {
+		filtered := make([]executor.ProcessInfo, 0, len(processes))
+		for _, proc := range processes {
+			if proc.Status == status {
+				filtered = append(filtered, proc)
+			}
+		}
+		processes = filtered
+	}
 	c.JSON(http.StatusOK, gin.H{"processes": processes})
 }
 
@@ -537,18 +653,295 @@ func (s *Server) handleProcessTerminate(c *gin.Context) {
 	}
 
 	if err := s.executor.TerminateProcess(pid); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.JSON(processErrorStatus(err), gin.H{"error": err.Error()})
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{"message": "Process terminated", "pid": pid})
 }
 
+// handleProcessPause stops a running process group until it is resumed
+func (s *Server) handleProcessPause(c *gin.Context) {
+	pid, err := strconv.Atoi(c.Param("pid"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid PID"})
+		return
+	}
+
+	if err := s.executor.SuspendProcess(pid); err != nil {
+		c.JSON(processErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Process paused", "process": s.executor.GetProcessStatus(pid)})
+}
+
+// handleProcessResume continues a paused process group
+func (s *Server) handleProcessResume(c *gin.Context) {
+	pid, err := strconv.Atoi(c.Param("pid"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid PID"})
+		return
+	}
+
+	if err := s.executor.ResumeProcess(pid); err != nil {
+		c.JSON(processErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Process resumed", "process": s.executor.GetProcessStatus(pid)})
+}
+
+// processErrorStatus maps executor process errors to HTTP status codes
+func processErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, executor.ErrProcessNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, executor.ErrProcessPaused), errors.Is(err, executor.ErrProcessNotPaused),
+		errors.Is(err, executor.ErrProcessFinished):
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
 func (s *Server) handleProcessDashboard(c *gin.Context) {
 	dashboard := s.executor.GetDashboard()
 	c.JSON(http.StatusOK, dashboard)
 }
 
+// Job handlers
+func (s *Server) handleJobList(c *gin.Context) {
+	jobList := s.jobs.List()
+	c.JSON(http.StatusOK, gin.H{"jobs": jobList, "count": len(jobList)})
+}
+
+func (s *Server) handleJobStatus(c *gin.Context) {
+	job, found := s.jobs.Get(c.Param("id"))
+	if !found {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, job)
+}
+
+func (s *Server) handleJobCancel(c *gin.Context) {
+	id := c.Param("id")
+	job, found := s.jobs.Get(id)
+	if !found {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+		return
+	}
+
+	if err := s.jobs.Cancel(id); err != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error(), "status": job.Status})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Job cancelled", "job_id": id})
+}
+
+// Artifact handlers
+func (s *Server) handleArtifactList(c *gin.Context) {
+	store := s.executor.Artifacts()
+	if store == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Output artifacts are disabled"})
+		return
+	}
+
+	artifacts, err := store.List(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Artifact not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"artifact_id": c.Param("id"), "files": artifacts})
+}
+
+// handleArtifactDownload serves an artifact file, honouring Range headers for partial reads
+func (s *Server) handleArtifactDownload(c *gin.Context) {
+	store := s.executor.Artifacts()
+	if store == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Output artifacts are disabled"})
+		return
+	}
+
+	file, err := store.Open(c.Param("id"), strings.TrimPrefix(c.Param("name"), "/"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Artifact not found"})
+		return
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if info.IsDir() {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Artifact not found"})
+		return
+	}
+
+	// Captured output is text, files written by tools are typed by ServeContent
+	switch filepath.Ext(info.Name()) {
+	case ".log":
+		c.Header("Content-Type", "text/plain; charset=utf-8")
+	case ".cast":
+		c.Header("Content-Type", "application/x-asciicast")
+	}
+	http.ServeContent(c.Writer, c.Request, info.Name(), info.ModTime(), file)
+}
+
+// handleJobRecordings lists the asciicast recordings of a job's executions
+func (s *Server) handleJobRecordings(c *gin.Context) {
+	records, ok := s.jobRecordings(c)
+	if !ok {
+		return
+	}
+
+	recordings := make([]gin.H, 0, len(records))
+	for _, rec := range records {
+		recordings = append(recordings, gin.H{
+			"execution_id": rec.ID,
+			"tool":         rec.Tool,
+			"command":      rec.Command,
+			"started_at":   rec.StartedAt,
+			"duration":     rec.Duration,
+			"url":          "/api/artifacts/" + rec.ArtifactID + "/" + rec.Recording,
+		})
+	}
+	c.JSON(http.StatusOK, gin.H{"job_id": c.Param("id"), "recordings": recordings, "count": len(recordings)})
+}
+
+// handleJobRecording serves the recording of a job's first execution,
+// or of the execution named by the execution_id query parameter
+func (s *Server) handleJobRecording(c *gin.Context) {
+	records, ok := s.jobRecordings(c)
+	if !ok {
+		return
+	}
+
+	rec := records[0]
+	if id := c.Query("execution_id"); id != "" {
+		found := false
+		for _, r := range records {
+			if r.ID == id {
+				rec, found = r, true
+				break
+			}
+		}
+		if !found {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Recording not found"})
+			return
+		}
+	}
+
+	file, err := s.executor.Artifacts().Open(rec.ArtifactID, rec.Recording)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Recording not found"})
+		return
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Header("Content-Type", "application/x-asciicast")
+	c.Header("Content-Disposition", fmt.Sprintf("inline; filename=%q", c.Param("id")+"-"+rec.ID+".cast"))
+	http.ServeContent(c.Writer, c.Request, info.Name(), info.ModTime(), file)
+}
+
+// jobRecordings returns the recorded executions of a job, oldest first
+func (s *Server) jobRecordings(c *gin.Context) ([]history.Record, bool) {
+	if s.history == nil || s.executor.Artifacts() == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Recordings are disabled"})
+		return nil, false
+	}
+
+	records, err := s.history.Query(history.Filter{JobID: c.Param("id"), Limit: 1000})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil, false
+	}
+
+	recorded := []history.Record{}
+	for i := len(records) - 1; i >= 0; i-- {
+		if records[i].Recording != "" && records[i].ArtifactID != "" {
+			recorded = append(recorded, records[i])
+		}
+	}
+	if len(recorded) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No recordings for job"})
+		return nil, false
+	}
+	return recorded, true
+}
+
+// History handlers
+func (s *Server) handleHistoryList(c *gin.Context) {
+	if s.history == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Execution history is disabled"})
+		return
+	}
+
+	filter := history.Filter{
+		Tool:      c.Query("tool"),
+		Target:    c.Query("target"),
+		Requester: c.Query("requester"),
+		Status:    c.Query("status"),
+	}
+	for param, dst := range map[string]*time.Time{"since": &filter.Since, "until": &filter.Until} {
+		if value := c.Query(param); value != "" {
+			t, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid %s, expected RFC 3339 time", param)})
+				return
+			}
+			*dst = t
+		}
+	}
+	if value := c.Query("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit"})
+			return
+		}
+		filter.Limit = limit
+	}
+
+	records, err := s.history.Query(filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"executions": records, "count": len(records)})
+}
+
+func (s *Server) handleHistoryGet(c *gin.Context) {
+	if s.history == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Execution history is disabled"})
+		return
+	}
+
+	record, found, err := s.history.Get(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !found {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Execution not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, record)
+}
+
 // Cache handlers
 func (s *Server) handleCacheStats(c *gin.Context) {
 	stats := s.cache.Stats()
@@ -557,11 +950,24 @@ func (s *Server) handleCacheStats(c *gin.Context) {
 
 // Telemetry handler
 func (s *Server) handleTelemetry(c *gin.Context) {
+	dashboard := s.executor.GetDashboard()
+
 	telemetry := map[string]interface{}{
-		"uptime":           time.Since(time.Now()).Seconds(), // TODO: Track actual uptime
-		"cpu_usage":        0,                                // TODO: Implement CPU usage tracking
-		"memory_usage":     0,                                // TODO: Implement memory usage tracking
-		"active_processes": len(s.executor.ListProcesses()),
+		"uptime":           time.Since(s.startTime).Seconds(),
+		"started_at":       s.startTime,
+		"active_processes": dashboard["active_processes"],
+		"process_usage":    dashboard["total_usage"],
 	}
+
+	host, err := s.hostStats.Host()
+	if err != nil {
+		telemetry["host_error"] = err.Error()
+	} else {
+		telemetry["cpu_usage"] = host.CPUPercent
+		telemetry["memory_usage"] = host.MemoryPercent
+	}
+	telemetry["host"] = host
+	telemetry["cache"] = s.cache.Stats()
+
 	c.JSON(http.StatusOK, telemetry)
 }
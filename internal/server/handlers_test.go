@@ -0,0 +1,33 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/LeHTVy/h_ai/internal/executor"
+)
+
+func TestFailureStatus(t *testing.T) {
+	tests := []struct {
+		reason executor.FailureReason
+		want   int
+	}{
+		{"", http.StatusOK},
+		{executor.FailureNonzeroExit, http.StatusOK},
+		{executor.FailureInvalidRequest, http.StatusBadRequest},
+		{executor.FailurePolicyDenied, http.StatusForbidden},
+		{executor.FailureQueueRejected, http.StatusServiceUnavailable},
+		{executor.FailureNotFound, http.StatusServiceUnavailable},
+		{executor.FailureTimeout, http.StatusGatewayTimeout},
+		{executor.FailureCancelled, statusClientClosedRequest},
+		{executor.FailureSignaled, http.StatusInternalServerError},
+		{executor.FailureSpawnError, http.StatusInternalServerError},
+		{executor.FailureResourceLimit, http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		if got := failureStatus(tt.reason); got != tt.want {
+			t.Errorf("failureStatus(%q) = %d, want %d", tt.reason, got, tt.want)
+		}
+	}
+}
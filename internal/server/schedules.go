@@ -0,0 +1,204 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/LeHTVy/h_ai/internal/executor"
+	"github.com/LeHTVy/h_ai/internal/models"
+	"github.com/LeHTVy/h_ai/internal/policy"
+	"github.com/LeHTVy/h_ai/internal/schedules"
+)
+
+// scheduleRunner runs schedules as background jobs through the tools manager
+type scheduleRunner struct {
+	s *Server
+}
+
+func (r scheduleRunner) Validate(tool string, request json.RawMessage) error {
+	_, err := r.s.tools.Prepare(tool, request)
+	return err
+}
+
+// Run submits the schedule's request as a job, bypassing cached results. Its executions
+// are recorded in the history with the requester "schedule:<id>".
+func (r scheduleRunner) Run(sched schedules.Schedule) (string, error) {
+	run, err := r.s.tools.Prepare(sched.Tool, sched.Request)
+	if err != nil {
+		return "", err
+	}
+
+	job, err := r.s.jobs.Submit(sched.Tool, func(ctx context.Context) map[string]interface{} {
+		// A scheduled scan is only useful if it actually runs
+		ctx = executor.WithFreshResults(executor.WithRequester(ctx, "schedule:"+sched.ID))
+		return run(policy.WithRole(ctx, sched.Role))
+	})
+	if err != nil {
+		return "", err
+	}
+	return job.ID, nil
+}
+
+func (r scheduleRunner) Running(jobID string) bool {
+	job, found := r.s.jobs.Get(jobID)
+	return found && !job.Finished()
+}
+
+// handleScheduleList lists the schedules of the caller's role
+func (s *Server) handleScheduleList(c *gin.Context) {
+	list := s.schedules.List(policy.RoleFromContext(c.Request.Context()))
+	c.JSON(http.StatusOK, gin.H{"schedules": list, "count": len(list)})
+}
+
+func (s *Server) handleScheduleGet(c *gin.Context) {
+	sched, found := s.schedules.Get(c.Param("id"), policy.RoleFromContext(c.Request.Context()))
+	if !found {
+		c.JSON(http.StatusNotFound, gin.H{"error": schedules.ErrNotFound.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, sched)
+}
+
+// handleScheduleCreate stores a tool request to run on a cron schedule. The caller's
+// role is kept so scheduled runs are held to the same policy as the caller.
+func (s *Server) handleScheduleCreate(c *gin.Context) {
+	sched, ok := bindSchedule(c)
+	if !ok {
+		return
+	}
+	sched.CreatedBy = executor.RequesterFromContext(c.Request.Context())
+	sched.Role = policy.RoleFromContext(c.Request.Context())
+	if !s.authorizeSchedule(c, sched) {
+		return
+	}
+
+	created, err := s.schedules.Create(sched)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, created)
+}
+
+func (s *Server) handleScheduleUpdate(c *gin.Context) {
+	sched, ok := bindSchedule(c)
+	if !ok {
+		return
+	}
+	// Runs execute under the schedule's role, only callers holding it may change them
+	sched.CreatedBy = executor.RequesterFromContext(c.Request.Context())
+	sched.Role = policy.RoleFromContext(c.Request.Context())
+	if !s.authorizeSchedule(c, sched) {
+		return
+	}
+
+	updated, err := s.schedules.Update(c.Param("id"), sched)
+	if err != nil {
+		c.JSON(scheduleErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, updated)
+}
+
+func (s *Server) handleScheduleDelete(c *gin.Context) {
+	if err := s.schedules.Delete(c.Param("id"), policy.RoleFromContext(c.Request.Context())); err != nil {
+		c.JSON(scheduleErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
+
+func (s *Server) handleScheduleEnable(c *gin.Context) {
+	s.setScheduleEnabled(c, true)
+}
+
+func (s *Server) handleScheduleDisable(c *gin.Context) {
+	s.setScheduleEnabled(c, false)
+}
+
+func (s *Server) setScheduleEnabled(c *gin.Context, enabled bool) {
+	sched, err := s.schedules.SetEnabled(c.Param("id"), policy.RoleFromContext(c.Request.Context()), enabled)
+	if err != nil {
+		c.JSON(scheduleErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, sched)
+}
+
+// handleScheduleRun starts a run of a schedule right away
+func (s *Server) handleScheduleRun(c *gin.Context) {
+	sched, err := s.schedules.RunNow(c.Param("id"), policy.RoleFromContext(c.Request.Context()))
+	if err != nil {
+		body := gin.H{"success": false, "error": err.Error()}
+		if sched.ID != "" {
+			body["schedule"] = sched
+		}
+		c.JSON(scheduleErrorStatus(err), body)
+		return
+	}
+	c.JSON(http.StatusAccepted, gin.H{
+		"success":    true,
+		"job_id":     sched.LastJobID,
+		"status_url": "/api/jobs/" + sched.LastJobID,
+		"schedule":   sched,
+	})
+}
+
+// authorizeSchedule checks the request of a schedule against the policy under the
+// caller's role, so a schedule is not accepted only to be denied on every run
+func (s *Server) authorizeSchedule(c *gin.Context, sched schedules.Schedule) bool {
+	rule, err := s.tools.Authorize(c.Request.Context(), sched.Tool, sched.Request)
+	if err == nil {
+		return true
+	}
+	if rule == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return false
+	}
+	c.JSON(http.StatusForbidden, gin.H{"error": err.Error(), "policy_violation": rule})
+	return false
+}
+
+func bindSchedule(c *gin.Context) (schedules.Schedule, bool) {
+	var req models.ScheduleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return schedules.Schedule{}, false
+	}
+	if req.Tool == "" || req.Cron == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "tool and cron are required"})
+		return schedules.Schedule{}, false
+	}
+
+	enabled := true
+	if req.Enabled != nil {
+		enabled = *req.Enabled
+	}
+	return schedules.Schedule{
+		Name:     req.Name,
+		Tool:     req.Tool,
+		Request:  req.Request,
+		Cron:     req.Cron,
+		Timezone: req.Timezone,
+		Enabled:  enabled,
+	}, true
+}
+
+func scheduleErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, schedules.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, schedules.ErrForbidden):
+		return http.StatusForbidden
+	case errors.Is(err, schedules.ErrStillRunning):
+		return http.StatusConflict
+	case errors.Is(err, executor.ErrShuttingDown):
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusBadRequest
+	}
+}
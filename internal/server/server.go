@@ -3,7 +3,10 @@ package server
 import (
 	"context"
 	"fmt"
+	"net"
 	"net/http"
+	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -11,11 +14,68 @@ import (
 
 	"github.com/LeHTVy/h_ai/internal/ai"
 	"github.com/LeHTVy/h_ai/internal/cache"
+	"github.com/LeHTVy/h_ai/internal/cluster"
 	"github.com/LeHTVy/h_ai/internal/executor"
+	"github.com/LeHTVy/h_ai/internal/history"
 	"github.com/LeHTVy/h_ai/internal/intelligence"
+	"github.com/LeHTVy/h_ai/internal/jobs"
+	"github.com/LeHTVy/h_ai/internal/policy"
+	"github.com/LeHTVy/h_ai/internal/procstats"
+	"github.com/LeHTVy/h_ai/internal/schedules"
 	"github.com/LeHTVy/h_ai/internal/tools"
 )
 
+// Config holds the settings the server is started with
+type Config struct {
+	Host        string
+	Port        int
+	OllamaURL   string
+	OllamaModel string
+	// DataDir holds output artifacts, the execution history and other state written by the server
+	DataDir string
+	// MaxOutputBytes bounds how much of each output stream is returned inline
+	MaxOutputBytes int
+	// Scheduler holds the execution concurrency limits
+	Scheduler executor.SchedulerConfig
+	// Limits holds the per-tool resource limits of spawned processes
+	Limits executor.LimitsConfig
+	// Policy restricts what may be executed, nil allows everything
+	Policy *policy.Engine
+	// Sandbox runs tools in Linux namespaces, nil runs them unconfined
+	Sandbox *executor.SandboxConfig
+	// ClusterToken authenticates worker nodes, empty disables their registration
+	ClusterToken string
+	// ExecMode records tool executions to, or replays them from, fixtures in FixtureDir.
+	// FixtureDir defaults to the fixtures directory under DataDir.
+	ExecMode   executor.ExecMode
+	FixtureDir string
+	// ProcessRetention is how long finished processes stay in the process list
+	ProcessRetention time.Duration
+	// MaxSessions bounds the interactive sessions running at once, 0 means unlimited
+	MaxSessions int
+	// CacheTTLs says how long the results of each tool stay cached
+	CacheTTLs cache.TTLPolicy
+	// CacheBackend is where results are cached: "memory", or "bolt" to keep them in
+	// the data directory across restarts
+	CacheBackend string
+	// CacheMaxBytes bounds the size of the cached results, the least recently used are
+	// evicted beyond it. 0 means unlimited.
+	CacheMaxBytes int64
+}
+
+// shutdownCleanupTimeout bounds the work left once the grace period is over
+const shutdownCleanupTimeout = 15 * time.Second
+
+// ShutdownSummary describes what a shutdown had to interrupt
+type ShutdownSummary struct {
+	// Drained is true when every execution finished within the grace period
+	Drained             bool                   `json:"drained"`
+	InterruptedJobs     []string               `json:"interrupted_jobs,omitempty"`
+	TerminatedProcesses []executor.ProcessInfo `json:"terminated_processes,omitempty"`
+	// InterruptedTasks are the worker tasks that were cancelled
+	InterruptedTasks []string `json:"interrupted_tasks,omitempty"`
+}
+
 type Server struct {
 	host     string
 	port     int
@@ -25,25 +85,43 @@ type Server struct {
 	executor *executor.Executor
 	cache    *cache.Cache
 	tools    *tools.Manager
+	jobs     *jobs.Manager
+	history  *history.Store
+	dataDir  string
 	engine   *intelligence.IntelligentDecisionEngine
+
+	schedules *schedules.Manager
+	startTime time.Time
+	hostStats *procstats.HostSampler
+
+	cluster      *cluster.Coordinator
+	clusterToken string
 }
 
-func New(host string, port int, logger *zap.Logger, ollamaURL string, ollamaModel string) *Server {
+func New(cfg Config, logger *zap.Logger) *Server {
 	gin.SetMode(gin.ReleaseMode)
 	router := gin.New()
-	router.Use(ginLogger(logger), gin.Recovery())
+	router.Use(ginLogger(logger), gin.Recovery(), callerMiddleware(cfg.Policy))
 
-	cache := cache.New(30 * time.Minute, 10*time.Minute)
-	exec := executor.New(logger, cache)
-	toolsMgr := tools.New(logger, exec)
+	exec, historyStore, cache := newExecutor(cfg, logger)
+	toolsMgr := tools.New(logger, exec, cfg.CacheTTLs)
+	jobsMgr := jobs.New(logger, exec)
+	if cfg.DataDir != "" {
+		restored, err := jobsMgr.Restore(filepath.Join(cfg.DataDir, "jobs.json"))
+		if err != nil {
+			logger.Warn("Failed to restore jobs", zap.Error(err))
+		} else if restored > 0 {
+			logger.Info("Restored jobs from checkpoint", zap.Int("jobs", restored))
+		}
+	}
 	
 	// Initialize Ollama client (always try to connect, model can be set later via UI)
 	// If ollamaURL is empty, use default localhost
 	// If ollamaModel is empty, will auto-select first available model
-	ollamaClient := ai.NewOllamaClient(ollamaURL, ollamaModel, logger)
+	ollamaClient := ai.NewOllamaClient(cfg.OllamaURL, cfg.OllamaModel, logger)
 	
 	// If no model was specified and Ollama is available, try to auto-select first model
-	if ollamaModel == "" && ollamaClient.IsEnabled() {
+	if cfg.OllamaModel == "" && ollamaClient.IsEnabled() {
 		models, err := ollamaClient.ListModels()
 		if err != nil {
 			logger.Warn("Failed to list Ollama models during startup, will use default",
@@ -63,20 +141,110 @@ func New(host string, port int, logger *zap.Logger, ollamaURL string, ollamaMode
 	decisionEngine := intelligence.NewDecisionEngine(logger, ollamaClient)
 
 	srv := &Server{
-		host:     host,
-		port:     port,
+		host:     cfg.Host,
+		port:     cfg.Port,
 		logger:   logger,
 		router:   router,
 		executor: exec,
 		cache:    cache,
 		tools:    toolsMgr,
+		jobs:     jobsMgr,
+		history:  historyStore,
+		dataDir:  cfg.DataDir,
 		engine:   decisionEngine,
+
+		startTime: time.Now(),
+		hostStats: procstats.NewHostSampler(),
+
+		cluster:      cluster.NewCoordinator(logger),
+		clusterToken: cfg.ClusterToken,
+	}
+	srv.cluster.Start()
+	if cfg.ClusterToken == "" {
+		logger.Info("No cluster token configured, worker nodes cannot register")
+	}
+
+	schedulesPath := ""
+	if cfg.DataDir != "" {
+		schedulesPath = filepath.Join(cfg.DataDir, "schedules.json")
+	}
+	scheduleMgr, err := schedules.New(logger, schedulesPath, scheduleRunner{srv})
+	if err != nil {
+		logger.Error("Failed to load schedules, starting without them", zap.Error(err))
+		scheduleMgr, _ = schedules.New(logger, "", scheduleRunner{srv})
 	}
+	srv.schedules = scheduleMgr
+	srv.schedules.Start()
 
 	srv.setupRoutes()
+	srv.httpSrv = &http.Server{
+		Addr:    fmt.Sprintf("%s:%d", cfg.Host, cfg.Port),
+		Handler: router,
+	}
 	return srv
 }
 
+// newExecutor creates the executor with its cache and execution history, nil when
+// there is no data directory or it fails to open
+func newExecutor(cfg Config, logger *zap.Logger) (*executor.Executor, *history.Store, *cache.Cache) {
+	defaultTTL := cfg.CacheTTLs.Default
+	if defaultTTL <= 0 {
+		defaultTTL = 30 * time.Minute
+	}
+	cache := cache.New(newCacheBackend(cfg, logger), cfg.CacheMaxBytes, defaultTTL, 10*time.Minute)
+	execCfg := executor.DefaultConfig()
+	execCfg.MaxOutputBytes = cfg.MaxOutputBytes
+	execCfg.Scheduler = cfg.Scheduler
+	execCfg.Limits = cfg.Limits
+	execCfg.Policy = cfg.Policy
+	execCfg.Sandbox = cfg.Sandbox
+	execCfg.Mode = cfg.ExecMode
+	execCfg.ProcessRetention = cfg.ProcessRetention
+	execCfg.MaxSessions = cfg.MaxSessions
+	execCfg.FixtureDir = cfg.FixtureDir
+	if execCfg.FixtureDir == "" {
+		execCfg.FixtureDir = filepath.Join(cfg.DataDir, "fixtures")
+	}
+	if cfg.Policy == nil {
+		logger.Warn("No execution policy configured, /api/command accepts any shell command")
+	}
+	var historyStore *history.Store
+	if cfg.DataDir != "" {
+		execCfg.ArtifactDir = filepath.Join(cfg.DataDir, "artifacts")
+
+		store, err := history.Open(filepath.Join(cfg.DataDir, "history.db"))
+		if err != nil {
+			logger.Error("Execution history disabled", zap.Error(err))
+		} else {
+			historyStore = store
+			execCfg.History = store
+		}
+	}
+	return executor.New(logger, cache, execCfg), historyStore, cache
+}
+
+// newCacheBackend opens the configured cache backend, falling back to memory when the
+// persistent one cannot be used
+func newCacheBackend(cfg Config, logger *zap.Logger) cache.Backend {
+	switch cfg.CacheBackend {
+	case "", "memory":
+	case "bolt":
+		if cfg.DataDir == "" {
+			logger.Warn("Persistent cache needs a data directory, caching in memory")
+			break
+		}
+		backend, err := cache.OpenBolt(filepath.Join(cfg.DataDir, "cache.db"))
+		if err != nil {
+			logger.Error("Persistent cache disabled, caching in memory", zap.Error(err))
+			break
+		}
+		return backend
+	default:
+		logger.Warn("Unknown cache backend, caching in memory", zap.String("backend", cfg.CacheBackend))
+	}
+	return cache.NewMemory()
+}
+
 func (s *Server) setupRoutes() {
 	// Health check
 	s.router.GET("/health", s.handleHealth)
@@ -130,7 +298,80 @@ func (s *Server) setupRoutes() {
 			process.GET("/list", s.handleProcessList)
 			process.GET("/status/:pid", s.handleProcessStatus)
 			process.POST("/terminate/:pid", s.handleProcessTerminate)
+			process.POST("/pause/:pid", s.handleProcessPause)
+			process.POST("/resume/:pid", s.handleProcessResume)
 			process.GET("/dashboard", s.handleProcessDashboard)
+			process.GET("/stream/:pid", s.handleProcessStream)
+			process.GET("/ws/:pid", s.handleProcessWebSocket)
+		}
+
+		// Asynchronous jobs
+		jobs := api.Group("/jobs")
+		{
+			jobs.GET("", s.handleJobList)
+			jobs.GET("/:id", s.handleJobStatus)
+			jobs.DELETE("/:id", s.handleJobCancel)
+			jobs.GET("/:id/stream", s.handleJobStream)
+			jobs.GET("/:id/ws", s.handleJobWebSocket)
+			jobs.GET("/:id/recordings", s.handleJobRecordings)
+			jobs.GET("/:id/recording", s.handleJobRecording)
+		}
+
+		// Interactive terminal sessions
+		sessions := api.Group("/sessions")
+		{
+			sessions.POST("", s.handleSessionOpen)
+			sessions.GET("", s.handleSessionList)
+			sessions.GET("/:id", s.handleSessionStatus)
+			sessions.DELETE("/:id", s.handleSessionClose)
+			sessions.POST("/:id/input", s.handleSessionInput)
+			sessions.POST("/:id/resize", s.handleSessionResize)
+			sessions.GET("/:id/output", s.handleSessionOutput)
+			sessions.GET("/:id/ws", s.handleSessionWebSocket)
+		}
+
+		// Scheduled tool requests
+		schedulesGroup := api.Group("/schedules")
+		{
+			schedulesGroup.GET("", s.handleScheduleList)
+			schedulesGroup.POST("", s.handleScheduleCreate)
+			schedulesGroup.GET("/:id", s.handleScheduleGet)
+			schedulesGroup.PUT("/:id", s.handleScheduleUpdate)
+			schedulesGroup.DELETE("/:id", s.handleScheduleDelete)
+			schedulesGroup.POST("/:id/enable", s.handleScheduleEnable)
+			schedulesGroup.POST("/:id/disable", s.handleScheduleDisable)
+			schedulesGroup.POST("/:id/run", s.handleScheduleRun)
+		}
+
+		// Distributed worker nodes
+		clusterGroup := api.Group("/cluster")
+		{
+			clusterGroup.GET("/workers", s.handleWorkerList)
+			clusterGroup.POST("/dispatch", s.handleClusterDispatch)
+			clusterGroup.GET("/tasks", s.handleClusterTaskList)
+			clusterGroup.GET("/tasks/:id", s.handleClusterTask)
+
+			// Called by the worker nodes themselves
+			workers := clusterGroup.Group("/workers", s.clusterAuth())
+			workers.POST("", s.handleWorkerRegister)
+			workers.DELETE("/:id", s.handleWorkerLeave)
+			workers.POST("/:id/heartbeat", s.handleWorkerHeartbeat)
+			workers.GET("/:id/next", s.handleWorkerNext)
+			workers.POST("/:id/tasks/:task", s.handleWorkerTaskResult)
+		}
+
+		// Output artifacts
+		artifacts := api.Group("/artifacts")
+		{
+			artifacts.GET("/:id", s.handleArtifactList)
+			artifacts.GET("/:id/*name", s.handleArtifactDownload)
+		}
+
+		// Execution history
+		historyGroup := api.Group("/history")
+		{
+			historyGroup.GET("", s.handleHistoryList)
+			historyGroup.GET("/:id", s.handleHistoryGet)
 		}
 
 		// Cache endpoints
@@ -145,12 +386,7 @@ func (s *Server) setupRoutes() {
 }
 
 func (s *Server) Start() error {
-	addr := fmt.Sprintf("%s:%d", s.host, s.port)
-	s.httpSrv = &http.Server{
-		Addr:    addr,
-		Handler: s.router,
-	}
-
+	addr := s.httpSrv.Addr
 	s.logger.Info("Starting HTTP server", zap.String("address", addr))
 	if err := s.httpSrv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 		return fmt.Errorf("server failed: %w", err)
@@ -158,8 +394,102 @@ func (s *Server) Start() error {
 	return nil
 }
 
-func (s *Server) Shutdown(ctx context.Context) error {
-	return s.httpSrv.Shutdown(ctx)
+// Shutdown stops accepting requests and jobs, then gives running executions until
+// ctx is done to finish. Whatever is still running after that is interrupted and
+// terminated. Jobs are checkpointed to the data directory and the history is closed.
+func (s *Server) Shutdown(ctx context.Context) (ShutdownSummary, error) {
+	var summary ShutdownSummary
+
+	s.schedules.Close()
+	s.jobs.Close()
+	// Workers cannot report back once the listener is closed, so their tasks end here
+	summary.InterruptedTasks = s.cluster.Close()
+	httpDone := make(chan error, 1)
+	go func() {
+		// Waits for in-flight requests, which return once their processes are gone
+		httpDone <- s.httpSrv.Shutdown(context.Background())
+	}()
+
+	s.logger.Info("Draining running executions")
+	summary.Drained = s.executor.Drain(ctx)
+	// Interactive sessions have no natural end to wait for
+	s.executor.CloseSessions()
+
+	cleanupCtx, cancel := context.WithTimeout(context.Background(), shutdownCleanupTimeout)
+	defer cancel()
+
+	if !summary.Drained {
+		s.logger.Warn("Grace period over, interrupting remaining executions")
+		summary.InterruptedJobs = s.jobs.Interrupt()
+		summary.TerminatedProcesses = s.executor.TerminateAll(cleanupCtx)
+	}
+	if !s.jobs.Wait(cleanupCtx) {
+		s.logger.Warn("Some jobs did not finish before the checkpoint")
+	}
+
+	var err error
+	if s.dataDir != "" {
+		if checkpointErr := s.jobs.Checkpoint(filepath.Join(s.dataDir, "jobs.json")); checkpointErr != nil {
+			s.logger.Error("Failed to checkpoint jobs", zap.Error(checkpointErr))
+			err = checkpointErr
+		}
+	}
+
+	select {
+	case httpErr := <-httpDone:
+		if httpErr != nil && err == nil {
+			err = httpErr
+		}
+	case <-cleanupCtx.Done():
+		s.logger.Warn("Closing remaining connections")
+		s.httpSrv.Close()
+	}
+
+	if s.history != nil {
+		if closeErr := s.history.Close(); closeErr != nil {
+			s.logger.Warn("Failed to close execution history", zap.Error(closeErr))
+		}
+	}
+	if closeErr := s.cache.Close(); closeErr != nil {
+		s.logger.Warn("Failed to close result cache", zap.Error(closeErr))
+	}
+	return summary, err
+}
+
+// callerMiddleware tags the request context with the caller: the requester, taken
+// from the X-Requester header or the client IP, and the policy role. The role comes
+// from the caller's API token, or from X-Role when the request is sent by a trusted
+// proxy; anyone else gets the default role.
+func callerMiddleware(engine *policy.Engine) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requester := c.GetHeader("X-Requester")
+		if requester == "" {
+			requester = c.ClientIP()
+		}
+
+		var role string
+		if engine != nil {
+			// Unknown tokens get the default role, worker nodes send the cluster token
+			// in the same header
+			token := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+			if tokenRole, ok := engine.TokenRole(token); ok {
+				role = tokenRole
+			} else if engine.TrustsProxy(net.ParseIP(c.RemoteIP())) {
+				role = c.GetHeader("X-Role")
+			}
+		}
+
+		ctx := executor.WithRequester(c.Request.Context(), requester)
+		ctx = policy.WithRole(ctx, role)
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+	}
+}
+
+// withCaller copies the caller attached by callerMiddleware from src to ctx
+func withCaller(ctx, src context.Context) context.Context {
+	ctx = executor.WithRequester(ctx, executor.RequesterFromContext(src))
+	return policy.WithRole(ctx, policy.RoleFromContext(src))
 }
 
 func ginLogger(logger *zap.Logger) gin.HandlerFunc {
@@ -0,0 +1,266 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"go.uber.org/zap"
+
+	"github.com/LeHTVy/h_ai/internal/executor"
+	"github.com/LeHTVy/h_ai/internal/models"
+	"github.com/LeHTVy/h_ai/internal/policy"
+)
+
+// sessionMessage is a client frame on a session WebSocket
+type sessionMessage struct {
+	Type string `json:"type"` // "input" or "resize"
+	Data string `json:"data,omitempty"`
+	Cols uint16 `json:"cols,omitempty"`
+	Rows uint16 `json:"rows,omitempty"`
+}
+
+// handleSessionOpen starts an interactive terminal session
+func (s *Server) handleSessionOpen(c *gin.Context) {
+	var req models.SessionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Command == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "command is required"})
+		return
+	}
+
+	info, err := s.tools.OpenSession(c.Request.Context(), req)
+	if err != nil {
+		var violation *policy.Violation
+		if errors.As(err, &violation) {
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error(), "policy_violation": violation.Rule})
+			return
+		}
+		if errors.Is(err, executor.ErrTooManySessions) {
+			c.Header("Retry-After", "30")
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"success": true,
+		"session": info,
+		"ws_url":  "/api/sessions/" + info.ID + "/ws",
+	})
+}
+
+// handleSessionList lists open and recently closed sessions
+func (s *Server) handleSessionList(c *gin.Context) {
+	sessions := s.executor.ListSessions()
+	c.JSON(http.StatusOK, gin.H{
+		"sessions": sessions,
+		"count":    len(sessions),
+	})
+}
+
+// handleSessionStatus returns the state of a session
+func (s *Server) handleSessionStatus(c *gin.Context) {
+	session, ok := s.lookupSession(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, session.Info())
+}
+
+// handleSessionInput sends input to a session
+func (s *Server) handleSessionInput(c *gin.Context) {
+	session, ok := s.lookupSession(c)
+	if !ok {
+		return
+	}
+
+	var req models.SessionInputRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := session.Write([]byte(req.Data)); err != nil {
+		s.sessionError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
+
+// handleSessionResize changes the terminal size of a session
+func (s *Server) handleSessionResize(c *gin.Context) {
+	session, ok := s.lookupSession(c)
+	if !ok {
+		return
+	}
+
+	var req models.SessionResizeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := session.Resize(req.Cols, req.Rows); err != nil {
+		s.sessionError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
+
+// handleSessionOutput returns the output produced since the offset query parameter,
+// for clients that poll instead of holding a WebSocket open
+func (s *Server) handleSessionOutput(c *gin.Context) {
+	session, ok := s.lookupSession(c)
+	if !ok {
+		return
+	}
+
+	var offset int64
+	if value := c.Query("offset"); value != "" {
+		var err error
+		if offset, err = strconv.ParseInt(value, 10, 64); err != nil || offset < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid offset"})
+			return
+		}
+	}
+
+	data, next, skipped := session.Read(offset)
+	info := session.Info()
+	c.JSON(http.StatusOK, gin.H{
+		"data":        string(data),
+		"next_offset": next,
+		"skipped":     skipped,
+		"status":      info.Status,
+		"exit_code":   info.ExitCode,
+	})
+}
+
+// handleSessionClose terminates a session
+func (s *Server) handleSessionClose(c *gin.Context) {
+	session, ok := s.lookupSession(c)
+	if !ok {
+		return
+	}
+
+	session.Close()
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"session": session.Info(),
+	})
+}
+
+// handleSessionWebSocket attaches a client to a session. The scrollback is replayed,
+// then output is forwarded live while input and resize frames are applied.
+func (s *Server) handleSessionWebSocket(c *gin.Context) {
+	session, ok := s.lookupSession(c)
+	if !ok {
+		return
+	}
+
+	conn, err := wsUpgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		s.logger.Debug("WebSocket upgrade failed", zap.Error(err))
+		return
+	}
+	defer conn.Close()
+
+	scrollback, chunks, cancel := session.Attach()
+	defer cancel()
+
+	// Only this goroutine writes to the connection, the reader reports errors here
+	errs := make(chan string, 16)
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			var msg sessionMessage
+			if err := conn.ReadJSON(&msg); err != nil {
+				if websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+					s.logger.Debug("Session WebSocket closed", zap.Error(err))
+				}
+				return
+			}
+
+			var opErr error
+			switch msg.Type {
+			case "input":
+				opErr = session.Write([]byte(msg.Data))
+			case "resize":
+				opErr = session.Resize(msg.Cols, msg.Rows)
+			default:
+				opErr = errors.New("unknown message type " + strconv.Quote(msg.Type))
+			}
+			if opErr != nil {
+				select {
+				case errs <- opErr.Error():
+				default:
+				}
+			}
+		}
+	}()
+
+	if len(scrollback) > 0 {
+		if err := conn.WriteJSON(gin.H{"event": "output", "data": executor.OutputChunk{Stream: "pty", Data: string(scrollback), Timestamp: time.Now()}}); err != nil {
+			return
+		}
+	}
+
+	for {
+		select {
+		case <-closed:
+			return
+		case msg := <-errs:
+			if err := conn.WriteJSON(gin.H{"event": "error", "data": msg}); err != nil {
+				return
+			}
+		case chunk, ok := <-chunks:
+			if !ok {
+				// The stream ends just before the exit status is recorded
+				select {
+				case <-session.Done():
+				case <-closed:
+					return
+				}
+				info := session.Info()
+				conn.WriteJSON(gin.H{"event": "end", "data": gin.H{"session_id": info.ID, "status": info.Status, "exit_code": info.ExitCode}})
+				conn.WriteMessage(websocket.CloseMessage,
+					websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+				return
+			}
+			if err := conn.WriteJSON(gin.H{"event": "output", "data": chunk}); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (s *Server) lookupSession(c *gin.Context) (*executor.Session, bool) {
+	session, found := s.executor.Session(c.Param("id"))
+	if !found {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Session not found"})
+		return nil, false
+	}
+	return session, true
+}
+
+func (s *Server) sessionError(c *gin.Context, err error) {
+	if errors.Is(err, executor.ErrSessionClosed) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	var violation *policy.Violation
+	if errors.As(err, &violation) {
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error(), "policy_violation": violation.Rule})
+		return
+	}
+	c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+}
@@ -0,0 +1,186 @@
+package server
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"go.uber.org/zap"
+
+	"github.com/LeHTVy/h_ai/internal/executor"
+)
+
+var wsUpgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 4096,
+	// The API has no browser sessions to protect, accept any origin like the rest of the API
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+// jobStreamWait is how long a job stream request waits for a pending job to spawn its process
+const jobStreamWait = 30 * time.Second
+
+// handleProcessStream streams a process' output as Server-Sent Events
+func (s *Server) handleProcessStream(c *gin.Context) {
+	stream, ok := s.lookupProcessStream(c)
+	if !ok {
+		return
+	}
+	s.serveSSE(c, stream)
+}
+
+// handleProcessWebSocket streams a process' output over a WebSocket
+func (s *Server) handleProcessWebSocket(c *gin.Context) {
+	stream, ok := s.lookupProcessStream(c)
+	if !ok {
+		return
+	}
+	s.serveWebSocket(c, stream)
+}
+
+// handleJobStream streams a job's output as Server-Sent Events
+func (s *Server) handleJobStream(c *gin.Context) {
+	stream, ok := s.lookupJobStream(c)
+	if !ok {
+		return
+	}
+	s.serveSSE(c, stream)
+}
+
+// handleJobWebSocket streams a job's output over a WebSocket
+func (s *Server) handleJobWebSocket(c *gin.Context) {
+	stream, ok := s.lookupJobStream(c)
+	if !ok {
+		return
+	}
+	s.serveWebSocket(c, stream)
+}
+
+func (s *Server) lookupProcessStream(c *gin.Context) (*executor.OutputStream, bool) {
+	pid, err := strconv.Atoi(c.Param("pid"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid PID"})
+		return nil, false
+	}
+
+	stream, found := s.executor.ProcessStream(pid)
+	if !found {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No output stream for process"})
+		return nil, false
+	}
+	return stream, true
+}
+
+// lookupJobStream resolves the stream of a job, waiting for a pending job to start its process
+func (s *Server) lookupJobStream(c *gin.Context) (*executor.OutputStream, bool) {
+	id := c.Param("id")
+	ctx, cancel := context.WithTimeout(c.Request.Context(), jobStreamWait)
+	defer cancel()
+
+	ticker := time.NewTicker(250 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		job, found := s.jobs.Get(id)
+		if !found {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+			return nil, false
+		}
+
+		if stream, found := s.executor.JobStream(id); found {
+			return stream, true
+		}
+
+		if job.Finished() {
+			c.JSON(http.StatusNotFound, gin.H{"error": "No output stream for job", "status": job.Status})
+			return nil, false
+		}
+
+		select {
+		case <-ctx.Done():
+			c.JSON(http.StatusNotFound, gin.H{"error": "Job has not started a process yet", "status": job.Status})
+			return nil, false
+		case <-ticker.C:
+		}
+	}
+}
+
+// serveSSE replays the buffered output and then forwards live chunks until the process exits
+func (s *Server) serveSSE(c *gin.Context, stream *executor.OutputStream) {
+	replay, chunks, cancel := stream.Subscribe()
+	defer cancel()
+
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+
+	for _, chunk := range replay {
+		c.SSEvent("output", chunk)
+	}
+	c.Writer.Flush()
+
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case <-c.Request.Context().Done():
+			return false
+		case chunk, ok := <-chunks:
+			if !ok {
+				c.SSEvent("end", gin.H{"pid": stream.PID, "job_id": stream.JobID, "completed": stream.Closed()})
+				return false
+			}
+			c.SSEvent("output", chunk)
+			return true
+		}
+	})
+}
+
+// serveWebSocket replays the buffered output and then forwards live chunks as JSON messages
+func (s *Server) serveWebSocket(c *gin.Context, stream *executor.OutputStream) {
+	conn, err := wsUpgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		s.logger.Debug("WebSocket upgrade failed", zap.Error(err))
+		return
+	}
+	defer conn.Close()
+
+	replay, chunks, cancel := stream.Subscribe()
+	defer cancel()
+
+	// Drain client frames so close messages are noticed
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	for _, chunk := range replay {
+		if err := conn.WriteJSON(gin.H{"event": "output", "data": chunk}); err != nil {
+			return
+		}
+	}
+
+	for {
+		select {
+		case <-closed:
+			return
+		case chunk, ok := <-chunks:
+			if !ok {
+				conn.WriteJSON(gin.H{"event": "end", "data": gin.H{"pid": stream.PID, "job_id": stream.JobID, "completed": stream.Closed()}})
+				conn.WriteMessage(websocket.CloseMessage,
+					websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+				return
+			}
+			if err := conn.WriteJSON(gin.H{"event": "output", "data": chunk}); err != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package server
+
+import (
+	"context"
+
+	"go.uber.org/zap"
+
+	"github.com/LeHTVy/h_ai/internal/cache"
+	"github.com/LeHTVy/h_ai/internal/cluster"
+	"github.com/LeHTVy/h_ai/internal/executor"
+	"github.com/LeHTVy/h_ai/internal/history"
+	"github.com/LeHTVy/h_ai/internal/tools"
+)
+
+// Worker runs tool requests pulled from a coordinator instead of serving the API. It
+// executes them with the same limits, policy, sandbox and history as the server would.
+type Worker struct {
+	logger   *zap.Logger
+	executor *executor.Executor
+	history  *history.Store
+	cache    *cache.Cache
+	agent    *cluster.Agent
+}
+
+// NewWorker creates a worker node; the API settings of cfg are ignored
+func NewWorker(cfg Config, agentCfg cluster.AgentConfig, logger *zap.Logger) *Worker {
+	exec, historyStore, resultCache := newExecutor(cfg, logger)
+	return &Worker{
+		logger:   logger,
+		executor: exec,
+		history:  historyStore,
+		cache:    resultCache,
+		agent:    cluster.NewAgent(logger, agentCfg, tools.New(logger, exec, cfg.CacheTTLs)),
+	}
+}
+
+// Run pulls tasks from the coordinator until ctx is done
+func (w *Worker) Run(ctx context.Context) {
+	w.agent.Run(ctx)
+}
+
+// Shutdown gives running tasks until ctx is done to finish, cancels the rest and
+// leaves the coordinator
+func (w *Worker) Shutdown(ctx context.Context) (ShutdownSummary, error) {
+	var summary ShutdownSummary
+
+	summary.InterruptedTasks = w.agent.Shutdown(ctx)
+	cleanupCtx, cancel := context.WithTimeout(context.Background(), shutdownCleanupTimeout)
+	defer cancel()
+	summary.Drained = w.executor.Drain(cleanupCtx) && len(summary.InterruptedTasks) == 0
+	summary.TerminatedProcesses = w.executor.TerminateAll(cleanupCtx)
+
+	if w.history != nil {
+		if err := w.history.Close(); err != nil {
+			w.logger.Warn("Failed to close execution history", zap.Error(err))
+		}
+	}
+	if err := w.cache.Close(); err != nil {
+		w.logger.Warn("Failed to close result cache", zap.Error(err))
+	}
+	return summary, nil
+}
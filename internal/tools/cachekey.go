@@ -0,0 +1,182 @@
+package tools
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/LeHTVy/h_ai/internal/cache"
+	"github.com/LeHTVy/h_ai/internal/executor"
+)
+
+// rawCommandTool is the cache namespace of raw commands, they never share results with
+// tool requests
+const rawCommandTool = "command"
+
+// cacheKey builds the key the result of spec is cached under
+func (m *Manager) cacheKey(spec executor.CommandSpec) cache.Key {
+	if spec.Raw != "" {
+		// The shell decides what a raw command line means, only the exact line matches
+		return cache.Key{Tool: rawCommandTool, Params: []string{spec.Raw}}
+	}
+
+	params := normalizeArgs(spec.ToolName(), spec.Args, spec.Target)
+	if spec.Stdin != "" {
+		params = append(params, "stdin="+spec.Stdin)
+	}
+	env := append([]string(nil), spec.Env...)
+	sort.Strings(env)
+	for _, kv := range env {
+		params = append(params, "env="+kv)
+	}
+
+	return cache.Key{
+		Tool:    spec.ToolName(),
+		Version: binaryVersion(spec.Binary),
+		Target:  spec.Target,
+		Params:  params,
+	}
+}
+
+// cacheTTL returns how long the result of spec stays cached
+func (m *Manager) cacheTTL(spec executor.CommandSpec) time.Duration {
+	if spec.Raw != "" {
+		return m.cacheTTLs.For(rawCommandTool)
+	}
+	return m.cacheTTLs.For(spec.ToolName())
+}
+
+// flagAliases maps the long spelling of a tool's options to the short one, so requests
+// that only spell an option differently share a result
+var flagAliases = map[string]map[string]string{
+	"gobuster": {
+		"--url":          "-u",
+		"--wordlist":     "-w",
+		"--threads":      "-t",
+		"--extensions":   "-x",
+		"--status-codes": "-s",
+		"--output":       "-o",
+		"--quiet":        "-q",
+	},
+	"nuclei": {
+		"-target":     "-u",
+		"--target":    "-u",
+		"-templates":  "-t",
+		"--templates": "-t",
+		"-severity":   "-s",
+		"--severity":  "-s",
+		"-output":     "-o",
+		"--output":    "-o",
+	},
+	"sqlmap": {
+		"--url": "-u",
+	},
+	"masscan": {
+		"--ports": "-p",
+	},
+	"msfvenom": {
+		"--payload": "-p",
+		"--format":  "-f",
+		"--arch":    "-a",
+		"--encoder": "-e",
+		"--out":     "-o",
+	},
+	"nxc": {
+		"--username": "-u",
+		"--password": "-p",
+	},
+}
+
+// normalizeArgs makes argument lists that only differ in the order or spelling of their
+// options compare equal. Whitespace around arguments is dropped and known long aliases,
+// including the "--option=value" form, are replaced by the short option. Each option is
+// grouped with the values following it and the groups are sorted, so "-p 80" stays one
+// unit. Positional arguments, like a subcommand before the options or the target, keep
+// their slot.
+func normalizeArgs(tool string, args []string, target string) []string {
+	aliases := flagAliases[tool]
+	var tokens []string
+	for _, arg := range args {
+		arg = strings.TrimSpace(arg)
+		if short, found := aliases[arg]; found {
+			tokens = append(tokens, short)
+			continue
+		}
+		if name, value, found := strings.Cut(arg, "="); found && strings.HasPrefix(name, "-") {
+			if short, found := aliases[name]; found {
+				tokens = append(tokens, short, value)
+				continue
+			}
+		}
+		tokens = append(tokens, arg)
+	}
+
+	// positional marks the arguments that keep their slot
+	positional := make([]bool, len(tokens))
+	var groups [][]string
+	inGroup, endOfOptions := false, false
+	for i, token := range tokens {
+		switch {
+		case endOfOptions:
+			positional[i] = true
+		case token == "--":
+			positional[i] = true
+			endOfOptions = true
+		case isOption(token):
+			groups = append(groups, []string{token})
+			inGroup = true
+		// The target is the value of an option right before it, like "-u <target>",
+		// and a positional argument otherwise
+		case !inGroup || (target != "" && token == target && !isOption(tokens[i-1])):
+			positional[i] = true
+			inGroup = false
+		default:
+			groups[len(groups)-1] = append(groups[len(groups)-1], token)
+		}
+	}
+
+	joined := make([]string, len(groups))
+	for i, group := range groups {
+		joined[i] = strings.Join(group, "\x00")
+	}
+	sort.Strings(joined)
+	var options []string
+	for _, group := range joined {
+		options = append(options, strings.Split(group, "\x00")...)
+	}
+
+	normalized := make([]string, len(tokens))
+	for i, token := range tokens {
+		if positional[i] {
+			normalized[i] = token
+		} else {
+			normalized[i], options = options[0], options[1:]
+		}
+	}
+	return normalized
+}
+
+// isOption reports whether arg is an option rather than a value
+func isOption(arg string) bool {
+	return strings.HasPrefix(arg, "-") && len(arg) > 1
+}
+
+// binaryVersion fingerprints the installed binary by path, size and modification time,
+// which changes whenever the tool is upgraded without having to run it
+func binaryVersion(binary string) string {
+	path, err := exec.LookPath(binary)
+	if err != nil {
+		return ""
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s:%d:%d", path, info.Size(), info.ModTime().UnixNano())))
+	return hex.EncodeToString(sum[:6])
+}
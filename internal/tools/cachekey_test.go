@@ -0,0 +1,184 @@
+package tools
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/LeHTVy/h_ai/internal/executor"
+)
+
+func TestNormalizeArgs(t *testing.T) {
+	tests := []struct {
+		name   string
+		tool   string
+		args   []string
+		target string
+		want   []string
+	}{
+		{
+			name:   "options are sorted, the target keeps its slot",
+			tool:   "nmap",
+			args:   []string{"-sV", "-p", "80", "example.com"},
+			target: "example.com",
+			want:   []string{"-p", "80", "-sV", "example.com"},
+		},
+		{
+			name:   "values stay with their option",
+			tool:   "nmap",
+			args:   []string{"-p", "-sV", "80", "example.com"},
+			target: "example.com",
+			want:   []string{"-p", "-sV", "80", "example.com"},
+		},
+		{
+			name:   "subcommand keeps its slot",
+			tool:   "gobuster",
+			args:   []string{"dir", "-w", "list.txt", "-u", "http://x"},
+			target: "http://x",
+			want:   []string{"dir", "-u", "http://x", "-w", "list.txt"},
+		},
+		{
+			name:   "positional target between options",
+			tool:   "masscan",
+			args:   []string{"-p", "80", "--rate", "1000", "10.0.0.1", "--wait", "0"},
+			target: "10.0.0.1",
+			want:   []string{"--rate", "1000", "--wait", "0", "10.0.0.1", "-p", "80"},
+		},
+		{
+			name:   "positionals after the target",
+			tool:   "hydra",
+			args:   []string{"-P", "list.txt", "-l", "admin", "10.0.0.1", "ssh", "-t", "4"},
+			target: "10.0.0.1",
+			want:   []string{"-P", "list.txt", "-l", "admin", "10.0.0.1", "ssh", "-t", "4"},
+		},
+		{
+			name: "end of options",
+			tool: "nmap",
+			args: []string{"-sV", "--", "-x", "-a"},
+			want: []string{"-sV", "--", "-x", "-a"},
+		},
+		{
+			name:   "whitespace around arguments",
+			tool:   "nmap",
+			args:   []string{" -sV", "example.com "},
+			target: "example.com",
+			want:   []string{"-sV", "example.com"},
+		},
+		{
+			name:   "long alias",
+			tool:   "gobuster",
+			args:   []string{"dir", "--url", "http://x", "--threads", "5"},
+			target: "http://x",
+			want:   []string{"dir", "-t", "5", "-u", "http://x"},
+		},
+		{
+			name:   "long alias with value",
+			tool:   "gobuster",
+			args:   []string{"dir", "--url=http://x?a=b"},
+			target: "http://x?a=b",
+			want:   []string{"dir", "-u", "http://x?a=b"},
+		},
+		{
+			name: "aliases are per tool",
+			tool: "nmap",
+			args: []string{"--url", "x"},
+			want: []string{"--url", "x"},
+		},
+		{
+			name:   "option values are kept",
+			tool:   "sqlmap",
+			args:   []string{"--url", "http://x/?id=1", "--data", "a=1&b=2"},
+			target: "http://x/?id=1",
+			want:   []string{"--data", "a=1&b=2", "-u", "http://x/?id=1"},
+		},
+		{
+			name: "unknown options with values",
+			tool: "nuclei",
+			args: []string{"-target=x", "-rl=10"},
+			want: []string{"-rl=10", "-u", "x"},
+		},
+		{
+			name: "no arguments",
+			tool: "amass",
+			args: nil,
+			want: []string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := normalizeArgs(tt.tool, tt.args, tt.target); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("normalizeArgs(%q, %q, %q) = %q, want %q", tt.tool, tt.args, tt.target, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCacheKey(t *testing.T) {
+	m := &Manager{}
+	spec := func(binary, target string, args ...string) executor.CommandSpec {
+		return executor.CommandSpec{Binary: binary, Args: args, Target: target}
+	}
+
+	tests := []struct {
+		name string
+		a, b executor.CommandSpec
+		same bool
+	}{
+		{
+			name: "aliases share a key",
+			a:    spec("gobuster", "http://x", "dir", "-u", "http://x", "-t", "5"),
+			b:    spec("gobuster", "http://x", "dir", "--url=http://x", "--threads", "5"),
+			same: true,
+		},
+		{
+			name: "option order does not matter",
+			a:    spec("nmap", "x", "-sV", "-p", "80", "x"),
+			b:    spec("nmap", "x", "-p", "80", "-sV", "x"),
+			same: true,
+		},
+		{
+			name: "values belong to their option",
+			a:    spec("nmap", "x", "-p", "80", "-sV", "x"),
+			b:    spec("nmap", "x", "-p", "-sV", "80", "x"),
+		},
+		{
+			name: "subcommands matter",
+			a:    spec("gobuster", "http://x", "dir", "-u", "http://x"),
+			b:    spec("gobuster", "http://x", "vhost", "-u", "http://x"),
+		},
+		{
+			name: "values matter",
+			a:    spec("nmap", "x", "-p", "80", "x"),
+			b:    spec("nmap", "x", "-p", "443", "x"),
+		},
+		{
+			name: "targets matter",
+			a:    spec("nmap", "x", "x"),
+			b:    spec("nmap", "y", "y"),
+		},
+		{
+			name: "stdin matters",
+			a:    executor.CommandSpec{Binary: "msfconsole", Args: []string{"-r", "-"}, Stdin: "use a"},
+			b:    executor.CommandSpec{Binary: "msfconsole", Args: []string{"-r", "-"}, Stdin: "use b"},
+		},
+		{
+			name: "raw commands only match the exact line",
+			a:    executor.CommandSpec{Binary: "sh", Args: []string{"-c", "nmap x"}, Raw: "nmap x"},
+			b:    executor.CommandSpec{Binary: "sh", Args: []string{"-c", "nmap  x"}, Raw: "nmap  x"},
+		},
+		{
+			name: "raw commands never match tool requests",
+			a:    executor.CommandSpec{Binary: "sh", Args: []string{"-c", "nmap x"}, Raw: "nmap x"},
+			b:    spec("nmap", "", "x"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, b := m.cacheKey(tt.a).String(), m.cacheKey(tt.b).String()
+			if (a == b) != tt.same {
+				t.Errorf("keys %q and %q, want same = %v", a, b, tt.same)
+			}
+		})
+	}
+}
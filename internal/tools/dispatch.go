@@ -0,0 +1,189 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/LeHTVy/h_ai/internal/executor"
+	"github.com/LeHTVy/h_ai/internal/models"
+)
+
+// Runner runs a decoded tool request
+type Runner func(ctx context.Context) map[string]interface{}
+
+// requestDecoders decode a JSON request for each tool, keyed like the /api/tools routes
+var requestDecoders = map[string]func(m *Manager, raw json.RawMessage) (Runner, error){
+	"command": func(m *Manager, raw json.RawMessage) (Runner, error) {
+		return decodeRequest(raw, m.ExecuteCommand, func(req models.CommandRequest) error {
+			return required("command", req.Command)
+		})
+	},
+	"nmap": func(m *Manager, raw json.RawMessage) (Runner, error) {
+		return decodeRequest(raw, m.ExecuteNmap, func(req models.NmapRequest) error {
+			return required("target", req.Target)
+		})
+	},
+	"nmap-advanced": func(m *Manager, raw json.RawMessage) (Runner, error) {
+		return decodeRequest(raw, m.ExecuteNmapAdvanced, func(req models.NmapAdvancedRequest) error {
+			return required("target", req.Target)
+		})
+	},
+	"metasploit": func(m *Manager, raw json.RawMessage) (Runner, error) {
+		return decodeRequest(raw, m.ExecuteMetasploit, func(req models.MetasploitRequest) error {
+			return required("module", req.Module)
+		})
+	},
+	"gobuster": func(m *Manager, raw json.RawMessage) (Runner, error) {
+		return decodeRequest[models.GobusterRequest](raw, m.ExecuteGobuster, nil)
+	},
+	"nuclei": func(m *Manager, raw json.RawMessage) (Runner, error) {
+		return decodeRequest[models.NucleiRequest](raw, m.ExecuteNuclei, nil)
+	},
+	"sqlmap": func(m *Manager, raw json.RawMessage) (Runner, error) {
+		return decodeRequest[models.SqlmapRequest](raw, m.ExecuteSqlmap, nil)
+	},
+	"hydra": func(m *Manager, raw json.RawMessage) (Runner, error) {
+		return decodeRequest[models.HydraRequest](raw, m.ExecuteHydra, nil)
+	},
+	"ffuf": func(m *Manager, raw json.RawMessage) (Runner, error) {
+		return decodeRequest[models.FFufRequest](raw, m.ExecuteFFuf, nil)
+	},
+	"netexec": func(m *Manager, raw json.RawMessage) (Runner, error) {
+		return decodeRequest[models.NetexecRequest](raw, m.ExecuteNetexec, nil)
+	},
+	"amass": func(m *Manager, raw json.RawMessage) (Runner, error) {
+		return decodeRequest[models.AmassRequest](raw, m.ExecuteAmass, nil)
+	},
+	"masscan": func(m *Manager, raw json.RawMessage) (Runner, error) {
+		return decodeRequest[models.MasscanRequest](raw, m.ExecuteMasscan, nil)
+	},
+	"autorecon": func(m *Manager, raw json.RawMessage) (Runner, error) {
+		return decodeRequest[models.AutoReconRequest](raw, m.ExecuteAutoRecon, nil)
+	},
+	"msfvenom": func(m *Manager, raw json.RawMessage) (Runner, error) {
+		return decodeRequest[models.MSFVenomRequest](raw, m.ExecuteMSFVenom, nil)
+	},
+}
+
+// requestBinaries names the binary run by each tool request, raw commands may run any
+var requestBinaries = map[string]string{
+	"command":       "",
+	"nmap":          "nmap",
+	"nmap-advanced": "nmap",
+	"metasploit":    "msfconsole",
+	"gobuster":      "gobuster",
+	"nuclei":        "nuclei",
+	"sqlmap":        "sqlmap",
+	"hydra":         "hydra",
+	"ffuf":          "ffuf",
+	"netexec":       "nxc",
+	"amass":         "amass",
+	"masscan":       "masscan",
+	"autorecon":     "autorecon",
+	"msfvenom":      "msfvenom",
+}
+
+// RequestBinary returns the binary run by requests for tool, empty for raw commands
+func RequestBinary(tool string) string {
+	return requestBinaries[tool]
+}
+
+// Prepare decodes a JSON request for tool, named like its /api/tools route or
+// "command" for raw commands, and returns a function that runs it
+func (m *Manager) Prepare(tool string, raw json.RawMessage) (Runner, error) {
+	decode, ok := requestDecoders[tool]
+	if !ok {
+		return nil, fmt.Errorf("unknown tool %q", tool)
+	}
+	return decode(m, raw)
+}
+
+// DryRun resolves the command of a tool request given as loose parameters, like the
+// steps of an attack chain, without running it
+func (m *Manager) DryRun(ctx context.Context, tool string, params map[string]interface{}) map[string]interface{} {
+	request := make(map[string]interface{}, len(params)+1)
+	for key, value := range params {
+		request[key] = value
+	}
+	request["dry_run"] = true
+
+	raw, err := json.Marshal(request)
+	if err != nil {
+		return m.errorResult(err)
+	}
+	run, err := m.Prepare(tool, raw)
+	if err != nil {
+		return m.errorResult(err)
+	}
+	return run(ctx)
+}
+
+// Authorize evaluates a tool request against the execution policy under the role of
+// ctx without running it. It returns the violated rule and why the request is denied.
+func (m *Manager) Authorize(ctx context.Context, tool string, raw json.RawMessage) (string, error) {
+	request := map[string]interface{}{}
+	if len(raw) > 0 {
+		if err := json.Unmarshal(raw, &request); err != nil {
+			return "", err
+		}
+	}
+	request["dry_run"] = true
+	planned, err := json.Marshal(request)
+	if err != nil {
+		return "", err
+	}
+
+	run, err := m.Prepare(tool, planned)
+	if err != nil {
+		return "", err
+	}
+	result := run(ctx)
+	plan, ok := result["plan"].(executor.ExecutionPlan)
+	if !ok {
+		if msg, _ := result["error"].(string); msg != "" {
+			return "", errors.New(msg)
+		}
+		return "", fmt.Errorf("could not plan %s request", tool)
+	}
+	if plan.PolicyViolation != "" {
+		return plan.PolicyViolation, errors.New(plan.PolicyError)
+	}
+	return "", nil
+}
+
+// RequestTools returns the tool names accepted by Prepare
+func RequestTools() []string {
+	names := make([]string, 0, len(requestDecoders))
+	for name := range requestDecoders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func decodeRequest[T any](raw json.RawMessage, run func(context.Context, T) map[string]interface{}, validate func(T) error) (Runner, error) {
+	var req T
+	if len(raw) > 0 {
+		if err := json.Unmarshal(raw, &req); err != nil {
+			return nil, fmt.Errorf("invalid request: %w", err)
+		}
+	}
+	if validate != nil {
+		if err := validate(req); err != nil {
+			return nil, err
+		}
+	}
+	return func(ctx context.Context) map[string]interface{} {
+		return run(ctx, req)
+	}, nil
+}
+
+func required(field, value string) error {
+	if value == "" {
+		return fmt.Errorf("%s parameter is required", field)
+	}
+	return nil
+}
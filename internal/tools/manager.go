@@ -1,30 +1,41 @@
 package tools
 
 import (
+	"context"
 	"fmt"
-	"os"
 	"os/exec"
 	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
 	"sync"
+	"time"
 
 	"go.uber.org/zap"
 
+	"github.com/LeHTVy/h_ai/internal/cache"
 	"github.com/LeHTVy/h_ai/internal/executor"
 	"github.com/LeHTVy/h_ai/internal/models"
+	"github.com/LeHTVy/h_ai/internal/recovery"
+	"github.com/LeHTVy/h_ai/internal/utils"
 )
 
 type Manager struct {
 	logger      *zap.Logger
 	executor    *executor.Executor
+	recovery    *recovery.RecoveryManager
+	cacheTTLs   cache.TTLPolicy
 	toolCache   map[string]bool
 	cacheLock   sync.RWMutex
 	toolTimeout int // in seconds
 }
 
-func New(logger *zap.Logger, exec *executor.Executor) *Manager {
+func New(logger *zap.Logger, exec *executor.Executor, cacheTTLs cache.TTLPolicy) *Manager {
 	mgr := &Manager{
 		logger:      logger,
 		executor:    exec,
+		recovery:    recovery.New(logger),
+		cacheTTLs:   cacheTTLs,
 		toolCache:   make(map[string]bool),
 		toolTimeout: 300,
 	}
@@ -46,11 +57,17 @@ func (m *Manager) CheckToolsAvailability() map[string]bool {
 	return result
 }
 
+// RefreshToolsAvailability checks again which tools are installed and returns the result
+func (m *Manager) RefreshToolsAvailability() map[string]bool {
+	m.checkToolsAvailability()
+	return m.CheckToolsAvailability()
+}
+
 func (m *Manager) checkToolsAvailability() {
 	tools := []string{
 		"nmap", "masscan", "rustscan", "gobuster", "feroxbuster",
 		"ffuf", "nuclei", "nikto", "sqlmap", "wpscan", "hydra",
-		"msfconsole", "msfvenom", "nxc", "amass", "subfinder",
+		"msfconsole", "msfvenom", "nxc", "amass", "subfinder", "autorecon",
 	}
 
 	m.cacheLock.Lock()
@@ -68,20 +85,61 @@ func (m *Manager) checkToolsAvailability() {
 }
 
 func (m *Manager) isToolAvailable(tool string) bool {
+	// Replayed tools need not be installed, only recorded
+	if m.executor.Mode() == executor.ExecReplay {
+		return m.executor.HasFixtures(tool)
+	}
 	_, err := exec.LookPath(tool)
 	return err == nil
 }
 
-func (m *Manager) buildCommand(tool string, args ...string) string {
-	cmd := tool
-	for _, arg := range args {
-		cmd += " " + arg
+func (m *Manager) buildCommand(tool string, args ...string) executor.CommandSpec {
+	return executor.CommandSpec{
+		Binary: tool,
+		Args:   args,
+	}
+}
+
+// appendAdditionalArgs tokenizes user supplied extra arguments and appends them to args.
+// The arguments are never handed to a shell, so quoting is the only syntax honoured.
+func appendAdditionalArgs(args []string, additionalArgs string) ([]string, error) {
+	extra, err := utils.SplitArgs(additionalArgs)
+	if err != nil {
+		return nil, fmt.Errorf("invalid additional_args: %w", err)
 	}
-	return cmd
+	return append(args, extra...), nil
+}
+
+// ExecuteCommand executes a raw command string through the shell
+func (m *Manager) ExecuteCommand(ctx context.Context, req models.CommandRequest) map[string]interface{} {
+	m.logger.Info("Executing command", zap.String("command", req.Command))
+
+	spec := executor.ShellCommand(req.Command)
+	// Record the program the shell runs rather than the shell itself
+	if words, err := utils.SplitArgs(req.Command); err == nil && len(words) > 0 {
+		spec.Tool = filepath.Base(words[0])
+	}
+	return m.run(ctx, spec, req.ExecutionOptions, req.UseCache)
+}
+
+// OpenSession starts an interactive terminal session, e.g. msfconsole
+func (m *Manager) OpenSession(ctx context.Context, req models.SessionRequest) (executor.SessionInfo, error) {
+	words, err := utils.SplitArgs(req.Command)
+	if err != nil {
+		return executor.SessionInfo{}, fmt.Errorf("invalid command: %w", err)
+	}
+	if len(words) == 0 {
+		return executor.SessionInfo{}, fmt.Errorf("command is required")
+	}
+
+	spec := m.buildCommand(words[0], words[1:]...)
+	m.logger.Info("Opening interactive session", zap.String("command", spec.String()))
+
+	return m.executor.OpenSession(ctx, spec, req.Cols, req.Rows)
 }
 
 // ExecuteNmap executes an Nmap scan
-func (m *Manager) ExecuteNmap(req models.NmapRequest) map[string]interface{} {
+func (m *Manager) ExecuteNmap(ctx context.Context, req models.NmapRequest) map[string]interface{} {
 	scanType := req.ScanType
 	if scanType == "" {
 		scanType = "-sCV"
@@ -91,20 +149,21 @@ func (m *Manager) ExecuteNmap(req models.NmapRequest) map[string]interface{} {
 	if req.Ports != "" {
 		args = append(args, "-p", req.Ports)
 	}
-	if req.AdditionalArgs != "" {
-		args = append(args, req.AdditionalArgs)
+	args, err := appendAdditionalArgs(args, req.AdditionalArgs)
+	if err != nil {
+		return m.errorResult(err)
 	}
 	args = append(args, req.Target)
 
-	command := m.buildCommand("nmap", args...)
+	spec := m.buildCommand("nmap", args...)
+	spec.Target = req.Target
 	m.logger.Info("Executing Nmap scan", zap.String("target", req.Target))
 
-	result := m.executor.Execute(command, true)
-	return m.formatResult(result)
+	return m.run(ctx, spec, req.ExecutionOptions, true)
 }
 
 // ExecuteNmapAdvanced executes an advanced Nmap scan
-func (m *Manager) ExecuteNmapAdvanced(req models.NmapAdvancedRequest) map[string]interface{} {
+func (m *Manager) ExecuteNmapAdvanced(ctx context.Context, req models.NmapAdvancedRequest) map[string]interface{} {
 	scanType := req.ScanType
 	if scanType == "" {
 		scanType = "-sS"
@@ -137,45 +196,72 @@ func (m *Manager) ExecuteNmapAdvanced(req models.NmapAdvancedRequest) map[string
 	} else if !req.Aggressive {
 		args = append(args, "--script=default,discovery,safe")
 	}
-	if req.AdditionalArgs != "" {
-		args = append(args, req.AdditionalArgs)
+	args, err := appendAdditionalArgs(args, req.AdditionalArgs)
+	if err != nil {
+		return m.errorResult(err)
 	}
 
-	command := m.buildCommand("nmap", args...)
+	spec := m.buildCommand("nmap", args...)
+	spec.Target = req.Target
 	m.logger.Info("Executing Advanced Nmap scan", zap.String("target", req.Target))
 
-	result := m.executor.Execute(command, true)
-	return m.formatResult(result)
+	return m.run(ctx, spec, req.ExecutionOptions, true)
 }
 
 // ExecuteMetasploit executes a Metasploit module
-func (m *Manager) ExecuteMetasploit(req models.MetasploitRequest) map[string]interface{} {
-	// Create resource script
-	resourceContent := fmt.Sprintf("use %s\n", req.Module)
-	for key, value := range req.Options {
-		resourceContent += fmt.Sprintf("set %s %s\n", key, value)
+func (m *Manager) ExecuteMetasploit(ctx context.Context, req models.MetasploitRequest) map[string]interface{} {
+	resourceContent, err := resourceScript(req.Module, req.Options)
+	if err != nil {
+		return m.errorResult(err)
 	}
-	resourceContent += "exploit\n"
 
-	// Save to temporary file
-	resourceFile := filepath.Join(os.TempDir(), "h_ai_msf_resource.rc")
-	if err := os.WriteFile(resourceFile, []byte(resourceContent), 0644); err != nil {
-		return map[string]interface{}{
-			"success": false,
-			"error":   fmt.Sprintf("Failed to create resource file: %v", err),
-		}
+	// The script is read from stdin ("-r -"), so concurrent runs and sandboxed runs,
+	// which have a private /tmp, need no shared file
+	spec := m.buildCommand("msfconsole", "-q", "-r", "-")
+	spec.Stdin = resourceContent
+	spec.Target = req.Options["RHOSTS"]
+	m.logger.Info("Executing Metasploit module", zap.String("module", req.Module))
+
+	result := m.run(ctx, spec, req.ExecutionOptions, false)
+	if req.DryRun {
+		result["resource_script"] = resourceContent
 	}
-	defer os.Remove(resourceFile)
+	return result
+}
 
-	command := fmt.Sprintf("msfconsole -q -r %s", resourceFile)
-	m.logger.Info("Executing Metasploit module", zap.String("module", req.Module))
+var (
+	msfModulePattern = regexp.MustCompile(`^[A-Za-z0-9_./-]+$`)
+	msfOptionPattern = regexp.MustCompile(`^[A-Za-z0-9_]+$`)
+)
 
-	result := m.executor.Execute(command, false)
-	return m.formatResult(result)
+// resourceScript writes the msfconsole script running module with options. Each
+// line of the script is a console command, so nothing may start another one.
+func resourceScript(module string, options map[string]string) (string, error) {
+	if !msfModulePattern.MatchString(module) {
+		return "", fmt.Errorf("invalid module %q", module)
+	}
+
+	keys := make([]string, 0, len(options))
+	for key, value := range options {
+		if !msfOptionPattern.MatchString(key) {
+			return "", fmt.Errorf("invalid option name %q", key)
+		}
+		if strings.ContainsAny(value, "\r\n;") {
+			return "", fmt.Errorf("invalid value for option %s: line breaks and ';' are not allowed", key)
+		}
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	script := fmt.Sprintf("use %s\n", module)
+	for _, key := range keys {
+		script += fmt.Sprintf("set %s %s\n", key, options[key])
+	}
+	return script + "exploit\n", nil
 }
 
 // ExecuteGobuster executes a Gobuster scan
-func (m *Manager) ExecuteGobuster(req models.GobusterRequest) map[string]interface{} {
+func (m *Manager) ExecuteGobuster(ctx context.Context, req models.GobusterRequest) map[string]interface{} {
 	mode := req.Mode
 	if mode == "" {
 		mode = "dir"
@@ -187,19 +273,20 @@ func (m *Manager) ExecuteGobuster(req models.GobusterRequest) map[string]interfa
 	}
 
 	args := []string{mode, "-u", req.URL, "-w", wordlist}
-	if req.AdditionalArgs != "" {
-		args = append(args, req.AdditionalArgs)
+	args, err := appendAdditionalArgs(args, req.AdditionalArgs)
+	if err != nil {
+		return m.errorResult(err)
 	}
 
-	command := m.buildCommand("gobuster", args...)
+	spec := m.buildCommand("gobuster", args...)
+	spec.Target = req.URL
 	m.logger.Info("Executing Gobuster scan", zap.String("url", req.URL))
 
-	result := m.executor.Execute(command, true)
-	return m.formatResult(result)
+	return m.run(ctx, spec, req.ExecutionOptions, true)
 }
 
 // ExecuteNuclei executes a Nuclei scan
-func (m *Manager) ExecuteNuclei(req models.NucleiRequest) map[string]interface{} {
+func (m *Manager) ExecuteNuclei(ctx context.Context, req models.NucleiRequest) map[string]interface{} {
 	args := []string{"-u", req.Target}
 	if req.Templates != "" {
 		args = append(args, "-t", req.Templates)
@@ -207,19 +294,20 @@ func (m *Manager) ExecuteNuclei(req models.NucleiRequest) map[string]interface{}
 	if req.Severity != "" {
 		args = append(args, "-severity", req.Severity)
 	}
-	if req.AdditionalArgs != "" {
-		args = append(args, req.AdditionalArgs)
+	args, err := appendAdditionalArgs(args, req.AdditionalArgs)
+	if err != nil {
+		return m.errorResult(err)
 	}
 
-	command := m.buildCommand("nuclei", args...)
+	spec := m.buildCommand("nuclei", args...)
+	spec.Target = req.Target
 	m.logger.Info("Executing Nuclei scan", zap.String("target", req.Target))
 
-	result := m.executor.Execute(command, true)
-	return m.formatResult(result)
+	return m.run(ctx, spec, req.ExecutionOptions, true)
 }
 
 // ExecuteSqlmap executes a SQLMap scan
-func (m *Manager) ExecuteSqlmap(req models.SqlmapRequest) map[string]interface{} {
+func (m *Manager) ExecuteSqlmap(ctx context.Context, req models.SqlmapRequest) map[string]interface{} {
 	args := []string{"-u", req.URL}
 	if req.Data != "" {
 		args = append(args, "--data", req.Data)
@@ -227,19 +315,20 @@ func (m *Manager) ExecuteSqlmap(req models.SqlmapRequest) map[string]interface{}
 	if req.Cookies != "" {
 		args = append(args, "--cookie", req.Cookies)
 	}
-	if req.AdditionalArgs != "" {
-		args = append(args, req.AdditionalArgs)
+	args, err := appendAdditionalArgs(args, req.AdditionalArgs)
+	if err != nil {
+		return m.errorResult(err)
 	}
 
-	command := m.buildCommand("sqlmap", args...)
+	spec := m.buildCommand("sqlmap", args...)
+	spec.Target = req.URL
 	m.logger.Info("Executing SQLMap scan", zap.String("url", req.URL))
 
-	result := m.executor.Execute(command, true)
-	return m.formatResult(result)
+	return m.run(ctx, spec, req.ExecutionOptions, true)
 }
 
 // ExecuteHydra executes a Hydra brute force attack
-func (m *Manager) ExecuteHydra(req models.HydraRequest) map[string]interface{} {
+func (m *Manager) ExecuteHydra(ctx context.Context, req models.HydraRequest) map[string]interface{} {
 	args := []string{}
 	if req.Username != "" {
 		args = append(args, "-l", req.Username)
@@ -250,19 +339,20 @@ func (m *Manager) ExecuteHydra(req models.HydraRequest) map[string]interface{} {
 		args = append(args, "-P", req.PasswordList)
 	}
 	args = append(args, req.Target, req.Service)
-	if req.AdditionalArgs != "" {
-		args = append(args, req.AdditionalArgs)
+	args, err := appendAdditionalArgs(args, req.AdditionalArgs)
+	if err != nil {
+		return m.errorResult(err)
 	}
 
-	command := m.buildCommand("hydra", args...)
+	spec := m.buildCommand("hydra", args...)
+	spec.Target = req.Target
 	m.logger.Info("Executing Hydra attack", zap.String("target", req.Target))
 
-	result := m.executor.Execute(command, false) // Don't cache brute force results
-	return m.formatResult(result)
+	return m.run(ctx, spec, req.ExecutionOptions, false) // Don't cache brute force results
 }
 
 // ExecuteFFuf executes an FFuf fuzzing scan
-func (m *Manager) ExecuteFFuf(req models.FFufRequest) map[string]interface{} {
+func (m *Manager) ExecuteFFuf(ctx context.Context, req models.FFufRequest) map[string]interface{} {
 	wordlist := req.Wordlist
 	if wordlist == "" {
 		wordlist = "/usr/share/wordlists/dirb/common.txt"
@@ -274,19 +364,20 @@ func (m *Manager) ExecuteFFuf(req models.FFufRequest) map[string]interface{} {
 			args = append(args, "-H", fmt.Sprintf("%s: %s", key, value))
 		}
 	}
-	if req.AdditionalArgs != "" {
-		args = append(args, req.AdditionalArgs)
+	args, err := appendAdditionalArgs(args, req.AdditionalArgs)
+	if err != nil {
+		return m.errorResult(err)
 	}
 
-	command := m.buildCommand("ffuf", args...)
+	spec := m.buildCommand("ffuf", args...)
+	spec.Target = req.URL
 	m.logger.Info("Executing FFuf scan", zap.String("url", req.URL))
 
-	result := m.executor.Execute(command, true)
-	return m.formatResult(result)
+	return m.run(ctx, spec, req.ExecutionOptions, true)
 }
 
 // ExecuteNetexec executes a NetExec scan
-func (m *Manager) ExecuteNetexec(req models.NetexecRequest) map[string]interface{} {
+func (m *Manager) ExecuteNetexec(ctx context.Context, req models.NetexecRequest) map[string]interface{} {
 	protocol := req.Protocol
 	if protocol == "" {
 		protocol = "smb"
@@ -305,33 +396,35 @@ func (m *Manager) ExecuteNetexec(req models.NetexecRequest) map[string]interface
 	if req.Module != "" {
 		args = append(args, "-M", req.Module)
 	}
-	if req.AdditionalArgs != "" {
-		args = append(args, req.AdditionalArgs)
+	args, err := appendAdditionalArgs(args, req.AdditionalArgs)
+	if err != nil {
+		return m.errorResult(err)
 	}
 
-	command := m.buildCommand("nxc", args...)
+	spec := m.buildCommand("nxc", args...)
+	spec.Target = req.Target
 	m.logger.Info("Executing NetExec scan", zap.String("target", req.Target))
 
-	result := m.executor.Execute(command, true)
-	return m.formatResult(result)
+	return m.run(ctx, spec, req.ExecutionOptions, true)
 }
 
 // ExecuteAmass executes an Amass enumeration
-func (m *Manager) ExecuteAmass(req models.AmassRequest) map[string]interface{} {
+func (m *Manager) ExecuteAmass(ctx context.Context, req models.AmassRequest) map[string]interface{} {
 	args := []string{"enum", "-d", req.Domain}
-	if req.AdditionalArgs != "" {
-		args = append(args, req.AdditionalArgs)
+	args, err := appendAdditionalArgs(args, req.AdditionalArgs)
+	if err != nil {
+		return m.errorResult(err)
 	}
 
-	command := m.buildCommand("amass", args...)
+	spec := m.buildCommand("amass", args...)
+	spec.Target = req.Domain
 	m.logger.Info("Executing Amass enumeration", zap.String("domain", req.Domain))
 
-	result := m.executor.Execute(command, true)
-	return m.formatResult(result)
+	return m.run(ctx, spec, req.ExecutionOptions, true)
 }
 
 // ExecuteMasscan executes a Masscan scan
-func (m *Manager) ExecuteMasscan(req models.MasscanRequest) map[string]interface{} {
+func (m *Manager) ExecuteMasscan(ctx context.Context, req models.MasscanRequest) map[string]interface{} {
 	ports := req.Ports
 	if ports == "" {
 		ports = "1-65535"
@@ -343,33 +436,35 @@ func (m *Manager) ExecuteMasscan(req models.MasscanRequest) map[string]interface
 	}
 
 	args := []string{"-p", ports, "--rate", rate, req.Target}
-	if req.AdditionalArgs != "" {
-		args = append(args, req.AdditionalArgs)
+	args, err := appendAdditionalArgs(args, req.AdditionalArgs)
+	if err != nil {
+		return m.errorResult(err)
 	}
 
-	command := m.buildCommand("masscan", args...)
+	spec := m.buildCommand("masscan", args...)
+	spec.Target = req.Target
 	m.logger.Info("Executing Masscan scan", zap.String("target", req.Target))
 
-	result := m.executor.Execute(command, true)
-	return m.formatResult(result)
+	return m.run(ctx, spec, req.ExecutionOptions, true)
 }
 
 // ExecuteAutoRecon executes an AutoRecon scan
-func (m *Manager) ExecuteAutoRecon(req models.AutoReconRequest) map[string]interface{} {
+func (m *Manager) ExecuteAutoRecon(ctx context.Context, req models.AutoReconRequest) map[string]interface{} {
 	args := []string{req.Target}
-	if req.AdditionalArgs != "" {
-		args = append(args, req.AdditionalArgs)
+	args, err := appendAdditionalArgs(args, req.AdditionalArgs)
+	if err != nil {
+		return m.errorResult(err)
 	}
 
-	command := m.buildCommand("autorecon", args...)
+	spec := m.buildCommand("autorecon", args...)
+	spec.Target = req.Target
 	m.logger.Info("Executing AutoRecon scan", zap.String("target", req.Target))
 
-	result := m.executor.Execute(command, true)
-	return m.formatResult(result)
+	return m.run(ctx, spec, req.ExecutionOptions, true)
 }
 
 // ExecuteMSFVenom executes MSFVenom for payload generation
-func (m *Manager) ExecuteMSFVenom(req models.MSFVenomRequest) map[string]interface{} {
+func (m *Manager) ExecuteMSFVenom(ctx context.Context, req models.MSFVenomRequest) map[string]interface{} {
 	args := []string{"-p", req.Payload}
 	if req.Format != "" {
 		args = append(args, "-f", req.Format)
@@ -383,24 +478,80 @@ func (m *Manager) ExecuteMSFVenom(req models.MSFVenomRequest) map[string]interfa
 	if req.Iterations != "" {
 		args = append(args, "-i", req.Iterations)
 	}
-	if req.AdditionalArgs != "" {
-		args = append(args, req.AdditionalArgs)
+	args, err := appendAdditionalArgs(args, req.AdditionalArgs)
+	if err != nil {
+		return m.errorResult(err)
 	}
 
-	command := m.buildCommand("msfvenom", args...)
+	spec := m.buildCommand("msfvenom", args...)
 	m.logger.Info("Executing MSFVenom", zap.String("payload", req.Payload))
 
-	result := m.executor.Execute(command, false) // Don't cache payload generation
+	return m.run(ctx, spec, req.ExecutionOptions, false) // Don't cache payload generation
+}
+
+// run executes spec with the per-request execution options applied
+func (m *Manager) run(ctx context.Context, spec executor.CommandSpec, opts models.ExecutionOptions, useCache bool) map[string]interface{} {
+	spec.Timeout = time.Duration(m.toolTimeout) * time.Second
+	if opts.Timeout > 0 {
+		spec.Timeout = time.Duration(opts.Timeout) * time.Second
+	}
+
+	if useCache {
+		spec.CacheKey = m.cacheKey(spec).String()
+		spec.CacheTTL = m.cacheTTL(spec)
+	}
+
+	if opts.DryRun {
+		m.logger.Info("Dry run", zap.String("command", spec.String()))
+		return map[string]interface{}{
+			"success": true,
+			"dry_run": true,
+			"plan":    m.executor.Plan(ctx, spec, useCache),
+		}
+	}
+
+	if opts.UseRecovery {
+		return m.runWithRecovery(ctx, spec, useCache)
+	}
+
+	result := m.executor.ExecuteSpec(ctx, spec, useCache)
 	return m.formatResult(result)
 }
 
+// errorResult is the result of a request rejected before anything ran
+func (m *Manager) errorResult(err error) map[string]interface{} {
+	return map[string]interface{}{
+		"success":        false,
+		"error":          err.Error(),
+		"failure_reason": executor.FailureInvalidRequest,
+	}
+}
+
 func (m *Manager) formatResult(result executor.ExecutionResult) map[string]interface{} {
 	return map[string]interface{}{
-		"success":        result.Success,
-		"stdout":         result.Stdout,
-		"stderr":         result.Stderr,
-		"return_code":    result.ReturnCode,
-		"execution_time": result.ExecutionTime,
-		"pid":            result.PID,
+		"success":           result.Success,
+		"stdout":            result.Stdout,
+		"stderr":            result.Stderr,
+		"return_code":       result.ReturnCode,
+		"execution_time":    result.ExecutionTime,
+		"pid":               result.PID,
+		"timed_out":         result.TimedOut,
+		"cancelled":         result.Cancelled,
+		"execution_id":      result.ExecutionID,
+		"stdout_bytes":      result.StdoutBytes,
+		"stderr_bytes":      result.StderrBytes,
+		"stdout_truncated":  result.StdoutTruncated,
+		"stderr_truncated":  result.StderrTruncated,
+		"artifact_id":       result.ArtifactID,
+		"queue_wait":        result.QueueWait,
+		"queue_rejected":    result.QueueRejected,
+		"limit_breaches":    result.LimitBreaches,
+		"policy_violation":  result.PolicyViolation,
+		"sandboxed":         result.Sandboxed,
+		"recording":         result.Recording,
+		"failure_reason":    result.FailureReason,
+		"signal":            result.Signal,
+		"output_incomplete": result.OutputIncomplete,
+		"cached":            result.Cached,
 	}
 }
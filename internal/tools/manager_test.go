@@ -0,0 +1,45 @@
+package tools
+
+import "testing"
+
+func TestResourceScript(t *testing.T) {
+	tests := []struct {
+		name    string
+		module  string
+		options map[string]string
+		want    string
+		wantErr bool
+	}{
+		{
+			name:    "options are sorted",
+			module:  "exploit/windows/smb/ms17_010_eternalblue",
+			options: map[string]string{"RHOSTS": "10.0.0.1", "LPORT": "4444"},
+			want:    "use exploit/windows/smb/ms17_010_eternalblue\nset LPORT 4444\nset RHOSTS 10.0.0.1\nexploit\n",
+		},
+		{
+			name:    "values may hold spaces",
+			module:  "auxiliary/scanner/http/title",
+			options: map[string]string{"USER_AGENT": "Mozilla/5.0 (X11)"},
+			want:    "use auxiliary/scanner/http/title\nset USER_AGENT Mozilla/5.0 (X11)\nexploit\n",
+		},
+		{name: "newline in value", module: "auxiliary/x", options: map[string]string{"RHOSTS": "x\n!rm -rf /"}, wantErr: true},
+		{name: "carriage return in value", module: "auxiliary/x", options: map[string]string{"RHOSTS": "x\rirb"}, wantErr: true},
+		{name: "semicolon in value", module: "auxiliary/x", options: map[string]string{"RHOSTS": "x; irb"}, wantErr: true},
+		{name: "newline in name", module: "auxiliary/x", options: map[string]string{"A\nirb": "x"}, wantErr: true},
+		{name: "space in name", module: "auxiliary/x", options: map[string]string{"A B": "x"}, wantErr: true},
+		{name: "newline in module", module: "auxiliary/x\nirb", wantErr: true},
+		{name: "empty module", module: "", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := resourceScript(tt.module, tt.options)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("resourceScript error = %v, want error %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("resourceScript = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
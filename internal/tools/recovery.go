@@ -0,0 +1,161 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/LeHTVy/h_ai/internal/executor"
+	"github.com/LeHTVy/h_ai/internal/recovery"
+)
+
+// Recovery statuses reported in the "recovery" field of a result
+const (
+	RecoverySucceeded = "succeeded" // the first attempt succeeded
+	RecoveryRecovered = "recovered" // a retry succeeded
+	RecoveryEscalated = "escalated" // every attempt failed, a human needs to look at it
+	RecoveryAborted   = "aborted"   // stopped early, retrying could not help
+)
+
+// recoveryAttempt describes one execution of a request run with recovery
+type recoveryAttempt struct {
+	Attempt       int     `json:"attempt"`
+	Success       bool    `json:"success"`
+	ReturnCode    int     `json:"return_code"`
+	ExecutionID   string  `json:"execution_id,omitempty"`
+	ExecutionTime float64 `json:"execution_time"`
+	TimedOut      bool    `json:"timed_out,omitempty"`
+	// FailureReason and Error say why the attempt failed
+	FailureReason executor.FailureReason `json:"failure_reason,omitempty"`
+	Error         string                 `json:"error,omitempty"`
+	// Backoff is how long was waited before the next attempt, in seconds
+	Backoff float64 `json:"backoff,omitempty"`
+}
+
+// recoveryReport is the "recovery" field of a result
+type recoveryReport struct {
+	Status   string                  `json:"status"`
+	Action   recovery.RecoveryAction `json:"action,omitempty"`
+	Attempts []recoveryAttempt       `json:"attempts"`
+	Error    string                  `json:"error,omitempty"`
+}
+
+// runWithRecovery executes spec, asking the recovery manager what to do after every
+// failure and retrying with its backoff until an attempt succeeds or it escalates
+func (m *Manager) runWithRecovery(ctx context.Context, spec executor.CommandSpec, useCache bool) map[string]interface{} {
+	tool := spec.ToolName()
+	report := recoveryReport{Status: RecoverySucceeded}
+
+	for attempt := 1; ; attempt++ {
+		result := m.executor.ExecuteSpec(ctx, spec, useCache)
+		report.Attempts = append(report.Attempts, recoveryAttempt{
+			Attempt:       attempt,
+			Success:       result.Success,
+			ReturnCode:    result.ReturnCode,
+			ExecutionID:   result.ExecutionID,
+			ExecutionTime: result.ExecutionTime,
+			TimedOut:      result.TimedOut,
+			FailureReason: result.FailureReason,
+			Error:         failureMessage(result),
+		})
+		if result.Success {
+			if attempt > 1 {
+				report.Status = RecoveryRecovered
+				m.logger.Info("Recovered after retrying",
+					zap.String("tool", tool), zap.Int("attempts", attempt))
+			}
+			return m.withRecovery(result, report)
+		}
+
+		if !retryable(result) || ctx.Err() != nil {
+			report.Status = RecoveryAborted
+			report.Action = recovery.AbortOperation
+			return m.withRecovery(result, report)
+		}
+
+		strategy, err := m.recovery.HandleError(tool, failureMessage(result), attempt)
+		if err != nil {
+			report.Status = RecoveryEscalated
+			report.Action = strategy.Action
+			report.Error = err.Error()
+			m.logger.Warn("Recovery escalated",
+				zap.String("tool", tool),
+				zap.String("command", spec.String()),
+				zap.Int("attempts", attempt),
+				zap.Error(err))
+			return m.withRecovery(result, report)
+		}
+		report.Action = strategy.Action
+		if strategy.Action != recovery.RetryWithBackoff {
+			// Only retries can be carried out without changing the request
+			report.Status = RecoveryEscalated
+			report.Error = fmt.Sprintf("recovery action %s needs a human", strategy.Action)
+			return m.withRecovery(result, report)
+		}
+
+		backoff := m.recovery.CalculateBackoff(attempt, strategy)
+		report.Attempts[len(report.Attempts)-1].Backoff = backoff.Seconds()
+		m.logger.Info("Retrying failed execution",
+			zap.String("tool", tool),
+			zap.Int("attempt", attempt),
+			zap.Duration("backoff", backoff))
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			report.Status = RecoveryAborted
+			report.Action = recovery.AbortOperation
+			report.Error = "cancelled while waiting to retry"
+			result.Cancelled = true
+			result.FailureReason = executor.FailureCancelled
+			return m.withRecovery(result, report)
+		case <-timer.C:
+		}
+	}
+}
+
+// retryable reports whether a failed execution may succeed when run again. Missing
+// tools, denied or cancelled executions and tools outgrowing their resource limits fail
+// the same way however often they run.
+func retryable(result executor.ExecutionResult) bool {
+	switch result.FailureReason {
+	case executor.FailureNotFound, executor.FailureSpawnError,
+		executor.FailurePolicyDenied, executor.FailureCancelled, executor.FailureInvalidRequest,
+		executor.FailureResourceLimit:
+		return false
+	case executor.FailureSignaled:
+		// Nothing but the OOM killer sends SIGKILL outside timeouts and cancellation,
+		// it did so because the tool needs more memory than it can get
+		return result.Signal != "SIGKILL"
+	default:
+		return true
+	}
+}
+
+func (m *Manager) withRecovery(result executor.ExecutionResult, report recoveryReport) map[string]interface{} {
+	formatted := m.formatResult(result)
+	formatted["recovery"] = report
+	return formatted
+}
+
+// failureMessage summarises why an execution failed, empty when it succeeded
+func failureMessage(result executor.ExecutionResult) string {
+	switch {
+	case result.Success:
+		return ""
+	case result.TimedOut:
+		return "timed out"
+	case result.Cancelled:
+		return "cancelled"
+	}
+	// The last line of stderr usually says what went wrong
+	if stderr := strings.TrimSpace(result.Stderr); stderr != "" {
+		lines := strings.Split(stderr, "\n")
+		return strings.TrimSpace(lines[len(lines)-1])
+	}
+	return fmt.Sprintf("exit code %d", result.ReturnCode)
+}
@@ -0,0 +1,34 @@
+package tools
+
+import (
+	"testing"
+
+	"github.com/LeHTVy/h_ai/internal/executor"
+)
+
+func TestRetryable(t *testing.T) {
+	tests := []struct {
+		name   string
+		result executor.ExecutionResult
+		want   bool
+	}{
+		{"nonzero exit", executor.ExecutionResult{FailureReason: executor.FailureNonzeroExit}, true},
+		{"timeout", executor.ExecutionResult{FailureReason: executor.FailureTimeout}, true},
+		{"queue full", executor.ExecutionResult{FailureReason: executor.FailureQueueRejected}, true},
+		{"terminated", executor.ExecutionResult{FailureReason: executor.FailureSignaled, Signal: "SIGTERM"}, true},
+		{"segfault", executor.ExecutionResult{FailureReason: executor.FailureSignaled, Signal: "SIGSEGV"}, true},
+		{"OOM killed", executor.ExecutionResult{FailureReason: executor.FailureSignaled, Signal: "SIGKILL"}, false},
+		{"resource limit", executor.ExecutionResult{FailureReason: executor.FailureResourceLimit, Signal: "SIGXCPU"}, false},
+		{"not found", executor.ExecutionResult{FailureReason: executor.FailureNotFound}, false},
+		{"spawn error", executor.ExecutionResult{FailureReason: executor.FailureSpawnError}, false},
+		{"policy denied", executor.ExecutionResult{FailureReason: executor.FailurePolicyDenied}, false},
+		{"cancelled", executor.ExecutionResult{FailureReason: executor.FailureCancelled}, false},
+		{"invalid request", executor.ExecutionResult{FailureReason: executor.FailureInvalidRequest}, false},
+	}
+
+	for _, tt := range tests {
+		if got := retryable(tt.result); got != tt.want {
+			t.Errorf("retryable(%s) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package utils
+
+import (
+	"fmt"
+	"strings"
+)
+
+// SplitArgs splits s into arguments using POSIX shell quoting rules.
+// Only quoting and escaping are interpreted: operators such as ;, |, & or $()
+// are returned as plain words, so the result is safe to pass as argv.
+func SplitArgs(s string) ([]string, error) {
+	var (
+		args    []string
+		current strings.Builder
+		inWord  bool
+		quote   rune
+		escaped bool
+	)
+
+	for _, r := range s {
+		switch {
+		case escaped:
+			// Inside double quotes a backslash only escapes a few characters
+			if quote == '"' && !strings.ContainsRune("\"\\$`\n", r) {
+				current.WriteRune('\\')
+			}
+			if r != '\n' {
+				current.WriteRune(r)
+			}
+			escaped = false
+		case quote == '\'':
+			if r == '\'' {
+				quote = 0
+			} else {
+				current.WriteRune(r)
+			}
+		case quote == '"':
+			switch r {
+			case '"':
+				quote = 0
+			case '\\':
+				escaped = true
+			default:
+				current.WriteRune(r)
+			}
+		case r == '\\':
+			escaped = true
+			inWord = true
+		case r == '\'' || r == '"':
+			quote = r
+			inWord = true
+		case r == ' ' || r == '\t' || r == '\n' || r == '\r':
+			if inWord {
+				args = append(args, current.String())
+				current.Reset()
+				inWord = false
+			}
+		default:
+			current.WriteRune(r)
+			inWord = true
+		}
+	}
+
+	if escaped {
+		return nil, fmt.Errorf("trailing backslash in %q", s)
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated %c quote in %q", quote, s)
+	}
+	if inWord {
+		args = append(args, current.String())
+	}
+	return args, nil
+}
+
+// QuoteArg quotes arg so that SplitArgs (or a POSIX shell) reads it back as a single word
+func QuoteArg(arg string) string {
+	if arg == "" {
+		return "''"
+	}
+	if !strings.ContainsAny(arg, " \t\n\r'\"\\$`;&|<>()*?[]{}#~!") {
+		return arg
+	}
+	return "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+}
+
+// JoinArgs renders argv as a single shell-quoted command line, for display only
+func JoinArgs(argv []string) string {
+	quoted := make([]string, len(argv))
+	for i, arg := range argv {
+		quoted[i] = QuoteArg(arg)
+	}
+	return strings.Join(quoted, " ")
+}
@@ -1,29 +1,142 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
+	"github.com/LeHTVy/h_ai/internal/cache"
+	"github.com/LeHTVy/h_ai/internal/cluster"
+	"github.com/LeHTVy/h_ai/internal/executor"
+	"github.com/LeHTVy/h_ai/internal/policy"
 	"github.com/LeHTVy/h_ai/internal/server"
 	"go.uber.org/zap"
 )
 
 const (
-	defaultPort = 8888
-	defaultHost = "0.0.0.0"
+	defaultPort             = 8888
+	defaultHost             = "0.0.0.0"
+	defaultDataDir          = "data"
+	defaultMaxOutputBytes   = 1024 * 1024
+	defaultMaxConcurrent    = 8
+	defaultMaxQueue         = 64
+	defaultToolLimits       = "masscan=1"
+	defaultShutdownGrace    = 30 * time.Second
+	defaultProcessRetention = 10 * time.Minute
+	defaultCacheMaxBytes    = 256 * 1024 * 1024
+	defaultMaxSessions      = 8
 )
 
 func main() {
+	// Sandboxed tools are started through this binary, this never returns for them
+	executor.SandboxInit()
+	// So are tools whose resource limits are set before they run
+	executor.LimitsInit()
+	// Replayed executions are played back by this binary as well
+	executor.ReplayInit()
+
 	var (
-		port       = flag.Int("port", defaultPort, "Port for the API server")
-		host       = flag.String("host", defaultHost, "Host for the API server")
-		debug      = flag.Bool("debug", false, "Enable debug mode")
-		ollamaURL  = flag.String("ollama-url", "", "Ollama API URL (default: http://localhost:11434, optional)")
-		ollamaModel = flag.String("ollama-model", "", "Ollama model to use (optional, can be selected from UI)")
+		port          = flag.Int("port", defaultPort, "Port for the API server")
+		host          = flag.String("host", defaultHost, "Host for the API server")
+		debug         = flag.Bool("debug", false, "Enable debug mode")
+		ollamaURL     = flag.String("ollama-url", "", "Ollama API URL (default: http://localhost:11434, optional)")
+		ollamaModel   = flag.String("ollama-model", "", "Ollama model to use (optional, can be selected from UI)")
+		dataDir       = flag.String("data-dir", defaultDataDir, "Directory for output artifacts and server state")
+		maxOutput     = flag.Int("max-output-bytes", defaultMaxOutputBytes, "Max bytes of each output stream returned inline (full output goes to artifacts)")
+		maxConcurrent = flag.Int("max-concurrent", defaultMaxConcurrent, "Max tool processes running at once (0 = unlimited)")
+		maxQueue      = flag.Int("max-queue", defaultMaxQueue, "Max executions waiting for a slot before requests are rejected (0 = unlimited)")
+		maxSessions   = flag.Int("max-sessions", defaultMaxSessions, "Max interactive sessions running at once (0 = unlimited)")
+		toolLimits    = flag.String("tool-limits", defaultToolLimits, "Per-tool concurrency limits, e.g. masscan=1,nmap=4")
+		cacheBackend  = flag.String("cache-backend", "memory", "Where results are cached: memory, or bolt to keep them in <data-dir>/cache.db across restarts")
+		cacheMaxBytes = flag.Int64("cache-max-bytes", defaultCacheMaxBytes, "Max bytes of cached results, the least recently used are evicted beyond it (0 = unlimited)")
+		cacheTTLs     = flag.String("cache-ttl", "", "Per-tool cache TTLs overriding the defaults, e.g. amass=48h,nmap=15m,*=1h (* sets the default)")
+		limitsFile    = flag.String("resource-limits", "", "JSON file with per-tool CPU, memory, open file and process limits (optional)")
+		sandboxFile   = flag.String("sandbox", "", "JSON file enabling the Linux namespace sandbox: read-only paths and per-tool network access (optional)")
+		shutdownGrace = flag.Duration("shutdown-grace", defaultShutdownGrace, "How long running executions may finish on shutdown before they are terminated")
+		policyFile    = flag.String("policy", "", "JSON file with the execution policy: allowed binaries, denied patterns and roles (optional)")
+		clusterToken  = flag.String("cluster-token", "", "Shared secret of the coordinator and its worker nodes, required for workers to register")
+		workerMode    = flag.Bool("worker", false, "Run as a worker node executing tool requests pulled from --coordinator instead of serving the API")
+		coordinator   = flag.String("coordinator", "", "URL of the coordinating server in worker mode, e.g. http://10.0.0.1:8888")
+		workerName    = flag.String("worker-name", "", "Name of this worker node (default: hostname)")
+		workerSegment = flag.String("worker-segment", "", "Network segment this worker scans from, dispatches can target it (optional)")
+		workerTasks   = flag.Int("worker-capacity", 0, "Tasks this worker runs at once (default: --max-concurrent)")
+		execMode      = flag.String("exec-mode", "live", "live runs the tools, record also saves their output as fixtures, replay plays fixtures back without running anything")
+		fixtureDir    = flag.String("fixtures", "", "Directory of the recorded fixtures (default: <data-dir>/fixtures)")
+		retention     = flag.Duration("process-retention", defaultProcessRetention, "How long finished processes stay listed with their outcome under /api/processes (0 = drop them at once)")
 	)
 	flag.Parse()
 
+	limits, err := executor.ParseToolLimits(*toolLimits)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid --tool-limits: %v\n", err)
+		os.Exit(1)
+	}
+
+	if *cacheBackend != "memory" && *cacheBackend != "bolt" {
+		fmt.Fprintf(os.Stderr, "Invalid --cache-backend %q, expected memory or bolt\n", *cacheBackend)
+		os.Exit(1)
+	}
+
+	ttls, err := cache.ParseTTLs(*cacheTTLs)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid --cache-ttl: %v\n", err)
+		os.Exit(1)
+	}
+
+	var resourceLimits executor.LimitsConfig
+	if *limitsFile != "" {
+		if resourceLimits, err = executor.LoadLimitsConfig(*limitsFile); err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid --resource-limits: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	var sandbox *executor.SandboxConfig
+	if *sandboxFile != "" {
+		if sandbox, err = executor.LoadSandboxConfig(*sandboxFile); err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid --sandbox: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	mode, err := executor.ParseExecMode(*execMode)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid --exec-mode: %v\n", err)
+		os.Exit(1)
+	}
+
+	var execPolicy *policy.Engine
+	if *policyFile != "" {
+		if execPolicy, err = policy.Load(*policyFile); err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid --policy: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	if *workerMode {
+		if err := cluster.ValidateCoordinatorURL(*coordinator); err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid --coordinator: %v\n", err)
+			os.Exit(1)
+		}
+		if *clusterToken == "" {
+			fmt.Fprintln(os.Stderr, "Worker mode needs the coordinator's --cluster-token")
+			os.Exit(1)
+		}
+		if *workerName == "" {
+			if *workerName, err = os.Hostname(); err != nil {
+				fmt.Fprintf(os.Stderr, "Cannot determine hostname, set --worker-name: %v\n", err)
+				os.Exit(1)
+			}
+		}
+		if *workerTasks <= 0 {
+			*workerTasks = *maxConcurrent
+		}
+	}
+
 	// Initialize logger
 	logConfig := zap.NewDevelopmentConfig()
 	if !*debug {
@@ -37,13 +150,100 @@ func main() {
 	}
 	defer logger.Sync()
 
+	cfg := server.Config{
+		Host:           *host,
+		Port:           *port,
+		OllamaURL:      *ollamaURL,
+		OllamaModel:    *ollamaModel,
+		DataDir:        *dataDir,
+		MaxOutputBytes: *maxOutput,
+		Scheduler: executor.SchedulerConfig{
+			MaxConcurrent: *maxConcurrent,
+			MaxQueue:      *maxQueue,
+			ToolLimits:    limits,
+		},
+		Limits:           resourceLimits,
+		Policy:           execPolicy,
+		Sandbox:          sandbox,
+		ClusterToken:     *clusterToken,
+		ExecMode:         mode,
+		FixtureDir:       *fixtureDir,
+		ProcessRetention: *retention,
+		MaxSessions:      *maxSessions,
+		CacheTTLs:        cache.DefaultTTLPolicy().With(ttls),
+		CacheBackend:     *cacheBackend,
+		CacheMaxBytes:    *cacheMaxBytes,
+	}
+
+	if *workerMode {
+		runWorker(cfg, cluster.AgentConfig{
+			Coordinator: *coordinator,
+			Token:       *clusterToken,
+			Name:        *workerName,
+			Segment:     *workerSegment,
+			Capacity:    *workerTasks,
+		}, *shutdownGrace, logger)
+		return
+	}
+
 	// Print banner
 	printBanner(*port, *debug, *ollamaURL, *ollamaModel)
 
 	// Create and start server
-	srv := server.New(*host, *port, logger, *ollamaURL, *ollamaModel)
-	if err := srv.Start(); err != nil {
-		logger.Fatal("Failed to start server", zap.Error(err))
+	srv := server.New(cfg, logger)
+	go func() {
+		if err := srv.Start(); err != nil {
+			logger.Fatal("Failed to start server", zap.Error(err))
+		}
+	}()
+
+	// A second signal during the grace period kills the server right away
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	<-ctx.Done()
+	stop()
+
+	logger.Info("Shutting down", zap.Duration("grace_period", *shutdownGrace))
+	graceCtx, cancel := context.WithTimeout(context.Background(), *shutdownGrace)
+	defer cancel()
+
+	summary, err := srv.Shutdown(graceCtx)
+	logShutdown(logger, summary, err)
+}
+
+// runWorker runs this binary as a worker node until it is interrupted
+func runWorker(cfg server.Config, agentCfg cluster.AgentConfig, grace time.Duration, logger *zap.Logger) {
+	logger.Info("Starting worker node",
+		zap.String("coordinator", agentCfg.Coordinator),
+		zap.String("name", agentCfg.Name),
+		zap.String("segment", agentCfg.Segment),
+		zap.Int("capacity", agentCfg.Capacity))
+
+	worker := server.NewWorker(cfg, agentCfg, logger)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	worker.Run(ctx)
+	stop()
+
+	logger.Info("Shutting down", zap.Duration("grace_period", grace))
+	graceCtx, cancel := context.WithTimeout(context.Background(), grace)
+	defer cancel()
+
+	summary, err := worker.Shutdown(graceCtx)
+	logShutdown(logger, summary, err)
+}
+
+func logShutdown(logger *zap.Logger, summary server.ShutdownSummary, err error) {
+	commands := make([]string, 0, len(summary.TerminatedProcesses))
+	for _, proc := range summary.TerminatedProcesses {
+		commands = append(commands, proc.Command)
+	}
+	logger.Info("Shutdown complete",
+		zap.Bool("drained", summary.Drained),
+		zap.Strings("interrupted_jobs", summary.InterruptedJobs),
+		zap.Strings("interrupted_tasks", summary.InterruptedTasks),
+		zap.Strings("terminated_processes", commands))
+	if err != nil {
+		logger.Error("Shutdown finished with errors", zap.Error(err))
+		os.Exit(1)
 	}
 }
 
@@ -59,7 +259,7 @@ func printBanner(port int, debug bool, ollamaURL string, ollamaModel string) {
 	} else if ollamaModel != "" {
 		aiStatus = fmt.Sprintf("Model: %s (using default URL)", ollamaModel)
 	}
-	
+
 	banner := fmt.Sprintf(`
 ██╗  ██╗███████╗██╗  ██╗███████╗████████╗██████╗ ██╗██╗  ██╗███████╗
 ██║  ██║██╔════╝╚██╗██╔╝██╔════╝╚══██╔══╝██╔══██╗██║██║ ██╔╝██╔════╝